@@ -4,74 +4,335 @@ import (
 	"context"
 	"fmt"
 	"image"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
+	"cdimage/encoder"
+	"cdimage/presets"
 	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
 )
 
+// burnOptions holds the settings of the burn command
+type burnOptions struct {
+	InputFile  string
+	OutputFile string
+	DiscType   string
+	Tr0        float64
+	Dtr        float64
+	R0         float64
+	Dither     ditherOptions
+	Sampling   samplingOptions
+	Placement  placementOptions
+	Palette    string // Palette given as byte:reflectance pairs, empty for the preset's
+	Preset     string
+	Parallel   bool
+	Format     string
+	SplitCount int       // Number of tracks to split the output into
+	SplitRadii []float64 // Radii (mm) at which new tracks start
+}
+
+// ditherOptions holds the flags that select the dithering engine of burn
+// and preview
+type ditherOptions struct {
+	Engine    string
+	Seed      int64
+	Screen    float64
+	MixColors bool
+}
+
+// addDitherFlags registers the dithering flags of cmd
+func addDitherFlags(cmd *cobra.Command, opts *ditherOptions) {
+	cmd.Flags().StringVar(&opts.Engine, "dither", encoder.DitherOrdered, "Dithering engine: "+strings.Join(encoder.DitherEngines(), ", "))
+	cmd.Flags().Int64Var(&opts.Seed, "seed", 1, "Seed of the random, bayer, bluenoise, am and diffusion engines")
+	cmd.Flags().Float64Var(&opts.Screen, "screen-frequency", encoder.DefaultScreenFrequency, "Screen frequency of the am engine in lines per inch")
+	cmd.Flags().BoolVar(&opts.MixColors, "mix-colors", false, "Use random color mixing")
+	cmd.Flags().MarkDeprecated("mix-colors", "use --dither random")
+}
+
+// samplingOptions holds the flags that select how burn and preview read
+// the image between its pixels
+type samplingOptions struct {
+	Filter string
+	Area   bool
+}
+
+// addSamplingFlags registers the sampling flags of cmd
+func addSamplingFlags(cmd *cobra.Command, opts *samplingOptions) {
+	cmd.Flags().StringVar(&opts.Filter, "sampling", encoder.SamplingNearest, "Image sampling filter: "+strings.Join(encoder.SamplingFilters(), ", "))
+	cmd.Flags().BoolVar(&opts.Area, "area-filter", false, "Average the image over the track pitch and sample spacing of every sample")
+}
+
+// filter returns the name of the selected sampling filter, after checking
+// that it exists
+func (opts samplingOptions) filter() (string, error) {
+	filter := strings.ToLower(opts.Filter)
+	for _, name := range encoder.SamplingFilters() {
+		if filter == name {
+			return filter, nil
+		}
+	}
+	return "", fmt.Errorf("unknown sampling filter '%s' (must be one of: %s)", opts.Filter, strings.Join(encoder.SamplingFilters(), ", "))
+}
+
+// String describes the filter and the prefilter
+func (opts samplingOptions) String() string {
+	filter, _ := opts.filter()
+	if opts.Area {
+		return filter + " with area prefilter"
+	}
+	return filter
+}
+
+// placementOptions holds the flags that sample the source image directly,
+// placed on the disc, instead of the disc raster of CreateDiscImage
+type placementOptions struct {
+	Direct     bool
+	Scale      float64
+	OffsetX    float64
+	OffsetY    float64
+	Rotation   float64
+	Resolution int // Longest side of the reduced source, 0 for the default of the disc type
+}
+
+// addPlacementFlags registers the direct sampling flags of cmd
+func addPlacementFlags(cmd *cobra.Command, opts *placementOptions) {
+	cmd.Flags().BoolVar(&opts.Direct, "direct", false, "Sample the source image directly instead of a 3000x3000 disc raster")
+	cmd.Flags().Float64Var(&opts.Scale, "scale", 1, "Size of the image relative to the default fit (direct sampling)")
+	cmd.Flags().Float64Var(&opts.OffsetX, "offset-x", 0, "Move the image right by this many mm (direct sampling)")
+	cmd.Flags().Float64Var(&opts.OffsetY, "offset-y", 0, "Move the image down by this many mm (direct sampling)")
+	cmd.Flags().Float64Var(&opts.Rotation, "rotate", 0, "Rotate the image clockwise by this many degrees (direct sampling)")
+	cmd.Flags().IntVar(&opts.Resolution, "resolution", 0, fmt.Sprintf("Longest side in pixels the source is reduced to for direct sampling (0 for %d on CD, %d on DVD)", encoder.CDResolution, encoder.DVDResolution))
+}
+
+// placement returns the placement of the source image, nil without
+// --direct, after checking the flags
+func (opts placementOptions) placement() (*encoder.Placement, error) {
+	if !opts.Direct {
+		if opts.Scale != 1 || opts.OffsetX != 0 || opts.OffsetY != 0 || opts.Rotation != 0 || opts.Resolution != 0 {
+			return nil, fmt.Errorf("--scale, --offset-x, --offset-y, --rotate and --resolution need --direct")
+		}
+		return nil, nil
+	}
+	if opts.Scale <= 0 {
+		return nil, fmt.Errorf("scale must be > 0")
+	}
+	if opts.Resolution < 0 {
+		return nil, fmt.Errorf("resolution must be >= 0")
+	}
+	return &encoder.Placement{
+		Scale:    opts.Scale,
+		OffsetX:  opts.OffsetX,
+		OffsetY:  opts.OffsetY,
+		Rotation: opts.Rotation,
+	}, nil
+}
+
+// resolution returns the intermediate resolution of the source for
+// discType
+func (opts placementOptions) resolution(discType string) int {
+	if opts.Resolution > 0 {
+		return opts.Resolution
+	}
+	return encoder.DefaultResolution(discType)
+}
+
+// loadImage loads the image to convert for discType: the source reduced to
+// the intermediate resolution for direct sampling, else the disc raster of
+// CreateDiscImage. Status messages go to msg.
+func (opts placementOptions) loadImage(filename, discType string, msg io.Writer) (image.Image, error) {
+	fmt.Fprintf(msg, "Loading image: %s\n", filename)
+	if !opts.Direct {
+		img, err := encoder.LoadImage(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load image: %w", err)
+		}
+		return encoder.CreateDiscImage(img, discType), nil
+	}
+
+	src, err := encoder.LoadSourceImage(filename, opts.resolution(discType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	fmt.Fprintf(msg, "Direct sampling: %dx%d source at %dx%d, scale %g, offset %g/%g mm, rotated %g°\n",
+		src.Width, src.Height, src.Rect.Dx(), src.Rect.Dy(), opts.Scale, opts.OffsetX, opts.OffsetY, opts.Rotation)
+	return src, nil
+}
+
+// addPaletteFlag registers the --palette flag of cmd
+func addPaletteFlag(cmd *cobra.Command, spec *string) {
+	cmd.Flags().StringVar(spec, "palette", "", "Track bytes and their measured reflectance as hex:value pairs, e.g. 10:0,21:1,28:2,AA:3 (use preset if empty)")
+}
+
+// resolvePalette returns the palette given by the --palette flag, or the
+// one of the preset if spec is empty
+func resolvePalette(spec string, preset presets.DiscPreset) (*encoder.Palette, error) {
+	if spec == "" {
+		palette, err := preset.GetPalette()
+		if err != nil {
+			return nil, fmt.Errorf("invalid palette in preset '%s': %w", preset.Name, err)
+		}
+		return palette, nil
+	}
+	palette, err := encoder.ParsePalette(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid palette: %w", err)
+	}
+	return palette, nil
+}
+
+// engine returns the name of the selected dithering engine, after checking
+// that it exists
+func (opts ditherOptions) engine() (string, error) {
+	engine := strings.ToLower(opts.Engine)
+	if opts.MixColors {
+		if engine != encoder.DitherOrdered && engine != encoder.DitherRandom {
+			return "", fmt.Errorf("--mix-colors cannot be combined with --dither %s", opts.Engine)
+		}
+		engine = encoder.DitherRandom
+	}
+	if opts.Screen <= 0 {
+		return "", fmt.Errorf("screen frequency must be > 0")
+	}
+	if engine == "" {
+		engine = encoder.DitherOrdered
+	}
+	// Checked by name, as building an engine such as bluenoise takes a while
+	if !slices.Contains(encoder.DitherEngines(), engine) {
+		return "", fmt.Errorf("unknown dithering engine '%s' (must be one of: %s)", opts.Engine, strings.Join(encoder.DitherEngines(), ", "))
+	}
+	return engine, nil
+}
+
+// String describes the engine and the settings it uses
+func (opts ditherOptions) String() string {
+	engine, _ := opts.engine()
+	switch engine {
+	case encoder.DitherOrdered, encoder.DitherThreshold:
+		return engine
+	case encoder.DitherAM:
+		return fmt.Sprintf("%s, seed %d, %g lpi", engine, opts.Seed, opts.Screen)
+	}
+	return fmt.Sprintf("%s, seed %d", engine, opts.Seed)
+}
+
 // burnImage handles the main burning logic
-func burnImage(inputFile, outputFile, discType string, tr0, dtr, r0 float64, mixColors bool, preset string, useMultithread bool) error {
+func burnImage(opts burnOptions) error {
 	// Validate disc type
-	discType = strings.ToLower(discType)
-	if discType != "cd" && discType != "dvd" {
-		return fmt.Errorf("invalid disc type: %s (must be 'cd' or 'dvd')", discType)
+	opts.DiscType = strings.ToLower(opts.DiscType)
+	if opts.DiscType != "cd" && opts.DiscType != "dvd" {
+		return fmt.Errorf("invalid disc type: %s (must be 'cd' or 'dvd')", opts.DiscType)
 	}
 
-	// Load image
-	fmt.Printf("Loading image: %s\n", inputFile)
-	img, err := loadImage(inputFile)
+	// Validate output format
+	switch opts.Format {
+	case encoder.FormatRaw, encoder.FormatFLAC:
+	case encoder.FormatWAV:
+		if opts.DiscType != "cd" {
+			return fmt.Errorf("wav output is only supported for CD (4 GiB WAV limit), use raw or flac")
+		}
+	case encoder.FormatBIN:
+		if opts.OutputFile == "-" {
+			return fmt.Errorf("bin output needs an output file for its cue sheet")
+		}
+		if opts.DiscType != "cd" {
+			return fmt.Errorf("bin/cue output is only supported for CD")
+		}
+	default:
+		return fmt.Errorf("invalid output format: %s (must be 'raw', 'wav', 'bin' or 'flac')", opts.Format)
+	}
+
+	// Splitting only changes the cue sheet, so it needs BIN/CUE output
+	split := opts.SplitCount > 1 || len(opts.SplitRadii) > 0
+	if split && opts.Format != encoder.FormatBIN {
+		return fmt.Errorf("splitting into tracks requires bin output (--format bin)")
+	}
+	if opts.SplitCount > 1 && len(opts.SplitRadii) > 0 {
+		return fmt.Errorf("use either --split or --split-radii, not both")
+	}
+
+	ditherEngine, err := opts.Dither.engine()
+	if err != nil {
+		return err
+	}
+	samplingFilter, err := opts.Sampling.filter()
 	if err != nil {
-		return fmt.Errorf("failed to load image: %w", err)
+		return err
+	}
+	placement, err := opts.Placement.placement()
+	if err != nil {
+		return err
 	}
 
-	// Process image for disc
-	processedImg := createDiscImage(img, discType)
+	// Status messages go to stderr when the track itself is streamed to stdout
+	toStdout := opts.OutputFile == "-"
+	msg := io.Writer(os.Stdout)
+	if toStdout {
+		msg = os.Stderr
+	}
+
+	// Load image and process it for disc
+	processedImg, err := opts.Placement.loadImage(opts.InputFile, opts.DiscType, msg)
+	if err != nil {
+		return err
+	}
 
 	// Determine parameters
-	var discPreset DiscPreset
+	var discPreset presets.DiscPreset
 	var usePreset bool
 
-	if preset != "" {
+	if opts.Preset != "" {
 		var exists bool
-		discPreset, exists = GetPresetByName(preset)
+		discPreset, exists = presets.GetPresetByName(opts.Preset)
 		if !exists {
-			return fmt.Errorf("preset '%s' not found (use 'cdimage list-presets' to see available presets)", preset)
+			return fmt.Errorf("preset '%s' not found (use 'cdimage list-presets' to see available presets)", opts.Preset)
 		}
 		usePreset = true
 		
 		// Ensure preset matches disc type
-		if discPreset.DiscType != discType {
-			return fmt.Errorf("preset '%s' is for %s, but disc type is %s", preset, discPreset.DiscType, discType)
+		if discPreset.DiscType != opts.DiscType {
+			return fmt.Errorf("preset '%s' is for %s, but disc type is %s", opts.Preset, discPreset.DiscType, opts.DiscType)
 		}
-	} else if tr0 == 0 || dtr == 0 {
+	} else if opts.Tr0 == 0 || opts.Dtr == 0 {
 		// Use default preset for disc type
-		discPreset = GetDefaultPreset(discType)
+		discPreset = presets.GetDefaultPreset(opts.DiscType)
 		usePreset = true
-		fmt.Printf("Using default preset for %s: %s\n", strings.ToUpper(discType), discPreset.Name)
+		fmt.Fprintf(msg, "Using default preset for %s: %s\n", strings.ToUpper(opts.DiscType), discPreset.Name)
 	}
 
 	// Set final parameters
-	finalTr0 := tr0
-	finalDtr := dtr
-	finalR0 := r0
+	finalTr0 := opts.Tr0
+	finalDtr := opts.Dtr
+	finalR0 := opts.R0
 
 	if usePreset {
 		finalTr0 = discPreset.Tr0
 		finalDtr = discPreset.Dtr
 		finalR0 = discPreset.R0
-		fmt.Printf("Using preset: %s\n", discPreset.Name)
+		fmt.Fprintf(msg, "Using preset: %s\n", discPreset.Name)
 	}
 
-	fmt.Printf("Parameters - tr0: %.2f, dtr: %.6f, r0: %.1f\n", finalTr0, finalDtr, finalR0)
-	fmt.Printf("Mix colors: %t\n", mixColors)
-	fmt.Printf("Multi-threading: %t\n", useMultithread)
+	palette, err := resolvePalette(opts.Palette, discPreset)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(msg, "Parameters - tr0: %.2f, dtr: %.6f, r0: %.1f\n", finalTr0, finalDtr, finalR0)
+	fmt.Fprintf(msg, "Dithering: %s\n", opts.Dither)
+	fmt.Fprintf(msg, "Sampling: %s\n", opts.Sampling)
+	fmt.Fprintf(msg, "Palette: %d levels (%s)\n", palette.Len(), palette)
+	fmt.Fprintf(msg, "Multi-threading: %t\n", opts.Parallel)
+	fmt.Fprintf(msg, "Output format: %s\n", opts.Format)
 
 	// Create progress bar
 	bar := progressbar.NewOptions(100,
+		progressbar.OptionSetWriter(msg),
 		progressbar.OptionSetDescription("Converting"),
 		progressbar.OptionSetTheme(progressbar.Theme{
 			Saucer:        "█",
@@ -88,16 +349,36 @@ func burnImage(inputFile, outputFile, discType string, tr0, dtr, r0 float64, mix
 	)
 
 	// Create converter (choose between single and multi-threaded)
-	var converter interface {
-		Convert(context.Context, image.Image, string) error
-		SetProgressCallback(func(int))
-		SetCancelCallback(func() bool)
+	encoderOpts := encoder.Options{
+		Tr0:        finalTr0,
+		Dtr:        finalDtr,
+		R0:         finalR0,
+		Dither:     ditherEngine,
+		Seed:       opts.Dither.Seed,
+		Screen:     opts.Dither.Screen,
+		Palette:    palette,
+		Sampling:   samplingFilter,
+		AreaFilter: opts.Sampling.Area,
+		Placement:  placement,
+		DiscType:   opts.DiscType,
+		Parallel:   opts.Parallel,
+		Format:     opts.Format,
 	}
+	converter := encoder.New(encoderOpts)
 
-	if useMultithread {
-		converter = NewMultiThreadedConverter(finalTr0, finalDtr, finalR0, mixColors, discType)
-	} else {
-		converter = NewConverter(finalTr0, finalDtr, finalR0, mixColors, discType)
+	// Work out the track boundaries before spending time on the conversion
+	trackStarts := []int64{0}
+	if split {
+		var err error
+		if len(opts.SplitRadii) > 0 {
+			trackStarts, err = encoder.NewConverter(encoderOpts).SplitAtRadii(opts.SplitRadii)
+		} else {
+			trackStarts, err = encoder.NewConverter(encoderOpts).SplitEvenly(opts.SplitCount)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot split track: %w", err)
+		}
+		fmt.Fprintf(msg, "Splitting into %d tracks\n", len(trackStarts))
 	}
 
 	// Set up progress tracking with throttling
@@ -128,27 +409,26 @@ func burnImage(inputFile, outputFile, discType string, tr0, dtr, r0 float64, mix
 
 	go func() {
 		<-sigChan
-		fmt.Printf("\nReceived interrupt signal, cancelling...\n")
+		fmt.Fprintf(msg, "\nReceived interrupt signal, cancelling...\n")
 		cancelled = true
 		cancel()
 	}()
 
 	// Start conversion
-	fmt.Printf("Converting image to %s audio track...\n", strings.ToUpper(discType))
-	fmt.Printf("Output file: %s\n", outputFile)
+	fmt.Fprintf(msg, "Converting image to %s audio track...\n", strings.ToUpper(opts.DiscType))
 	
 	startTime := time.Now()
 	
-	// Choose conversion method based on threading option
 	var convErr error
-	if useMultithread {
-		if mtconv, ok := converter.(*MultiThreadedConverter); ok {
-			convErr = mtconv.ConvertParallel(ctx, processedImg, outputFile)
-		} else {
-			convErr = converter.Convert(ctx, processedImg, outputFile)
-		}
+	var written int64
+	if toStdout {
+		fmt.Fprintf(msg, "Output: stdout\n")
+		out := &countingWriter{w: os.Stdout}
+		convErr = converter.Convert(ctx, processedImg, out)
+		written = out.n
 	} else {
-		convErr = converter.Convert(ctx, processedImg, outputFile)
+		fmt.Fprintf(msg, "Output file: %s\n", opts.OutputFile)
+		convErr = converter.ConvertFile(ctx, processedImg, opts.OutputFile)
 	}
 	
 	duration := time.Since(startTime)
@@ -156,39 +436,112 @@ func burnImage(inputFile, outputFile, discType string, tr0, dtr, r0 float64, mix
 	// Ensure progress bar reaches 100% and finishes cleanly
 	bar.Set(100)
 	bar.Finish()
-	fmt.Printf("\n") // Add single newline after progress bar
+	fmt.Fprintf(msg, "\n") // Add single newline after progress bar
 
 	if convErr != nil {
 		if cancelled || ctx.Err() != nil {
-			fmt.Printf("Conversion cancelled.\n")
+			fmt.Fprintf(msg, "Conversion cancelled.\n")
 			return nil
 		}
 		return fmt.Errorf("conversion failed: %w", convErr)
 	}
 
+	if toStdout {
+		fmt.Fprintf(msg, "\nConversion completed successfully!\n")
+		fmt.Fprintf(msg, "Duration: %v\n", duration.Truncate(time.Second))
+		fmt.Fprintf(msg, "Streamed %.1f MB to stdout\n", float64(written)/(1024*1024))
+		return nil
+	}
+
+	// Describe the track in a cue sheet next to the BIN file
+	burnFile := opts.OutputFile
+	if opts.Format == encoder.FormatBIN {
+		performer := fmt.Sprintf("cdimage tr0=%.2f dtr=%.6f r0=%.1f", finalTr0, finalDtr, finalR0)
+		if usePreset {
+			performer = discPreset.Name
+		}
+		burnFile = strings.TrimSuffix(opts.OutputFile, filepath.Ext(opts.OutputFile)) + ".cue"
+		if err := writeCueSheet(burnFile, opts.OutputFile, opts.InputFile, performer, trackStarts); err != nil {
+			return err
+		}
+		fmt.Fprintf(msg, "Cue sheet: %s\n", burnFile)
+		if split {
+			for i, start := range trackStarts {
+				fmt.Fprintf(msg, "  Track %02d at %s\n", i+1, encoder.MSF(start/encoder.SectorSize))
+			}
+		}
+	}
+
 	// Check if file was created successfully
-	if info, err := os.Stat(outputFile); err != nil {
+	if info, err := os.Stat(opts.OutputFile); err != nil {
 		return fmt.Errorf("output file was not created: %w", err)
 	} else {
 		fileSize := float64(info.Size()) / (1024 * 1024) // Size in MB
-		fmt.Printf("\nConversion completed successfully!\n")
-		fmt.Printf("Duration: %v\n", duration.Truncate(time.Second))
-		fmt.Printf("Output file size: %.1f MB\n", fileSize)
-		fmt.Printf("\nTo burn the track to a %s:\n", strings.ToUpper(discType))
+		fmt.Fprintf(msg, "\nConversion completed successfully!\n")
+		fmt.Fprintf(msg, "Duration: %v\n", duration.Truncate(time.Second))
+		fmt.Fprintf(msg, "Output file size: %.1f MB\n", fileSize)
+		
+		// FLAC is for exchanging tracks; burners need the samples back
+		if opts.Format == encoder.FormatFLAC {
+			rawFile := strings.TrimSuffix(opts.OutputFile, filepath.Ext(opts.OutputFile)) + ".raw"
+			fmt.Fprintf(msg, "\nTo restore the raw track before burning:\n")
+			fmt.Fprintf(msg, "  cdimage decode -i %s -o %s\n", opts.OutputFile, rawFile)
+			return nil
+		}
+		
+		fmt.Fprintf(msg, "\nTo burn the track to a %s:\n", strings.ToUpper(opts.DiscType))
 		
-		if discType == "cd" {
-			fmt.Printf("  cdrecord -audio dev=/dev/sr0 %s\n", outputFile)
-			fmt.Printf("  OR\n")
-			fmt.Printf("  wodim -audio dev=/dev/sr0 %s\n", outputFile)
+		// Raw tracks are little endian, cdrecord reads raw audio as big endian
+		audio := "-audio"
+		if opts.Format == encoder.FormatRaw {
+			audio = "-audio -swab"
+		}
+		
+		if opts.Format == encoder.FormatBIN {
+			fmt.Fprintf(msg, "  cdrdao write --device /dev/sr0 %s\n", burnFile)
+		} else if opts.DiscType == "cd" {
+			fmt.Fprintf(msg, "  cdrecord %s dev=/dev/sr0 %s\n", audio, opts.OutputFile)
+			fmt.Fprintf(msg, "  OR\n")
+			fmt.Fprintf(msg, "  wodim %s dev=/dev/sr0 %s\n", audio, opts.OutputFile)
 		} else {
-			fmt.Printf("  growisofs -audio -Z /dev/sr0=%s\n", outputFile)
-			fmt.Printf("  OR\n")
-			fmt.Printf("  cdrecord -audio dev=/dev/sr0 %s\n", outputFile)
+			fmt.Fprintf(msg, "  growisofs -audio -Z /dev/sr0=%s\n", opts.OutputFile)
+			fmt.Fprintf(msg, "  OR\n")
+			fmt.Fprintf(msg, "  cdrecord %s dev=/dev/sr0 %s\n", audio, opts.OutputFile)
 		}
 		
-		fmt.Printf("\nNote: Replace /dev/sr0 with your actual optical drive device.\n")
-		fmt.Printf("Use 'cdrecord -scanbus' or 'wodim -scanbus' to find your drive.\n")
+		fmt.Fprintf(msg, "\nNote: Replace /dev/sr0 with your actual optical drive device.\n")
+		fmt.Fprintf(msg, "Use 'cdrecord -scanbus' or 'wodim -scanbus' to find your drive.\n")
 	}
 
 	return nil
+}
+
+// writeCueSheet writes a cue sheet for binFile with tracks starting at the
+// given byte offsets, titled after the source image
+func writeCueSheet(cueFile, binFile, imageFile, performer string, trackStarts []int64) error {
+	title := strings.TrimSuffix(filepath.Base(imageFile), filepath.Ext(imageFile))
+	sheet := encoder.NewSplitCueSheet(filepath.Base(binFile), title, performer, trackStarts)
+
+	file, err := os.Create(cueFile)
+	if err != nil {
+		return fmt.Errorf("failed to create cue sheet: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := sheet.WriteTo(file); err != nil {
+		return fmt.Errorf("failed to write cue sheet: %w", err)
+	}
+	return file.Close()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
\ No newline at end of file
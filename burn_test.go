@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cdimage/encoder"
+)
+
+func TestDitherOptionsEngine(t *testing.T) {
+	tests := []struct {
+		opts ditherOptions
+		want string // Empty if the options are rejected
+	}{
+		{ditherOptions{Engine: "", Screen: 1}, encoder.DitherOrdered},
+		{ditherOptions{Engine: "BlueNoise", Screen: 1}, encoder.DitherBlueNoise},
+		{ditherOptions{Engine: encoder.DitherOrdered, MixColors: true, Screen: 1}, encoder.DitherRandom},
+		{ditherOptions{Engine: encoder.DitherBayer, MixColors: true, Screen: 1}, ""},
+		{ditherOptions{Engine: "floyd", Screen: 1}, ""},
+		{ditherOptions{Engine: encoder.DitherAM, Screen: 0}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.opts.engine()
+		if tt.want == "" && err == nil {
+			t.Errorf("%+v: accepted as %s", tt.opts, got)
+		}
+		if tt.want != "" && (err != nil || got != tt.want) {
+			t.Errorf("%+v: got %q, %v, expected %s", tt.opts, got, err, tt.want)
+		}
+	}
+}
+
+// TestBurnImageFormatDiscType checks that output formats limited to CD are
+// rejected for DVD before anything is read or written
+func TestBurnImageFormatDiscType(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{encoder.FormatWAV, encoder.FormatBIN} {
+		output := filepath.Join(dir, "track."+format)
+		err := burnImage(burnOptions{
+			InputFile:  filepath.Join(dir, "missing.png"),
+			OutputFile: output,
+			DiscType:   "DVD",
+			Format:     format,
+		})
+		if err == nil || !strings.Contains(err.Error(), "only supported for CD") {
+			t.Errorf("%s on DVD: got %v", format, err)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Errorf("%s on DVD: output file created", format)
+		}
+	}
+}
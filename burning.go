@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"cdimage/encoder"
 )
 
 // OpticalDrive represents an optical drive that can burn discs
@@ -229,18 +231,16 @@ func enhanceWithTool(drives *[]OpticalDrive, tool string) bool {
 
 // BurnAudioTrack burns an audio track to the specified drive
 func BurnAudioTrack(drive OpticalDrive, trackFile string, discType string) error {
-	// Determine the appropriate burning tool and command
-	var cmd *exec.Cmd
+	cmd, tocFile, err := burnCommand(drive, trackFile, discType)
+	if err != nil {
+		return err
+	}
 	
-	// Try different burning tools in order of preference
-	if _, err := exec.LookPath("cdrecord"); err == nil {
-		cmd = exec.Command("cdrecord", "-audio", fmt.Sprintf("dev=%s", drive.Device), trackFile)
-	} else if _, err := exec.LookPath("wodim"); err == nil {
-		cmd = exec.Command("wodim", "-audio", fmt.Sprintf("dev=%s", drive.Device), trackFile)
-	} else if _, err := exec.LookPath("growisofs"); err == nil && discType == "dvd" {
-		cmd = exec.Command("growisofs", "-audio", fmt.Sprintf("-Z %s=%s", drive.Device, trackFile))
-	} else {
-		return fmt.Errorf("no suitable burning tool found (cdrecord, wodim, or growisofs)")
+	// cdrdao burns from a TOC file written next to the track
+	if tocFile != "" {
+		if err := WriteTOCFile(tocFile, trackFile); err != nil {
+			return err
+		}
 	}
 	
 	// Set up command to show output
@@ -250,6 +250,115 @@ func BurnAudioTrack(drive OpticalDrive, trackFile string, discType string) error
 	return cmd.Run()
 }
 
+// burnCommand builds the command that burns trackFile. CDs are written
+// disc-at-once by cdrdao when it is installed, so there is no pregap of
+// unknown length between the lead-in and the image; the other tools write
+// track-at-once. For cdrdao the TOC file the command reads is returned too,
+// unless trackFile already is a cue sheet or TOC. A BIN file is burned from
+// the cue sheet next to it, which keeps its tracks and CD-Text.
+//
+// Raw and BIN tracks hold the samples in disc byte order (little endian).
+// Cue sheets declare BIN files as such, but cdrdao reads the raw files of a
+// TOC and cdrecord raw audio as big endian, so those commands swap them.
+func burnCommand(drive OpticalDrive, trackFile string, discType string) (*exec.Cmd, string, error) {
+	ext := strings.ToLower(filepath.Ext(trackFile))
+	if _, err := exec.LookPath("cdrdao"); err == nil && discType == "cd" {
+		args := []string{"write", "--device", drive.Device}
+		
+		tocFile := ""
+		burnFile := trackFile
+		switch ext {
+		case ".cue":
+		case ".toc":
+			swap, err := tocSwap(trackFile)
+			if err != nil {
+				return nil, "", err
+			}
+			if swap {
+				args = append(args, "--swap")
+			}
+		case ".bin":
+			burnFile = strings.TrimSuffix(trackFile, filepath.Ext(trackFile)) + ".cue"
+			if _, err := os.Stat(burnFile); err != nil {
+				return nil, "", fmt.Errorf("no cue sheet %s for %s", burnFile, trackFile)
+			}
+		case ".wav":
+			tocFile = strings.TrimSuffix(trackFile, filepath.Ext(trackFile)) + ".toc"
+			burnFile = tocFile
+		default:
+			args = append(args, "--swap")
+			tocFile = strings.TrimSuffix(trackFile, filepath.Ext(trackFile)) + ".toc"
+			burnFile = tocFile
+		}
+		
+		// File names in the TOC and cue sheet are relative to their directory
+		cmd := exec.Command("cdrdao", append(args, filepath.Base(burnFile))...)
+		cmd.Dir = filepath.Dir(burnFile)
+		return cmd, tocFile, nil
+	}
+	
+	// Try different burning tools in order of preference
+	audio := []string{"-audio"}
+	if ext != ".wav" {
+		audio = append(audio, "-swab")
+	}
+	if _, err := exec.LookPath("cdrecord"); err == nil {
+		return exec.Command("cdrecord", append(audio, fmt.Sprintf("dev=%s", drive.Device), trackFile)...), "", nil
+	} else if _, err := exec.LookPath("wodim"); err == nil {
+		return exec.Command("wodim", append(audio, fmt.Sprintf("dev=%s", drive.Device), trackFile)...), "", nil
+	} else if _, err := exec.LookPath("growisofs"); err == nil && discType == "dvd" {
+		return exec.Command("growisofs", "-audio", fmt.Sprintf("-Z %s=%s", drive.Device, trackFile)), "", nil
+	}
+	
+	return nil, "", fmt.Errorf("no suitable burning tool found (cdrdao, cdrecord, wodim, or growisofs)")
+}
+
+// tocFileRe matches the audio file of a TOC file track
+var tocFileRe = regexp.MustCompile(`^\s*(?:AUDIO)?FILE\s+"([^"]*)"`)
+
+// tocSwap reports whether cdrdao has to swap the samples of the audio files
+// a TOC file refers to: raw tracks need it, WAV files do not. A TOC mixing
+// both cannot be burned with a single byte order.
+func tocSwap(tocFile string) (bool, error) {
+	data, err := os.ReadFile(tocFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read TOC file: %w", err)
+	}
+	
+	raw, wav := false, false
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := tocFileRe.FindStringSubmatch(line); m != nil {
+			if strings.EqualFold(filepath.Ext(m[1]), ".wav") {
+				wav = true
+			} else {
+				raw = true
+			}
+		}
+	}
+	if raw && wav {
+		return false, fmt.Errorf("%s mixes WAV and raw audio files", tocFile)
+	}
+	return raw, nil
+}
+
+// WriteTOCFile writes a cdrdao TOC file describing trackFile as a single
+// audio track
+func WriteTOCFile(tocFile, trackFile string) error {
+	title := strings.TrimSuffix(filepath.Base(trackFile), filepath.Ext(trackFile))
+	sheet := encoder.NewCueSheet(filepath.Base(trackFile), title, "cdimage")
+	
+	file, err := os.Create(tocFile)
+	if err != nil {
+		return fmt.Errorf("failed to create TOC file: %w", err)
+	}
+	defer file.Close()
+	
+	if _, err := sheet.WriteTOC(file); err != nil {
+		return fmt.Errorf("failed to write TOC file: %w", err)
+	}
+	return file.Close()
+}
+
 // CheckDiscInDrive checks if there's a writable disc in the drive
 func CheckDiscInDrive(drive OpticalDrive) (bool, string, error) {
 	// Use blkid to get disc info
@@ -281,13 +390,27 @@ func CheckDiscInDrive(drive OpticalDrive) (bool, string, error) {
 
 // GetBurningCommand returns the command line that would be used for burning
 func GetBurningCommand(drive OpticalDrive, trackFile string, discType string) string {
-	if _, err := exec.LookPath("cdrecord"); err == nil {
-		return fmt.Sprintf("cdrecord -audio dev=%s %s", drive.Device, trackFile)
-	} else if _, err := exec.LookPath("wodim"); err == nil {
-		return fmt.Sprintf("wodim -audio dev=%s %s", drive.Device, trackFile)
-	} else if _, err := exec.LookPath("growisofs"); err == nil && discType == "dvd" {
-		return fmt.Sprintf("growisofs -audio -Z %s=%s", drive.Device, trackFile)
+	cmd, _, err := burnCommand(drive, trackFile, discType)
+	if err != nil {
+		return "No burning tool available"
 	}
 	
-	return "No burning tool available"
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = shellQuote(arg)
+	}
+	command := strings.Join(args, " ")
+	if cmd.Dir != "" && cmd.Dir != "." {
+		command = fmt.Sprintf("cd %s && %s", shellQuote(cmd.Dir), command)
+	}
+	return command
+}
+
+// shellQuote quotes s for a POSIX shell, unless it only holds characters
+// the shell takes literally
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
\ No newline at end of file
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubTool puts a script named tool on PATH, alone, that records its
+// arguments and working directory in the returned log file
+func stubTool(t *testing.T, tool string) string {
+	t.Helper()
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), tool+".log")
+	script := "#!/bin/sh\npwd > '" + log + "'\nfor arg in \"$@\"; do echo \"$arg\" >> '" + log + "'; done\n"
+	if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	return log
+}
+
+// stubCdrdao puts a cdrdao script on PATH, see stubTool
+func stubCdrdao(t *testing.T) string {
+	return stubTool(t, "cdrdao")
+}
+
+func TestBurnCommandCdrdao(t *testing.T) {
+	stubCdrdao(t)
+	drive := OpticalDrive{Device: "/dev/sr9"}
+	dir := t.TempDir()
+	files := map[string]string{
+		"image.cue": "FILE \"image.bin\" BINARY\n",
+		"raw.toc":   "CD_DA\nTRACK AUDIO\nFILE \"track.raw\" 00:00:00\n",
+		"wav.toc":   "CD_DA\nTRACK AUDIO\nAUDIOFILE \"track.wav\" 0\n",
+		"mixed.toc": "CD_DA\nTRACK AUDIO\nFILE \"track.raw\" 0\nTRACK AUDIO\nFILE \"track.wav\" 0\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		track string
+		args  []string // nil if the track cannot be burned
+		toc   string
+	}{
+		// Raw tracks are little endian, the raw files of a TOC big endian
+		{"track.raw", []string{"cdrdao", "write", "--device", "/dev/sr9", "--swap", "track.toc"}, "track.toc"},
+		{"track.wav", []string{"cdrdao", "write", "--device", "/dev/sr9", "track.toc"}, "track.toc"},
+		// A BIN file is burned from its cue sheet, which declares it little endian
+		{"image.bin", []string{"cdrdao", "write", "--device", "/dev/sr9", "image.cue"}, ""},
+		{"other.bin", nil, ""},
+		{"image.cue", []string{"cdrdao", "write", "--device", "/dev/sr9", "image.cue"}, ""},
+		{"raw.toc", []string{"cdrdao", "write", "--device", "/dev/sr9", "--swap", "raw.toc"}, ""},
+		{"wav.toc", []string{"cdrdao", "write", "--device", "/dev/sr9", "wav.toc"}, ""},
+		{"mixed.toc", nil, ""},
+	}
+	for _, tt := range tests {
+		cmd, tocFile, err := burnCommand(drive, filepath.Join(dir, tt.track), "cd")
+		if tt.args == nil {
+			if err == nil {
+				t.Errorf("%s: burned as %q", tt.track, cmd.Args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.track, err)
+		}
+		if strings.Join(cmd.Args, " ") != strings.Join(tt.args, " ") {
+			t.Errorf("%s: args %q, want %q", tt.track, cmd.Args, tt.args)
+		}
+		if cmd.Dir != dir {
+			t.Errorf("%s: dir %q, want %q", tt.track, cmd.Dir, dir)
+		}
+		want := ""
+		if tt.toc != "" {
+			want = filepath.Join(dir, tt.toc)
+		}
+		if tocFile != want {
+			t.Errorf("%s: TOC file %q, want %q", tt.track, tocFile, want)
+		}
+	}
+
+	// DVDs are not written by cdrdao
+	if _, _, err := burnCommand(drive, filepath.Join(dir, "track.raw"), "dvd"); err == nil {
+		t.Error("dvd: expected no burning tool")
+	}
+}
+
+func TestBurnCommandCdrecord(t *testing.T) {
+	stubTool(t, "cdrecord")
+	drive := OpticalDrive{Device: "/dev/sr9"}
+	for track, want := range map[string]string{
+		"track.raw": "cdrecord -audio -swab dev=/dev/sr9 track.raw",
+		"track.wav": "cdrecord -audio dev=/dev/sr9 track.wav",
+	} {
+		cmd, _, err := burnCommand(drive, track, "cd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(cmd.Args, " "); got != want {
+			t.Errorf("%s: got %s, want %s", track, got, want)
+		}
+	}
+}
+
+func TestBurnAudioTrackRunsCdrdao(t *testing.T) {
+	log := stubCdrdao(t)
+	dir := t.TempDir()
+	track := filepath.Join(dir, "my image.raw")
+	if err := os.WriteFile(track, make([]byte, 2352), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BurnAudioTrack(OpticalDrive{Device: "/dev/sr9"}, track, "cd"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	want := []string{dir, "write", "--device", "/dev/sr9", "--swap", "my image.toc"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("cdrdao ran as %q, want %q", lines, want)
+	}
+
+	toc, err := os.ReadFile(filepath.Join(dir, "my image.toc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"CD_DA", "TITLE \"my image\"", "TRACK AUDIO", "FILE \"my image.raw\" 00:00:00", "START 00:00:00"} {
+		if !strings.Contains(string(toc), line+"\n") {
+			t.Errorf("TOC lacks %q:\n%s", line, toc)
+		}
+	}
+}
+
+func TestGetBurningCommandQuotes(t *testing.T) {
+	stubCdrdao(t)
+	dir := filepath.Join(t.TempDir(), "it's a $dir")
+	got := GetBurningCommand(OpticalDrive{Device: "/dev/sr9"}, filepath.Join(dir, "track.raw"), "cd")
+	want := "cd '" + strings.ReplaceAll(dir, "'", `'\''`) + "' && cdrdao write --device /dev/sr9 --swap track.toc"
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"cdimage/encoder"
+	"cdimage/preview"
+)
+
+// Radius bands printed by the compare command
+const compareBands = 12
+
+// compareOptions holds the settings of the compare command
+type compareOptions struct {
+	InputFile  string
+	ResultFile string
+	DiscType   string
+	Tr0        float64
+	Dtr        float64
+	R0         float64
+	Preset     string
+	Palette    string
+	Rings      int
+	Sectors    int
+	Heatmap    string
+	Size       int
+	CSVFile    string
+	CueTracks  []int
+}
+
+// compareResult reports how faithfully a track or preview reproduces its
+// source image
+func compareResult(opts compareOptions) error {
+	// Validate disc type
+	opts.DiscType = strings.ToLower(opts.DiscType)
+	if opts.DiscType != "cd" && opts.DiscType != "dvd" {
+		return fmt.Errorf("invalid disc type: %s (must be 'cd' or 'dvd')", opts.DiscType)
+	}
+
+	geometry, err := resolveGeometry(opts.DiscType, opts.Preset, opts.Tr0, opts.Dtr, opts.R0)
+	if err != nil {
+		return err
+	}
+	opts.Tr0, opts.Dtr, opts.R0 = geometry.Tr0, geometry.Dtr, geometry.R0
+	fmt.Printf("Parameters - tr0: %.2f, dtr: %.6f, r0: %.1f\n", opts.Tr0, opts.Dtr, opts.R0)
+	palette, err := resolvePalette(opts.Palette, geometry)
+	if err != nil {
+		return err
+	}
+
+	visualizer := preview.NewTrackVisualizer(opts.Tr0, opts.Dtr, opts.R0, opts.DiscType)
+	if err := visualizer.SetSize(opts.Size); err != nil {
+		return err
+	}
+	visualizer.SetPalette(palette)
+	visualizer.SetCueTracks(opts.CueTracks)
+
+	// Load image
+	fmt.Printf("Loading image: %s\n", opts.InputFile)
+	img, err := encoder.LoadImage(opts.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+	discImg := encoder.CreateDiscImage(img, opts.DiscType)
+
+	// Handle Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cmp, err := visualizer.Compare(ctx, discImg, opts.ResultFile, preview.CompareOptions{
+		Rings:   opts.Rings,
+		Sectors: opts.Sectors,
+		Heatmap: opts.Heatmap,
+	})
+	if err != nil {
+		return fmt.Errorf("comparison failed: %w", err)
+	}
+
+	fmt.Printf("\nPSNR: %.2f dB\n", cmp.PSNR)
+	fmt.Printf("SSIM: %.4f\n\n", cmp.SSIM)
+	fmt.Printf("%10s %8s %8s %8s\n", "radius mm", "RMSE", "bias", "SSIM")
+	for _, band := range cmp.Bands(compareBands) {
+		if band.Bins == 0 {
+			fmt.Printf("%10.2f %8s %8s %8s\n", band.Radius, "-", "-", "-")
+			continue
+		}
+		fmt.Printf("%10.2f %8.2f %+8.2f %8.4f\n", band.Radius, band.RMSE, band.Bias, band.SSIM)
+	}
+
+	if opts.CSVFile != "" {
+		if err := writeRingErrors(opts.CSVFile, cmp.Rings); err != nil {
+			return err
+		}
+		fmt.Printf("\nPer-radius errors saved to: %s\n", opts.CSVFile)
+	}
+	return nil
+}
+
+// writeRingErrors writes the error curves of every ring to a CSV file
+func writeRingErrors(fileName string, rings []preview.RingError) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create CSV file: %w", err)
+	}
+	defer file.Close()
+
+	format := func(f float64) string {
+		if math.IsNaN(f) {
+			return ""
+		}
+		return strconv.FormatFloat(f, 'f', 4, 64)
+	}
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"radius_mm", "bins", "rmse", "bias", "ssim"})
+	for _, r := range rings {
+		w.Write([]string{format(r.Radius), strconv.Itoa(r.Bins), format(r.RMSE), format(r.Bias), format(r.SSIM)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV file: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cdimage/encoder"
+)
+
+// decodeTrack decodes a FLAC track back to the raw sample stream, or to WAV
+// when the output file name ends in .wav
+func decodeTrack(inputFile, outputFile string) error {
+	toStdout := outputFile == "-"
+	msg := io.Writer(os.Stdout)
+	if toStdout {
+		msg = os.Stderr
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open track file: %w", err)
+	}
+	defer in.Close()
+
+	fr, err := encoder.NewFLACReader(in)
+	if errors.Is(err, encoder.ErrNotFLAC) {
+		return fmt.Errorf("%s is not a FLAC file", inputFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read FLAC track: %w", err)
+	}
+
+	wav := !toStdout && strings.EqualFold(filepath.Ext(outputFile), ".wav")
+	if wav && fr.Samples() == 0 {
+		return fmt.Errorf("FLAC track does not record its length, decode to raw instead")
+	}
+
+	fmt.Fprintf(msg, "Decoding %s\n", inputFile)
+
+	var out io.Writer = os.Stdout
+	if !toStdout {
+		file, err := os.Create(outputFile)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err = func() error {
+		if wav {
+			if err := encoder.WriteWAVHeader(out, fr.Samples()*encoder.BytesPerFrame); err != nil {
+				return err
+			}
+		}
+		n, err := io.Copy(out, fr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(msg, "Decoded %.1f MB of audio, checksum verified\n", float64(n)/(1024*1024))
+		return nil
+	}()
+	if err != nil {
+		if !toStdout {
+			os.Remove(outputFile)
+		}
+		return fmt.Errorf("decoding failed: %w", err)
+	}
+
+	if f, ok := out.(*os.File); ok && !toStdout {
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+		fmt.Fprintf(msg, "Output file: %s\n", outputFile)
+	}
+	return nil
+}
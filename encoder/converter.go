@@ -0,0 +1,617 @@
+// Package encoder converts images into CD-DA audio tracks that render a
+// visible picture when burned onto a CD or DVD.
+//
+// The image is sampled along the recording spiral described by tr0 (samples
+// in the first track), dtr (growth of the track length per revolution) and
+// r0 (radius of the first track in mm). Each sample is quantised to one of
+// the palette bytes and passed through a delay sequence that cancels the
+// drive's CIRC interleave, so the bytes land on the disc in spiral order.
+package encoder
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+const (
+	// D is the CIRC delay unit in frames
+	D = 4
+	// Audio CD sector size
+	SectorSize = 2352
+	// Total size for CD (800MB)
+	CDTotalSize = 800 * 1024 * 1024
+	// Total size for DVD (4.7GB)
+	DVDTotalSize = 4700 * 1024 * 1024
+	// Output written per call to the writer, a whole number of sectors
+	writeBatch = 64 * SectorSize
+	// Frames in the delay line of the converter, 28*D of them carried over
+	// when it is full
+	delayFrames = 1024
+)
+
+// Disc geometry used to map the spiral onto the 3000x3000 disc image
+const (
+	imageRadius = 1500.0 // Image radius in pixels
+	discRadius  = 57.5   // CD radius in mm
+)
+
+// delays array from original C++ code
+var delays = [24]int{
+	-24 * (3), -24*(1*D+2) + 1, 8 - 24*(2*D+3), 8 - 24*(3*D+2) + 1,
+	16 - 24*(4*D+3), 16 - 24*(5*D+2) + 1, 2 - 24*(6*D+3), 2 - 24*(7*D+2) + 1,
+	10 - 24*(8*D+3), 10 - 24*(9*D+2) + 1, 18 - 24*(10*D+3), 18 - 24*(11*D+2) + 1,
+
+	4 - 24*(16*D+1), 4 - 24*(17*D) + 1, 12 - 24*(18*D+1), 12 - 24*(19*D) + 1,
+	20 - 24*(20*D+1), 20 - 24*(21*D) + 1, 6 - 24*(22*D+1), 6 - 24*(23*D) + 1,
+	14 - 24*(24*D+1), 14 - 24*(25*D) + 1, 22 - 24*(26*D+1), 22 - 24*(27*D) + 1,
+}
+
+// Options configures a Converter
+type Options struct {
+	Tr0        float64    // Samples in the first track
+	Dtr        float64    // Samples added to each following track
+	R0         float64    // Radius of the first track in mm
+	MixColors  bool       // Random instead of ordered dithering, same as Dither DitherRandom
+	Dither     string     // Dithering engine, see DitherEngines; empty for ordered
+	Seed       int64      // Seed of the randomised dithering engines
+	Screen     float64    // Screen frequency of the AM halftone in lines per inch, 0 for the default
+	Palette    *Palette   // Bytes written for the gray levels, nil for DefaultPalette
+	Sampling   string     // Filter reading the image between pixels, see SamplingFilters; empty for nearest
+	AreaFilter bool       // Average the image over the track pitch and sample spacing of every sample
+	Placement  *Placement // Position of the source image on the disc, nil for a disc raster from CreateDiscImage
+	DiscType   string     // "cd" or "dvd"
+	Parallel   bool       // Use MultiThreadedConverter in New
+	Format     string     // FormatRaw (default), FormatWAV or FormatBIN
+
+	totalSize int // Samples on the disc, 0 for those of DiscType; tests use a small disc
+}
+
+// Encoder is implemented by Converter and MultiThreadedConverter
+type Encoder interface {
+	Convert(ctx context.Context, img image.Image, w io.Writer) error
+	ConvertFile(ctx context.Context, img image.Image, filename string) error
+	SetProgressCallback(func(int))
+	SetCancelCallback(func() bool)
+}
+
+// New returns a single or multi-threaded converter depending on opts.Parallel
+func New(opts Options) Encoder {
+	if opts.Parallel {
+		return NewMultiThreadedConverter(opts)
+	}
+	return NewConverter(opts)
+}
+
+// Converter handles the image to audio track conversion
+type Converter struct {
+	tr0        float64
+	dtr        float64
+	r0         float64
+	dither     string
+	ditherer   Ditherer
+	palette    *Palette
+	sampler    sampler
+	placement  *Placement
+	optionsErr error // Unknown dithering engine or sampling filter
+	discType   string
+	totalSize  int
+	format     string
+	
+	// Internal state
+	delayLine [24 * delayFrames]byte // Frames passing through the delay sequence
+	frame     int                    // Frame of delayLine being filled
+	pinf      int                    // Bytes of the frame filled so far
+	out       []byte                 // Output batch, written in writeBatch bytes
+	
+	// Progress tracking
+	progressCallback func(int)
+	cancelCallback   func() bool
+}
+
+// NewConverter creates a new converter with the given parameters. An
+// unknown dithering engine or sampling filter is reported by Convert.
+func NewConverter(opts Options) *Converter {
+	dither := opts.Dither
+	if dither == "" && opts.MixColors {
+		dither = DitherRandom
+	}
+	palette := opts.Palette
+	if palette == nil {
+		palette = DefaultPalette
+	}
+	totalSize := opts.totalSize
+	if totalSize == 0 {
+		totalSize = CDTotalSize
+		if opts.DiscType == "dvd" {
+			totalSize = DVDTotalSize
+		}
+	}
+	ditherer, err := NewDitherer(dither, opts.Seed, opts.Screen, palette)
+	sampler, samplerErr := newSampler(opts.Sampling, opts.AreaFilter)
+	if err == nil {
+		err = samplerErr
+	}
+
+	return &Converter{
+		tr0:        opts.Tr0,
+		dtr:        opts.Dtr,
+		r0:         opts.R0,
+		dither:     dither,
+		ditherer:   ditherer,
+		palette:    palette,
+		sampler:    sampler,
+		placement:  opts.Placement,
+		optionsErr: err,
+		discType:   opts.DiscType,
+		totalSize:  totalSize,
+		format:     opts.Format,
+		frame:      28*D - 1,
+		pinf:       0,
+		out:        make([]byte, 0, writeBatch),
+	}
+}
+
+// SetProgressCallback sets a callback function for progress updates
+func (conv *Converter) SetProgressCallback(callback func(int)) {
+	conv.progressCallback = callback
+}
+
+// SetCancelCallback sets a callback function to check for cancellation
+func (conv *Converter) SetCancelCallback(callback func() bool) {
+	conv.cancelCallback = callback
+}
+
+// Convert converts an image to a raw audio track written to w. The image is
+// expected to be a disc raster as returned by CreateDiscImage, or with
+// Options.Placement the source image, best loaded by LoadSourceImage.
+func (conv *Converter) Convert(ctx context.Context, img image.Image, w io.Writer) error {
+	if conv.optionsErr != nil {
+		return conv.optionsErr
+	}
+	conv.reset()
+	
+	w, err := conv.writeHeader(w)
+	if err != nil {
+		return err
+	}
+	
+	// Convert the image to gray values once
+	src := conv.source(img)
+	
+	sp := conv.newSpiral()
+	trackData := make([]byte, 0, int(conv.tr0))
+	ditherer := conv.passDitherer()
+	
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		
+		// Check for cancellation
+		if conv.cancelCallback != nil && conv.cancelCallback() {
+			return fmt.Errorf("conversion cancelled")
+		}
+		
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		
+		// Update progress
+		if conv.progressCallback != nil {
+			conv.progressCallback(sp.progress(step))
+		}
+		
+		trackData = conv.renderLevels(src, ditherer, step, 0, step.itr, trackData[:0])
+		if err := conv.writeTrack(trackData, step.fill, w); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+	}
+	
+	// Flush remaining buffer
+	if err := conv.flush(w); err != nil {
+		return fmt.Errorf("failed to write final buffer: %w", err)
+	}
+	
+	if err := conv.writeTrailer(w); err != nil {
+		return fmt.Errorf("failed to complete output: %w", err)
+	}
+	
+	return nil
+}
+
+// ConvertFile converts an image to an audio track file
+func (conv *Converter) ConvertFile(ctx context.Context, img image.Image, filename string) error {
+	return convertFile(ctx, img, filename, conv.Convert)
+}
+
+// convertFile runs convert into a new file and removes the file if the
+// conversion fails or is cancelled
+func convertFile(ctx context.Context, img image.Image, filename string, convert func(context.Context, image.Image, io.Writer) error) error {
+	// Create output file
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	
+	err = convert(ctx, img, file)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close output file: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
+}
+
+// TrackSize returns the length of the audio data in bytes, excluding any
+// container header
+func (conv *Converter) TrackSize() int64 {
+	var samples int64
+	sp := conv.newSpiral()
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		samples += int64(step.itr + step.fill)
+	}
+	
+	// The delay sequence only emits complete frames
+	return samples - samples%24
+}
+
+// trackStep describes one revolution of the spiral
+type trackStep struct {
+	index int
+	c     float64 // samples emitted before this track
+	itr   int     // samples taken from the image
+	fill  int     // darkest palette bytes appended after the image samples
+	r     float64 // track radius in mm
+	ri    float64 // track radius in image pixels
+	zf    int     // ordered dither column at the start of the track
+}
+
+// spiral walks the tracks of the disc in burn order. It carries the
+// tr/r/c geometry and the zf dither column from one track to the next; the
+// dither row is the track index modulo 17.
+type spiral struct {
+	tr, dtr   float64
+	r, dr, c  float64
+	zf        int
+	index     int
+	totalSize int
+}
+
+// newSpiral returns a spiral positioned at the first track
+func (conv *Converter) newSpiral() *spiral {
+	return &spiral{
+		tr:        conv.tr0,
+		dtr:       conv.dtr,
+		r:         conv.r0,
+		dr:        conv.dtr * conv.r0 / conv.tr0,
+		totalSize: conv.totalSize,
+	}
+}
+
+// next returns the current track and advances the spiral past it
+func (sp *spiral) next() (trackStep, bool) {
+	if sp.c >= float64(sp.totalSize)-sp.tr {
+		return trackStep{}, false
+	}
+	
+	step := trackStep{
+		index: sp.index,
+		c:     sp.c,
+		itr:   int(sp.tr),
+		r:     sp.r,
+		ri:    imageRadius * sp.r / discRadius,
+		zf:    sp.zf,
+	}
+	
+	sp.zf = (sp.zf + step.itr) % 5
+	
+	sp.c += sp.tr
+	ic := int(sp.c)
+	
+	// Fill remaining samples if needed
+	for int(sp.c) > ic {
+		step.fill++
+		ic++
+		sp.zf++
+		if sp.zf >= 4 {
+			sp.zf = 0
+		}
+	}
+	
+	sp.tr += sp.dtr
+	sp.r += sp.dr
+	sp.index++
+	
+	return step, true
+}
+
+// ditherTrack returns the position of the samples of the track for a
+// Ditherer
+func (step trackStep) ditherTrack() DitherTrack {
+	return DitherTrack{
+		Index:   step.index,
+		Samples: step.itr,
+		Radius:  step.r,
+		Phase:   step.zf,
+	}
+}
+
+// progress returns the completion percentage at the start of step
+func (sp *spiral) progress(step trackStep) int {
+	return int(100 * step.c / float64(sp.totalSize))
+}
+
+// renderTrack samples the gray raster along one track and appends the
+// palette bytes chosen for each sample to dst
+func (conv *Converter) renderTrack(src *raster, d Ditherer, step trackStep, dst []byte) []byte {
+	return conv.renderSamples(src, d, step, 0, step.itr, dst)
+}
+
+// renderSamples appends the palette bytes for samples [from, to) of a track
+// to dst. Samples past step.itr are the fill of the darkest palette byte.
+func (conv *Converter) renderSamples(src *raster, d Ditherer, step trackStep, from, to int, dst []byte) []byte {
+	start := len(dst)
+	dst = conv.renderLevels(src, d, step, from, to, dst)
+	for i, level := range dst[start:] {
+		dst[start+i] = conv.palette.bytes[level]
+	}
+	return dst
+}
+
+// renderLevels is renderSamples returning palette levels, which the
+// converters map to bytes as they pass them through the delay sequence
+func (conv *Converter) renderLevels(src *raster, d Ditherer, step trackStep, from, to int, dst []byte) []byte {
+	start := len(dst)
+	dst = conv.sampleTrack(src, step, from, min(to, step.itr), dst)
+	d.Dither(step.ditherTrack(), from, dst[start:], dst[start:])
+	for i := max(from, step.itr); i < to; i++ {
+		dst = append(dst, 0)
+	}
+	return dst
+}
+
+// sampleTrack appends the gray values of samples [from, to) of a track to
+// dst
+func (conv *Converter) sampleTrack(src *raster, step trackStep, from, to int, dst []byte) []byte {
+	if !conv.sampler.exact() {
+		return conv.filterTrack(src, step, from, to, dst)
+	}
+	
+	dst = slices.Grow(dst, to-from)
+	out := dst[len(dst) : len(dst)+to-from]
+	if src.placed {
+		placedTrack(out, src, step, from)
+		return dst[:len(dst)+to-from]
+	}
+	
+	cx := float64(src.Rect.Dx()) / 2
+	cy := float64(src.Rect.Dy()) / 2
+	
+	// Tracks reaching the rim of the raster sample outside of it, which the
+	// turned points leave to exactPixel
+	if step.ri+1 >= min(cx, cy) {
+		for i := range out {
+			out[i] = exactPixel(src.Gray, step, from+i)
+		}
+		return dst[:len(dst)+to-from]
+	}
+	
+	// The points are computed in 32.32 fixed point pixels, scaled exactly by
+	// a power of 2, each run of samples turning the exact point of its first
+	const scale = 1 << 32
+	var turns turnTable
+	turns.fill(step.itr, to-from)
+	for i := from; i < to; {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(step.itr))
+		px, py := step.ri*scale*cos, step.ri*scale*sin
+		run := turns[:min(rotationRun, to-i)]
+		o := out[i-from:]
+		for j := 0; j < len(run); j++ {
+			j += sampleRun(o[j:], src.Gray, run[j:], cx*scale, cy*scale, px, py)
+			if j < len(run) {
+				o[j] = exactPixel(src.Gray, step, i+j)
+			}
+		}
+		i += len(run)
+	}
+	
+	return dst[:len(dst)+to-from]
+}
+
+// sampleRun stores the pixels of the point (px, py) from the centre (cx,
+// cy) turned by turns, all in 32.32 fixed point, in out. Off the pixel
+// edges a turned point lands in the same pixel as the angle of the original
+// converter; sampleRun stops at the first point on an edge and returns its
+// index, or len(turns).
+func sampleRun(out []byte, src *image.Gray, turns []turn, cx, cy, px, py float64) int {
+	out = out[:len(turns)]
+	pix, stride := src.Pix, src.Stride
+	for j, t := range turns {
+		fx := int64(cx + px*t.cos - py*t.sin)
+		fy := int64(cy + px*t.sin + py*t.cos)
+		if nearEdge(fx) || nearEdge(fy) {
+			return j
+		}
+		out[j] = pix[int(fy>>32)*stride+int(fx>>32)]
+	}
+	return len(turns)
+}
+
+// exactPixel returns the gray value sample i of a track is taken from,
+// computed from its angle as by the original converter and clamped to the
+// raster
+func exactPixel(src *image.Gray, step trackStep, i int) byte {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	alpha := 2 * math.Pi * float64(i) / float64(step.itr)
+	xi := float64(width)/2 + step.ri*math.Cos(alpha)
+	yi := float64(height)/2 + step.ri*math.Sin(alpha)
+	x := min(max(int(xi), 0), width-1)
+	y := min(max(int(yi), 0), height-1)
+	return src.Pix[y*src.Stride+x]
+}
+
+// placedTrack stores the pixels of the samples of a track from sample from
+// on in out, src being a placed image. The placement turns and scales the
+// disc evenly, so the point of a sample in the image is the image point of
+// the first sample of its run turned like on the disc.
+func placedTrack(out []byte, src *raster, step trackStep, from int) {
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	m := src.toImage
+	var turns turnTable
+	turns.fill(step.itr, len(out))
+	for i := 0; i < len(out); {
+		sin, cos := math.Sincos(2 * math.Pi * float64(from+i) / float64(step.itr))
+		dx, dy := step.ri*cos, step.ri*sin
+		px, py := m.xx*dx+m.xy*dy, m.yx*dx+m.yy*dy
+		run := turns[:min(rotationRun, len(out)-i)]
+		for j, t := range run {
+			x := m.x0 + px*t.cos - py*t.sin
+			y := m.y0 + px*t.sin + py*t.cos
+			if x < 0 || y < 0 || int(x) >= width || int(y) >= height {
+				out[i+j] = background
+				continue
+			}
+			out[i+j] = src.Pix[int(y)*src.Stride+int(x)]
+		}
+		i += len(run)
+	}
+}
+
+// filterTrack is sampleTrack for the interpolating filters and the area
+// prefilter
+func (conv *Converter) filterTrack(src *raster, step trackStep, from, to int, dst []byte) []byte {
+	pitch := imageRadius * conv.dtr * conv.r0 / conv.tr0 / discRadius
+	f := newFootprint(step.ri*src.scale, pitch*src.scale, step.itr)
+	
+	var turns turnTable
+	turns.fill(step.itr, to-from)
+	for i := from; i < to; {
+		sin0, cos0 := math.Sincos(2 * math.Pi * float64(i) / float64(step.itr))
+		run := turns[:min(rotationRun, to-i)]
+		for _, t := range run {
+			sin := sin0*t.cos + cos0*t.sin
+			cos := cos0*t.cos - sin0*t.sin
+			x, y := src.toImage.apply(step.ri*cos, step.ri*sin)
+			sin, cos = sin*src.turn.cos+cos*src.turn.sin, cos*src.turn.cos-sin*src.turn.sin
+			dst = append(dst, conv.sampler.sample(src, x, y, sin, cos, f))
+		}
+		i += len(run)
+	}
+	
+	return dst
+}
+
+// passDitherer returns the ditherer of one pass along the spiral, a fresh
+// copy of a sequential engine
+func (conv *Converter) passDitherer() Ditherer {
+	if s, ok := conv.ditherer.(SequentialDitherer); ok {
+		return s.Clone()
+	}
+	return conv.ditherer
+}
+
+// source returns the raster the converter samples for img
+func (conv *Converter) source(img image.Image) *raster {
+	if conv.placement != nil {
+		return conv.placement.raster(img, conv.discType)
+	}
+	return discRaster(img)
+}
+
+// writeTrack passes the palette levels of a rendered track and its fill
+// samples through the delay sequence as their bytes
+func (conv *Converter) writeTrack(levels []byte, fill int, w io.Writer) error {
+	if err := conv.interleave(levels, w); err != nil {
+		return err
+	}
+	dark := []byte{0}
+	for i := 0; i < fill; i++ {
+		if err := conv.interleave(dark, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset clears the interleave state so every conversion starts from the same point
+func (conv *Converter) reset() {
+	conv.delayLine = [24 * delayFrames]byte{}
+	conv.frame = 28*D - 1
+	conv.pinf = 0
+	conv.out = conv.out[:0]
+}
+
+// flush writes out the bytes left in the output batch
+func (conv *Converter) flush(w io.Writer) error {
+	if len(conv.out) > 0 {
+		if _, err := w.Write(conv.out); err != nil {
+			return err
+		}
+		conv.out = conv.out[:0]
+	}
+	return nil
+}
+
+// interleave processes the bytes of palette levels through the delay
+// sequence (from original algorithm). The original keeps the last 28*D
+// frames in a circular buffer; here the frames run along delayLine without
+// wrapping, each byte stored at its delay behind the frame being filled.
+// Once a frame is filled the one 28*D-1 frames before it has all its bytes
+// and is appended to the output batch.
+func (conv *Converter) interleave(levels []byte, w io.Writer) error {
+	line := conv.delayLine[:]
+	toByte := &conv.palette.bytes
+	for len(levels) > 0 {
+		base := conv.frame * 24
+		if n := 24 - conv.pinf; n < 24 || len(levels) < 24 {
+			n = min(n, len(levels))
+			for k, delay := range delays[conv.pinf : conv.pinf+n] {
+				line[base+delay] = toByte[levels[k]]
+			}
+			conv.pinf += n
+			levels = levels[n:]
+		} else {
+			// A whole frame
+			frame := (*[24]byte)(levels)
+			for k, delay := range delays {
+				line[base+delay] = toByte[frame[k]]
+			}
+			conv.pinf = 24
+			levels = levels[24:]
+		}
+		if conv.pinf < 24 {
+			break
+		}
+		
+		conv.pinf = 0
+		conv.out = append(conv.out, conv.delayLine[base-24*(28*D-1):][:24]...)
+		conv.frame++
+		if conv.frame == delayFrames {
+			// Move the frames still in the delay sequence to the start
+			copy(conv.delayLine[:], conv.delayLine[24*(delayFrames-28*D):])
+			conv.frame = 28 * D
+		}
+		
+		if len(conv.out) >= writeBatch {
+			if err := conv.flush(w); err != nil {
+				return err
+			}
+		}
+	}
+	
+	return nil
+}
@@ -0,0 +1,228 @@
+package encoder
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// trackJob represents a single track processing job
+type trackJob struct {
+	step   trackStep
+	result chan trackResult
+}
+
+// trackResult holds the result of processing a track
+type trackResult struct {
+	trackIndex int
+	data       []byte
+	err        error
+}
+
+// MultiThreadedConverter extends the basic converter with parallel processing
+type MultiThreadedConverter struct {
+	*Converter
+	numWorkers int
+}
+
+// NewMultiThreadedConverter creates a new multi-threaded converter
+func NewMultiThreadedConverter(opts Options) *MultiThreadedConverter {
+	numWorkers := runtime.NumCPU()
+	if numWorkers > 8 {
+		numWorkers = 8 // Cap at 8 to avoid memory issues
+	}
+
+	return &MultiThreadedConverter{
+		Converter:  NewConverter(opts),
+		numWorkers: numWorkers,
+	}
+}
+
+// Convert converts an image using ConvertParallel
+func (mtconv *MultiThreadedConverter) Convert(ctx context.Context, img image.Image, w io.Writer) error {
+	return mtconv.ConvertParallel(ctx, img, w)
+}
+
+// ConvertFile converts an image to an audio track file using ConvertParallel
+func (mtconv *MultiThreadedConverter) ConvertFile(ctx context.Context, img image.Image, filename string) error {
+	return convertFile(ctx, img, filename, mtconv.ConvertParallel)
+}
+
+// ConvertParallel converts an image using multiple goroutines for track processing.
+//
+// Workers only sample and dither the image; the rendered tracks are passed
+// through the delay sequence in spiral order, so the output is byte-for-byte
+// the same as Convert. A sequential dithering engine dithers the sampled
+// tracks in spiral order as they are written. At most 2*numWorkers tracks
+// are held in memory at a time.
+func (mtconv *MultiThreadedConverter) ConvertParallel(ctx context.Context, img image.Image, w io.Writer) error {
+	if mtconv.optionsErr != nil {
+		return mtconv.optionsErr
+	}
+	mtconv.reset()
+
+	w, err := mtconv.writeHeader(w)
+	if err != nil {
+		return err
+	}
+
+	// Convert the image to gray values once for all workers
+	src := mtconv.source(img)
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan trackJob, mtconv.numWorkers)
+	// pending holds the jobs in spiral order; its capacity bounds the reorder window
+	pending := make(chan trackJob, mtconv.numWorkers*2)
+
+	// Start worker goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < mtconv.numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mtconv.trackWorker(workCtx, src, jobs)
+		}()
+	}
+
+	// Generate jobs for tracks
+	sp := mtconv.newSpiral()
+	var genErr error
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+
+		for {
+			step, ok := sp.next()
+			if !ok {
+				return
+			}
+
+			if mtconv.cancelCallback != nil && mtconv.cancelCallback() {
+				genErr = fmt.Errorf("conversion cancelled")
+				return
+			}
+
+			job := trackJob{step: step, result: make(chan trackResult, 1)}
+
+			select {
+			case pending <- job:
+			case <-workCtx.Done():
+				return
+			}
+
+			select {
+			case jobs <- job:
+			case <-workCtx.Done():
+				return
+			}
+		}
+	}()
+
+	// Write tracks in order through the delay sequence
+	err = mtconv.writeResults(workCtx, pending, sp, w)
+
+	cancel()
+	for range pending {
+		// Drain so the generator can exit
+	}
+	wg.Wait()
+
+	if err == nil {
+		err = genErr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// writeResults consumes the pending jobs in order and writes each track
+// through the delay sequence, returning the first error encountered
+func (mtconv *MultiThreadedConverter) writeResults(ctx context.Context, pending <-chan trackJob, sp *spiral, w io.Writer) error {
+	ditherer, sequential := mtconv.passDitherer().(SequentialDitherer)
+	for job := range pending {
+		var result trackResult
+		select {
+		case result = <-job.result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if result.err != nil {
+			return fmt.Errorf("track %d: %w", result.trackIndex, result.err)
+		}
+
+		// Update progress
+		if mtconv.progressCallback != nil {
+			mtconv.progressCallback(sp.progress(job.step))
+		}
+
+		if sequential {
+			ditherer.Dither(job.step.ditherTrack(), 0, result.data, result.data)
+		}
+		if err := mtconv.writeTrack(result.data, job.step.fill, w); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+	}
+
+	// Flush remaining buffer
+	if err := mtconv.flush(w); err != nil {
+		return fmt.Errorf("failed to write final buffer: %w", err)
+	}
+
+	if err := mtconv.writeTrailer(w); err != nil {
+		return fmt.Errorf("failed to complete output: %w", err)
+	}
+
+	return nil
+}
+
+// trackWorker processes individual tracks in parallel
+func (mtconv *MultiThreadedConverter) trackWorker(ctx context.Context, src *raster, jobs <-chan trackJob) {
+	for {
+		select {
+		case job, ok := <-jobs:
+			if !ok {
+				return // Channel closed, worker done
+			}
+
+			data, err := mtconv.processTrack(ctx, src, job)
+
+			// The result channel is buffered, so this never blocks
+			job.result <- trackResult{
+				trackIndex: job.step.index,
+				data:       data,
+				err:        err,
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// processTrack processes a single track and returns its palette levels, or
+// its gray values for a sequential dithering engine
+func (mtconv *MultiThreadedConverter) processTrack(ctx context.Context, src *raster, job trackJob) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	trackData := make([]byte, 0, job.step.itr)
+	if _, ok := mtconv.ditherer.(SequentialDitherer); ok {
+		return mtconv.sampleTrack(src, job.step, 0, job.step.itr, trackData), nil
+	}
+	return mtconv.renderLevels(src, mtconv.ditherer, job.step, 0, job.step.itr, trackData), nil
+}
+
+// SetNumWorkers allows customizing the number of worker goroutines
+func (mtconv *MultiThreadedConverter) SetNumWorkers(numWorkers int) {
+	if numWorkers > 0 && numWorkers <= 16 {
+		mtconv.numWorkers = numWorkers
+	}
+}
\ No newline at end of file
@@ -0,0 +1,222 @@
+package encoder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// testOptions is a disc of a few hundred tracks, small enough to convert in
+// a fraction of a second
+func testOptions() Options {
+	return Options{
+		Tr0:       2000,
+		Dtr:       5,
+		R0:        25,
+		DiscType:  "cd",
+		totalSize: 1 << 20,
+	}
+}
+
+// testImage returns a disc raster with gray values changing along both the
+// radius and the angle, so every track samples a range of levels
+func testImage() *image.Gray {
+	const size = 2 * imageRadius
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Pix[y*img.Stride+x] = uint8((x*y)>>7 + (x*x+y*y)>>11)
+		}
+	}
+	return img
+}
+
+// convert returns the raw track conv writes for img
+func convert(t *testing.T, conv Encoder, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := conv.Convert(context.Background(), img, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// paletteStream returns the palette bytes conv renders for img in spiral
+// order, the fill of every track included
+func paletteStream(conv *Converter, img image.Image) []byte {
+	src := conv.source(img)
+	ditherer := conv.passDitherer()
+	sp := conv.newSpiral()
+	var stream, levels []byte
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		levels = conv.renderLevels(src, ditherer, step, 0, step.itr, levels[:0])
+		for i := 0; i < step.fill; i++ {
+			levels = append(levels, 0)
+		}
+		for _, level := range levels {
+			stream = append(stream, conv.palette.Byte(level))
+		}
+	}
+	return stream
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	opts := testOptions()
+	img := testImage()
+	conv := NewConverter(opts)
+	raw := convert(t, conv, img)
+	if int64(len(raw)) != conv.TrackSize() {
+		t.Fatalf("wrote %d bytes, TrackSize is %d", len(raw), conv.TrackSize())
+	}
+
+	got, err := io.ReadAll(NewDeinterleaver(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := paletteStream(NewConverter(opts), img)
+	if len(got) != len(raw) {
+		t.Fatalf("deinterleaved %d of %d bytes", len(got), len(raw))
+	}
+
+	// Only bytes delayed past the end of the track may be lost, and they are
+	// returned as 0
+	tail := len(got) - (maxLag+1)*24
+	for i, b := range got {
+		if b != want[i] && (b != 0 || i < tail) {
+			t.Fatalf("palette byte %d is %#02x, rendered %#02x", i, b, want[i])
+		}
+	}
+
+	levels := map[uint8]bool{}
+	for _, b := range got[:tail] {
+		levels[conv.palette.Level(b)] = true
+	}
+	if len(levels) != conv.palette.Len() {
+		t.Errorf("track holds %d of %d palette levels", len(levels), conv.palette.Len())
+	}
+
+	if err := NewTrackDecoder(opts).Verify(bytes.NewReader(raw), img); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestConvertGolden pins the track of the default options, as written by
+// the converter before the flat raster and trig tables, so that speeding up
+// the hot loop cannot change its output
+func TestConvertGolden(t *testing.T) {
+	const want = "7eca788747ea4305c88690a4693cedcc183f88b1b3068b86ece9802e5085617f"
+	raw := convert(t, NewConverter(testOptions()), testImage())
+	if sum := sha256.Sum256(raw); hex.EncodeToString(sum[:]) != want {
+		t.Errorf("track of %d bytes has SHA-256 %x, expected %s", len(raw), sum, want)
+	}
+}
+
+func BenchmarkConvert(b *testing.B) {
+	img := testImage()
+	conv := NewConverter(testOptions())
+	b.SetBytes(conv.TrackSize())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := conv.Convert(context.Background(), img, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestConvertersMatch checks that the single and multi-threaded converters
+// and TrackReader give the same track
+func TestConvertersMatch(t *testing.T) {
+	img := testImage()
+	tests := []Options{
+		{},
+		{Dither: DitherRandom, Seed: 3},
+		{Dither: DitherBayer},
+		{Dither: DitherDiffusion},
+		{Sampling: SamplingBicubic},
+		{Placement: &Placement{Scale: 1.5, OffsetX: 4, Rotation: 30}},
+		{Placement: &Placement{Rotation: 90}, Sampling: SamplingBilinear, AreaFilter: true},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.totalSize = 1 << 18
+		opts.Dither, opts.Seed = tt.Dither, tt.Seed
+		opts.Sampling, opts.AreaFilter = tt.Sampling, tt.AreaFilter
+		opts.Placement = tt.Placement
+		name := fmt.Sprintf("%s/%s/%t/%v", tt.Dither, tt.Sampling, tt.AreaFilter, tt.Placement)
+
+		t.Run(name, func(t *testing.T) {
+			want := convert(t, NewConverter(opts), img)
+
+			mt := NewMultiThreadedConverter(opts)
+			mt.SetNumWorkers(3)
+			if got := convert(t, mt, img); !bytes.Equal(got, want) {
+				t.Error("multi-threaded track differs")
+			}
+
+			r, err := NewTrackReader(opts, img)
+			if opts.Dither == DitherDiffusion {
+				if err == nil {
+					t.Error("TrackReader accepted a sequential dithering engine")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, r.Size())
+			if _, err := r.ReadAt(got, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("TrackReader track differs")
+			}
+		})
+	}
+}
+
+func TestTrackReaderRandomOffsets(t *testing.T) {
+	opts := testOptions()
+	opts.Dither = DitherRandom
+	img := testImage()
+	want := convert(t, NewConverter(opts), img)
+
+	r, err := NewTrackReader(opts, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != int64(len(want)) {
+		t.Fatalf("Size is %d, track has %d bytes", r.Size(), len(want))
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		off := rng.Int63n(r.Size() + 100)
+		p := make([]byte, rng.Intn(3*readChunk))
+		n, err := r.ReadAt(p, off)
+
+		end := min(off+int64(len(p)), r.Size())
+		wantN := int(max(end-off, 0))
+		if n != wantN {
+			t.Fatalf("ReadAt(%d bytes, %d) read %d bytes, expected %d", len(p), off, n, wantN)
+		}
+		if n < len(p) && err != io.EOF {
+			t.Fatalf("ReadAt(%d bytes, %d) returned %v after a short read", len(p), off, err)
+		}
+		if n == len(p) && err != nil {
+			t.Fatalf("ReadAt(%d bytes, %d): %v", len(p), off, err)
+		}
+		if n > 0 && !bytes.Equal(p[:n], want[off:end]) {
+			t.Fatalf("ReadAt(%d bytes, %d) differs from Convert", len(p), off)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package encoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// FramesPerSecond is the number of CD sectors per second of audio
+const FramesPerSecond = 75
+
+// cdTextMaxLen is the longest CD-Text string most burners accept
+const cdTextMaxLen = 80
+
+// CueTrack describes one audio track in a cue sheet
+type CueTrack struct {
+	Title     string
+	Performer string
+	Start     int64 // Byte offset of INDEX 01 in the BIN file
+	Pregap    int64 // Bytes before Start that belong to the pregap (INDEX 00)
+	Silence   int64 // Sectors of silent pregap added by the burner (PREGAP)
+	Data      bool  // Data track of a mixed mode disc, only set by ParseCueSheet
+}
+
+// CueSheet describes a BIN file of CD-DA and the tracks it contains
+type CueSheet struct {
+	Title     string
+	Performer string
+	File      string   // BIN file name, relative to the cue sheet
+	Files     []string // All files of a sheet read by ParseCueSheet, File first
+	Tracks    []CueTrack
+}
+
+// NewCueSheet returns a cue sheet for a BIN file holding a single track.
+//
+// Track 1 gets the mandatory two second pregap as PREGAP, so the burner
+// writes silence first and the image data starts at INDEX 01, the same
+// layout as 'cdrecord -audio'.
+func NewCueSheet(binFile, title, performer string) *CueSheet {
+	return NewSplitCueSheet(binFile, title, performer, []int64{0})
+}
+
+// NewSplitCueSheet returns a cue sheet dividing a BIN file into tracks that
+// start at the given byte offsets, as returned by Converter.SplitEvenly or
+// Converter.SplitAtRadii. Only track 1 has a pregap; the following tracks
+// have none, so the data stays contiguous when burned disc-at-once.
+func NewSplitCueSheet(binFile, title, performer string, starts []int64) *CueSheet {
+	cs := &CueSheet{
+		Title:     title,
+		Performer: performer,
+		File:      binFile,
+	}
+
+	for i, start := range starts {
+		track := CueTrack{
+			Title:     title,
+			Performer: performer,
+			Start:     start,
+		}
+		if len(starts) > 1 {
+			track.Title = fmt.Sprintf("%s (%d/%d)", title, i+1, len(starts))
+		}
+		if i == 0 {
+			track.Silence = 2 * FramesPerSecond
+		}
+		cs.Tracks = append(cs.Tracks, track)
+	}
+
+	return cs
+}
+
+// WriteTo writes the cue sheet in the format read by cdrdao and most
+// burning tools
+func (cs *CueSheet) WriteTo(w io.Writer) (int64, error) {
+	file, err := cueFile(cs.File)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	fmt.Fprintf(cw, "REM COMMENT \"cdimage\"\n")
+	if cs.Performer != "" {
+		fmt.Fprintf(cw, "PERFORMER %s\n", cueString(cs.Performer))
+	}
+	if cs.Title != "" {
+		fmt.Fprintf(cw, "TITLE %s\n", cueString(cs.Title))
+	}
+	fmt.Fprintf(cw, "FILE %s BINARY\n", file)
+
+	for i, t := range cs.Tracks {
+		if t.Start%SectorSize != 0 || t.Pregap%SectorSize != 0 || t.Pregap > t.Start {
+			return cw.n, fmt.Errorf("track %d is not aligned to %d-byte sectors", i+1, SectorSize)
+		}
+
+		fmt.Fprintf(cw, "  TRACK %02d AUDIO\n", i+1)
+		if t.Title != "" {
+			fmt.Fprintf(cw, "    TITLE %s\n", cueString(t.Title))
+		}
+		if t.Performer != "" {
+			fmt.Fprintf(cw, "    PERFORMER %s\n", cueString(t.Performer))
+		}
+		if t.Silence > 0 {
+			fmt.Fprintf(cw, "    PREGAP %s\n", MSF(t.Silence))
+		}
+		if t.Pregap > 0 {
+			fmt.Fprintf(cw, "    INDEX 00 %s\n", MSF((t.Start-t.Pregap)/SectorSize))
+		}
+		fmt.Fprintf(cw, "    INDEX 01 %s\n", MSF(t.Start/SectorSize))
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ParseCueSheet reads a cue sheet of CD-DA, as written by WriteTo or by
+// ripping and burning tools. The times of a cue sheet count from the start
+// of the FILE they follow, so size must return the length in bytes of the
+// audio data of every file; Start and Pregap then count through the files
+// in order, as if they were a single BIN file. This also places an INDEX 00
+// that ends a file before the INDEX 01 that starts the next one.
+//
+// Data tracks in raw 2352-byte sectors are kept and marked, tracks of other
+// sector sizes and big endian or compressed audio files are rejected.
+func ParseCueSheet(r io.Reader, size func(file string) (int64, error)) (*CueSheet, error) {
+	cs := &CueSheet{}
+	var base, fileSize int64
+	var track *CueTrack
+	var pregapAt int64 = -1
+
+	// finishTrack checks the indexes of the current track
+	finishTrack := func() error {
+		if track == nil {
+			return nil
+		}
+		n := len(cs.Tracks)
+		if track.Start < 0 {
+			return fmt.Errorf("track %d has no INDEX 01", n)
+		}
+		if pregapAt >= 0 {
+			track.Pregap = track.Start - pregapAt
+		}
+		if n > 1 {
+			prev := cs.Tracks[n-2]
+			if track.Start-track.Pregap < prev.Start {
+				return fmt.Errorf("track %d starts before track %d", n, n-1)
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields, err := cueFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		args := fields[1:]
+		arg := func(i int) string {
+			if i < len(args) {
+				return args[i]
+			}
+			return ""
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(0)
+			} else {
+				cs.Title = arg(0)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(0)
+			} else {
+				cs.Performer = arg(0)
+			}
+		case "FILE":
+			switch strings.ToUpper(arg(1)) {
+			case "BINARY", "WAVE", "FLAC":
+			case "MOTOROLA":
+				return nil, fmt.Errorf("line %d: big endian audio (MOTOROLA) is not supported", line)
+			default:
+				return nil, fmt.Errorf("line %d: unsupported file type '%s'", line, arg(1))
+			}
+			if arg(0) == "" {
+				return nil, fmt.Errorf("line %d: FILE without a file name", line)
+			}
+			if len(cs.Files) == 0 {
+				cs.File = arg(0)
+			}
+			base += fileSize
+			fileSize, err = size(arg(0))
+			if err != nil {
+				return nil, err
+			}
+			cs.Files = append(cs.Files, arg(0))
+		case "TRACK":
+			if len(cs.Files) == 0 {
+				return nil, fmt.Errorf("line %d: TRACK before FILE", line)
+			}
+			if err := finishTrack(); err != nil {
+				return nil, err
+			}
+			if number, err := strconv.Atoi(arg(0)); err != nil || number != len(cs.Tracks)+1 {
+				return nil, fmt.Errorf("line %d: expected track %d, got '%s'", line, len(cs.Tracks)+1, arg(0))
+			}
+			data := false
+			switch strings.ToUpper(arg(1)) {
+			case "AUDIO":
+			case "MODE1/2352", "MODE2/2352":
+				data = true
+			default:
+				return nil, fmt.Errorf("line %d: unsupported track mode '%s' (need %d-byte sectors)", line, arg(1), SectorSize)
+			}
+			cs.Tracks = append(cs.Tracks, CueTrack{Start: -1, Data: data})
+			track = &cs.Tracks[len(cs.Tracks)-1]
+			pregapAt = -1
+		case "INDEX":
+			if track == nil {
+				return nil, fmt.Errorf("line %d: INDEX before TRACK", line)
+			}
+			number, err := strconv.Atoi(arg(0))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid index number '%s'", line, arg(0))
+			}
+			sectors, err := parseMSF(arg(1))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			offset := sectors * SectorSize
+			if offset > fileSize {
+				return nil, fmt.Errorf("line %d: index %s is past the end of %s", line, arg(1), cs.Files[len(cs.Files)-1])
+			}
+			switch number {
+			case 0:
+				pregapAt = base + offset
+			case 1:
+				track.Start = base + offset
+			}
+		case "PREGAP":
+			if track == nil {
+				return nil, fmt.Errorf("line %d: PREGAP before TRACK", line)
+			}
+			sectors, err := parseMSF(arg(0))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			track.Silence = sectors
+		case "POSTGAP":
+			return nil, fmt.Errorf("line %d: POSTGAP is not supported", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cue sheet: %w", err)
+	}
+	if err := finishTrack(); err != nil {
+		return nil, err
+	}
+	if len(cs.Tracks) == 0 {
+		return nil, fmt.Errorf("cue sheet has no tracks")
+	}
+	return cs, nil
+}
+
+// cueFields splits a line of a cue sheet into words and quoted strings
+func cueFields(line string) ([]string, error) {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+		} else {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+		}
+		line = strings.TrimLeft(line, " \t")
+	}
+	return fields, nil
+}
+
+// parseMSF parses a mm:ss:ff time of a cue sheet into a sector count
+func parseMSF(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time '%s' (must be mm:ss:ff)", s)
+	}
+	var v [3]int64
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time '%s' (must be mm:ss:ff)", s)
+		}
+		v[i] = n
+	}
+	if v[1] >= 60 || v[2] >= FramesPerSecond {
+		return 0, fmt.Errorf("invalid time '%s' (must be mm:ss:ff)", s)
+	}
+	return (v[0]*60+v[1])*FramesPerSecond + v[2], nil
+}
+
+// MSF formats a sector count as the mm:ss:ff time used by cue sheets
+func MSF(sectors int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d",
+		sectors/(60*FramesPerSecond), sectors/FramesPerSecond%60, sectors%FramesPerSecond)
+}
+
+// cueString quotes s for a cue sheet, replacing characters cue parsers and
+// CD-Text cannot handle and truncating it to the CD-Text length limit
+func cueString(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '"':
+			return '\''
+		case r < ' ':
+			return ' '
+		case r > '~':
+			return '?'
+		}
+		return r
+	}, s)
+	if len(s) > cdTextMaxLen {
+		s = s[:cdTextMaxLen]
+	}
+	return `"` + s + `"`
+}
+
+// cueFile quotes a file name for a cue sheet or TOC file. Unlike cueString
+// it keeps the name as it is, so it still finds the file; a name that
+// cannot be quoted is an error.
+func cueFile(name string) (string, error) {
+	if strings.ContainsAny(name, "\"\r\n") {
+		return "", fmt.Errorf("file name %q cannot be written to a cue sheet", name)
+	}
+	return `"` + name + `"`, nil
+}
+
+// countWriter counts the bytes written and keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
\ No newline at end of file
@@ -0,0 +1,181 @@
+package encoder
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeCue returns the cue sheet cs writes
+func writeCue(t *testing.T, cs *CueSheet) string {
+	t.Helper()
+	var b strings.Builder
+	n, err := cs.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo reported %d of %d bytes", n, b.Len())
+	}
+	return b.String()
+}
+
+func TestCueSheetFileName(t *testing.T) {
+	long := strings.Repeat("verzeichnis/", 10) + "Fotos-Ü.bin"
+	for _, name := range []string{"Fotos-Ü.bin", long, "it's (1).bin"} {
+		cue := writeCue(t, NewCueSheet(name, "Fotos-Ü \"2024\"", "cdimage"))
+		if !strings.Contains(cue, "\nFILE \""+name+"\" BINARY\n") {
+			t.Errorf("FILE of %s not kept as it is:\n%s", name, cue)
+		}
+		// CD-Text is still limited to printable ASCII
+		if !strings.Contains(cue, "\nTITLE \"Fotos-? '2024'\"\n") {
+			t.Errorf("TITLE not sanitised:\n%s", cue)
+		}
+	}
+
+	if _, err := NewCueSheet("a\"b.bin", "", "").WriteTo(&strings.Builder{}); err == nil {
+		t.Error("file name with a quote accepted")
+	}
+}
+
+func TestCueSheetWriteTo(t *testing.T) {
+	cs := NewSplitCueSheet("image.bin", "Disc", "cdimage", []int64{0, 1000 * SectorSize, 9003 * SectorSize})
+	cs.Tracks[1].Pregap = 150 * SectorSize
+	got := writeCue(t, cs)
+
+	want := `REM COMMENT "cdimage"
+PERFORMER "cdimage"
+TITLE "Disc"
+FILE "image.bin" BINARY
+  TRACK 01 AUDIO
+    TITLE "Disc (1/3)"
+    PERFORMER "cdimage"
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Disc (2/3)"
+    PERFORMER "cdimage"
+    INDEX 00 00:11:25
+    INDEX 01 00:13:25
+  TRACK 03 AUDIO
+    TITLE "Disc (3/3)"
+    PERFORMER "cdimage"
+    INDEX 01 02:00:03
+`
+	if got != want {
+		t.Errorf("cue sheet is\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestCueSheetWriteToAlignment(t *testing.T) {
+	tests := []CueTrack{
+		{Start: 1000*SectorSize + 4},
+		{Start: 1000 * SectorSize, Pregap: 10},
+		{Start: 100 * SectorSize, Pregap: 150 * SectorSize},
+	}
+	for _, tt := range tests {
+		cs := NewSplitCueSheet("image.bin", "", "", []int64{0})
+		cs.Tracks = append(cs.Tracks, tt)
+		if _, err := cs.WriteTo(&strings.Builder{}); err == nil || !strings.Contains(err.Error(), "track 2") {
+			t.Errorf("start %d, pregap %d: got %v, expected an error for track 2", tt.Start, tt.Pregap, err)
+		}
+	}
+}
+
+// testCueSheet spreads four tracks over two files of 300 and 400 sectors:
+// track 2 has its INDEX 00 in the first file, track 3 is a data track and
+// tracks 1 and 4 have a PREGAP
+const testCueSheet = `REM COMMENT "test"
+PERFORMER "cdimage"
+TITLE "Mixed"
+FILE "a.bin" BINARY
+  TRACK 01 AUDIO
+    TITLE "One"
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:03:00
+FILE "b b.bin" BINARY
+    INDEX 01 00:00:00
+  TRACK 03 MODE1/2352
+    INDEX 01 00:02:00
+  TRACK 04 AUDIO
+    PREGAP 00:01:00
+    INDEX 00 00:04:00
+    INDEX 01 00:04:50
+`
+
+// testCueFiles returns the size function of the files of testCueSheet
+func testCueFiles(name string) (int64, error) {
+	switch name {
+	case "a.bin":
+		return 300 * SectorSize, nil
+	case "b b.bin":
+		return 400 * SectorSize, nil
+	}
+	return 0, fmt.Errorf("no file %s", name)
+}
+
+func TestParseCueSheet(t *testing.T) {
+	cs, err := ParseCueSheet(strings.NewReader(testCueSheet), testCueFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Title != "Mixed" || cs.Performer != "cdimage" || cs.File != "a.bin" || !slices.Equal(cs.Files, []string{"a.bin", "b b.bin"}) {
+		t.Errorf("sheet %q by %q, files %q", cs.Title, cs.Performer, cs.Files)
+	}
+
+	want := []CueTrack{
+		{Title: "One", Start: 0, Silence: 150},
+		{Start: 300 * SectorSize, Pregap: 75 * SectorSize},
+		{Start: 450 * SectorSize, Data: true},
+		{Start: 650 * SectorSize, Pregap: 50 * SectorSize, Silence: 75},
+	}
+	if !slices.Equal(cs.Tracks, want) {
+		t.Errorf("tracks\n%+v\nexpected\n%+v", cs.Tracks, want)
+	}
+}
+
+// TestParseCueSheetRoundTrip reads back a split sheet from WriteTo
+func TestParseCueSheetRoundTrip(t *testing.T) {
+	cs := NewSplitCueSheet("image.bin", "Disc", "cdimage", []int64{0, 1000 * SectorSize, 9003 * SectorSize})
+	cs.Tracks[1].Pregap = 150 * SectorSize
+	got, err := ParseCueSheet(strings.NewReader(writeCue(t, cs)), func(string) (int64, error) {
+		return 10000 * SectorSize, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Files = []string{"image.bin"}
+	if !reflect.DeepEqual(got, cs) {
+		t.Errorf("read back\n%+v\nexpected\n%+v", got, cs)
+	}
+}
+
+func TestParseCueSheetErrors(t *testing.T) {
+	tests := []struct {
+		name, sheet, err string
+	}{
+		{"index past end", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:04:01\n", "past the end of a.bin"},
+		{"index past end of first file", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:00:00\nTRACK 02 AUDIO\nINDEX 00 00:04:10\nFILE \"b b.bin\" BINARY\nINDEX 01 00:00:00\n", "past the end of a.bin"},
+		{"motorola", "FILE \"a.bin\" MOTOROLA\nTRACK 01 AUDIO\nINDEX 01 00:00:00\n", "MOTOROLA"},
+		{"postgap", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:00:00\nPOSTGAP 00:02:00\n", "POSTGAP"},
+		{"file type", "FILE \"a.bin\" AIFF\n", "unsupported file type"},
+		{"mode", "FILE \"a.bin\" BINARY\nTRACK 01 MODE1/2048\n", "unsupported track mode"},
+		{"track before file", "TRACK 01 AUDIO\n", "TRACK before FILE"},
+		{"track number", "FILE \"a.bin\" BINARY\nTRACK 02 AUDIO\n", "expected track 1"},
+		{"no index 01", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 00 00:00:00\n", "no INDEX 01"},
+		{"overlap", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:02:00\nTRACK 02 AUDIO\nINDEX 01 00:01:00\n", "starts before track 1"},
+		{"time", "FILE \"a.bin\" BINARY\nTRACK 01 AUDIO\nINDEX 01 00:00:75\n", "invalid time"},
+		{"missing file", "FILE \"c.bin\" BINARY\n", "no file c.bin"},
+		{"no tracks", "FILE \"a.bin\" BINARY\n", "no tracks"},
+	}
+	for _, tt := range tests {
+		_, err := ParseCueSheet(strings.NewReader(tt.sheet), testCueFiles)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, expected an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
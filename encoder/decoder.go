@@ -0,0 +1,355 @@
+package encoder
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// LevelUnknown marks a sample whose byte is not a palette byte, or whose
+// byte was lost at the end of the track
+const LevelUnknown = 0xff
+
+// PaletteLevel returns the level (0 darkest to 3 brightest) of a track byte
+// in DefaultPalette, or LevelUnknown if b is not a palette byte
+func PaletteLevel(b byte) uint8 {
+	return DefaultPalette.Level(b)
+}
+
+// Deinterleaver undoes the delay sequence of a raw track, giving back the
+// palette bytes in spiral order as the Converter generated them.
+//
+// The last bytes of the palette stream are delayed past the end of the raw
+// track and never written; they are returned as 0, which NewPalette does not
+// accept as a palette byte, so they decode as LevelUnknown. The output has
+// the same length as the input, rounded down to whole 24-byte frames.
+type Deinterleaver struct {
+	r    *bufio.Reader
+	ring [][24]byte // the last maxLag+1 raw frames
+	in   int64      // raw frames read
+	out  int64      // palette frames returned
+	eof  bool
+
+	frame [24]byte // current palette frame
+	pos   int      // bytes of frame already read
+}
+
+// NewDeinterleaver returns a Deinterleaver reading the raw track from r
+func NewDeinterleaver(r io.Reader) *Deinterleaver {
+	return &Deinterleaver{
+		r:    bufio.NewReaderSize(r, 64*1024),
+		ring: make([][24]byte, maxLag+1),
+		pos:  24,
+	}
+}
+
+// Read reads palette bytes
+func (d *Deinterleaver) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if d.pos == 24 {
+			more, err := d.nextFrame()
+			if err != nil {
+				return n, err
+			}
+			if !more {
+				break
+			}
+		}
+		c := copy(p[n:], d.frame[d.pos:])
+		d.pos += c
+		n += c
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// nextFrame gathers the next palette frame from the raw frames it was
+// spread over
+func (d *Deinterleaver) nextFrame() (bool, error) {
+	for !d.eof && d.in <= d.out+int64(maxLag) {
+		frame := &d.ring[d.in%int64(len(d.ring))]
+		if _, err := io.ReadFull(d.r, frame[:]); err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				return false, err
+			}
+			d.eof = true
+			break
+		}
+		d.in++
+	}
+	if d.out >= d.in {
+		return false, nil
+	}
+
+	g := d.out
+	for s := range d.frame {
+		src := g + int64(slotLag[s])
+		if src < d.in {
+			d.frame[s] = d.ring[src%int64(len(d.ring))][slotOutput[s]]
+		} else {
+			d.frame[s] = 0
+		}
+	}
+	d.out++
+	d.pos = 0
+	return true, nil
+}
+
+// DecodedTrack holds the palette levels of one revolution of the spiral.
+// Sample i lies at angle 2*pi*i/len(Levels) on a circle of the given radius.
+type DecodedTrack struct {
+	Index  int     // Track number, 0 for the track at r0
+	Radius float64 // Radius in mm
+	Levels []uint8 // Palette index of every image sample, or LevelUnknown
+}
+
+// TrackInfo locates one revolution of the spiral in the palette stream
+type TrackInfo struct {
+	Index   int     // Track number, 0 for the track at r0
+	Radius  float64 // Radius in mm
+	Offset  int64   // Offset of the first sample in the palette stream
+	Samples int     // Image samples in the track
+	Fill    int     // Fill samples following the image samples
+}
+
+// TrackDecoder splits a raw track into the spiral tracks it was generated
+// from. It must be created with the tr0/dtr/r0, disc type and palette the
+// track was converted with. A TrackDecoder is safe for concurrent use.
+type TrackDecoder struct {
+	conv   *Converter
+	steps  []trackStep
+	tracks []TrackInfo
+}
+
+// NewTrackDecoder returns a decoder for tracks converted with opts
+func NewTrackDecoder(opts Options) *TrackDecoder {
+	td := &TrackDecoder{conv: NewConverter(opts)}
+	td.steps, td.tracks = td.conv.layout()
+	return td
+}
+
+// layout walks the whole spiral and returns its tracks in burn order
+func (conv *Converter) layout() ([]trackStep, []TrackInfo) {
+	var (
+		steps  []trackStep
+		tracks []TrackInfo
+		offset int64
+	)
+	sp := conv.newSpiral()
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		steps = append(steps, step)
+		tracks = append(tracks, TrackInfo{
+			Index:   step.index,
+			Radius:  step.r,
+			Offset:  offset,
+			Samples: step.itr,
+			Fill:    step.fill,
+		})
+		offset += int64(step.itr + step.fill)
+	}
+	return steps, tracks
+}
+
+// Tracks returns the layout of the spiral tracks in burn order, with
+// increasing radius
+func (td *TrackDecoder) Tracks() []TrackInfo {
+	return td.tracks
+}
+
+// Palette returns the palette the levels of the decoded tracks refer to
+func (td *TrackDecoder) Palette() *Palette {
+	return td.conv.palette
+}
+
+// Decode de-interleaves the raw track read from r and calls fn for every
+// spiral track in burn order. The fill samples between tracks are dropped.
+// A short input ends the decoding early; the last track is then padded
+// with LevelUnknown.
+func (td *TrackDecoder) Decode(r io.Reader, fn func(DecodedTrack) error) error {
+	return td.decode(NewDeinterleaver(r), 0, len(td.steps), fn)
+}
+
+// DecodeTracks decodes only the tracks from..to-1 of a raw track of size
+// bytes, reading just the part of r they were interleaved into. Several
+// ranges can be decoded concurrently.
+func (td *TrackDecoder) DecodeTracks(r io.ReaderAt, size int64, from, to int, fn func(DecodedTrack) error) error {
+	from = max(from, 0)
+	to = min(to, len(td.steps))
+	if from >= to {
+		return nil
+	}
+
+	// The delay sequence only mixes whole frames, so de-interleaving can
+	// start at the frame holding the first sample
+	start := td.tracks[from].Offset
+	frame := start / 24 * 24
+	if frame >= size {
+		return nil
+	}
+	d := NewDeinterleaver(io.NewSectionReader(r, frame, size-frame))
+	if _, err := io.CopyN(io.Discard, d, start-frame); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+
+	return td.decode(d, from, to, fn)
+}
+
+// decode reads the tracks from..to-1 from d, which is positioned at the
+// first sample of track from
+func (td *TrackDecoder) decode(d *Deinterleaver, from, to int, fn func(DecodedTrack) error) error {
+	var buf []byte
+	for _, step := range td.steps[from:to] {
+		n := step.itr + step.fill
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+
+		got, err := io.ReadFull(d, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		levels := make([]uint8, step.itr)
+		for i := range levels {
+			levels[i] = LevelUnknown
+			if i < got {
+				levels[i] = td.conv.palette.Level(buf[i])
+			}
+		}
+
+		track := DecodedTrack{
+			Index:  step.index,
+			Radius: step.r,
+			Levels: levels,
+		}
+		if err := fn(track); err != nil {
+			return err
+		}
+		if got < n {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Reconstruct decodes the raw track read from r and rebuilds the disc
+// raster it was sampled from, width x height pixels as passed to
+// Converter.Convert. Each pixel is the average gray value of the palette
+// levels sampled from it, which undoes the dithering; pixels no sample was
+// taken from stay black.
+//
+// A sample goes to the pixel its point on the spiral lies in, the pixel
+// nearest sampling reads and the one the interpolating filters and the area
+// filter weigh most. A track sampled directly through a Placement has no
+// disc raster and is rejected.
+func (td *TrackDecoder) Reconstruct(r io.Reader, width, height int) (*image.Gray, error) {
+	if td.conv.optionsErr != nil {
+		return nil, td.conv.optionsErr
+	}
+	if td.conv.placement != nil {
+		return nil, fmt.Errorf("cannot reconstruct a track sampled through a placement, it has no disc raster")
+	}
+
+	sum := make([]uint32, width*height)
+	count := make([]uint32, width*height)
+
+	cx := float64(width) / 2
+	cy := float64(height) / 2
+	err := td.Decode(r, func(t DecodedTrack) error {
+		ri := imageRadius * t.Radius / discRadius
+		for i, level := range t.Levels {
+			if level == LevelUnknown {
+				continue
+			}
+
+			// The pixel of exactPixel, including its clamping
+			alpha := 2 * math.Pi * float64(i) / float64(len(t.Levels))
+			x := min(max(int(cx+ri*math.Cos(alpha)), 0), width-1)
+			y := min(max(int(cy+ri*math.Sin(alpha)), 0), height-1)
+
+			sum[y*width+x] += uint32(td.conv.palette.Gray(level))
+			count[y*width+x]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i, c := range count {
+		if c > 0 {
+			img.Pix[i] = uint8((sum[i] + c/2) / c)
+		}
+	}
+	return img, nil
+}
+
+// Verify decodes the raw track read from r and checks that every sample
+// holds the palette level the Converter renders for img, the image the
+// track was converted from. Only the bytes lost at the end of the track may
+// be missing. The decoder must use the dithering engine and seed of the
+// conversion.
+func (td *TrackDecoder) Verify(r io.Reader, img image.Image) error {
+	if td.conv.optionsErr != nil {
+		return td.conv.optionsErr
+	}
+
+	steps := td.steps
+	ditherer := td.conv.passDitherer()
+
+	src := td.conv.source(img)
+
+	// Unknown bytes are only expected in the palette frames delayed past the
+	// end of the track
+	type position struct{ track, sample, offset int }
+	var firstUnknown *position
+
+	var expected []byte
+	tracks, offset := 0, 0
+	err := td.Decode(r, func(t DecodedTrack) error {
+		expected = td.conv.renderTrack(src, ditherer, steps[t.Index], expected[:0])
+		for i, level := range t.Levels {
+			if level == LevelUnknown {
+				if firstUnknown == nil {
+					firstUnknown = &position{t.Index, i, offset + i}
+				}
+				continue
+			}
+			if want := td.conv.palette.Level(expected[i]); level != want {
+				return fmt.Errorf("track %d, sample %d: level %d, expected %d", t.Index, i, level, want)
+			}
+		}
+		tracks++
+		offset += len(t.Levels) + steps[t.Index].fill
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if tracks < len(steps) {
+		return fmt.Errorf("track data ends after %d of %d spiral tracks", tracks, len(steps))
+	}
+	if firstUnknown != nil && offset-firstUnknown.offset > (maxLag+2)*24 {
+		return fmt.Errorf("track %d, sample %d: not a palette byte", firstUnknown.track, firstUnknown.sample)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// TestVerifyOptions checks that Verify follows the dithering engine,
+// sampling filter and placement of the conversion, and that it notices a
+// track converted with other options
+func TestVerifyOptions(t *testing.T) {
+	img := testImage()
+	tests := []Options{
+		{Dither: DitherRandom, Seed: 3},
+		{Dither: DitherBlueNoise, Seed: 2},
+		{Dither: DitherAM, Seed: 1, Screen: 150},
+		{Dither: DitherDiffusion},
+		{Sampling: SamplingBilinear, AreaFilter: true},
+		{Sampling: SamplingBicubic},
+		{Placement: &Placement{Scale: 1.5, OffsetX: 4, Rotation: 30}},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.totalSize = 1 << 18
+		opts.Dither, opts.Seed, opts.Screen = tt.Dither, tt.Seed, tt.Screen
+		opts.Sampling, opts.AreaFilter = tt.Sampling, tt.AreaFilter
+		opts.Placement = tt.Placement
+		name := fmt.Sprintf("%s/%s/%t/%v", tt.Dither, tt.Sampling, tt.AreaFilter, tt.Placement)
+
+		t.Run(name, func(t *testing.T) {
+			raw := convert(t, NewConverter(opts), img)
+			if err := NewTrackDecoder(opts).Verify(bytes.NewReader(raw), img); err != nil {
+				t.Fatal(err)
+			}
+
+			other := opts
+			switch {
+			case opts.Seed != 0:
+				other.Seed++
+			case opts.Placement != nil:
+				other.Placement = &Placement{Scale: 1.5, OffsetX: 4, Rotation: 31}
+			case opts.Sampling != "" && opts.AreaFilter:
+				other.AreaFilter = false
+			default:
+				other.Dither = DitherOrdered
+				other.Sampling = SamplingNearest
+			}
+			if err := NewTrackDecoder(other).Verify(bytes.NewReader(raw), img); err == nil {
+				t.Errorf("track verified with %+v", other)
+			}
+		})
+	}
+}
+
+// TestReconstruct checks that every pixel the spiral sampled is rebuilt
+// from its own samples. Without dithering they all hold the level of the
+// pixel's gray value.
+func TestReconstruct(t *testing.T) {
+	opts := testOptions()
+	opts.Dither = DitherThreshold
+	img := testImage()
+	raw := convert(t, NewConverter(opts), img)
+
+	rec, err := NewTrackDecoder(opts).Reconstruct(bytes.NewReader(raw), img.Rect.Dx(), img.Rect.Dy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want [256]uint8
+	d, _ := NewDitherer(DitherThreshold, 0, 0, nil)
+	for v := range want {
+		var level [1]uint8
+		d.Dither(DitherTrack{Samples: 1}, 0, []uint8{uint8(v)}, level[:])
+		want[v] = DefaultPalette.Gray(level[0])
+	}
+
+	src := grayRaster(img)
+	sampled := 0
+	for i, got := range rec.Pix {
+		if got == 0 {
+			continue
+		}
+		sampled++
+		if w := want[src.Pix[i]]; got != w {
+			t.Fatalf("pixel %d,%d of gray %d rebuilt as %d, expected %d", i%rec.Stride, i/rec.Stride, src.Pix[i], got, w)
+		}
+	}
+	if sampled < 100000 {
+		t.Errorf("only %d pixels rebuilt", sampled)
+	}
+
+	placed := opts
+	placed.Placement = &Placement{}
+	if _, err := NewTrackDecoder(placed).Reconstruct(bytes.NewReader(raw), img.Rect.Dx(), img.Rect.Dy()); err == nil {
+		t.Error("track of a placed image reconstructed")
+	}
+}
@@ -0,0 +1,415 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Dithering engines selectable by name in Options.Dither
+const (
+	DitherOrdered   = "ordered"   // The 17x5 pattern of the original converter
+	DitherRandom    = "random"    // Seeded white noise, the former --mix-colors
+	DitherThreshold = "threshold" // No dithering, the nearest level below or above
+	DitherBayer     = "bayer"     // 8x8 Bayer matrix over tracks and samples
+	DitherBlueNoise = "bluenoise" // 64x64 blue noise threshold map over tracks and samples
+	DitherAM        = "am"        // Clustered dot halftone screen on the disc surface
+	DitherDiffusion = "diffusion" // Error diffusion along the spiral and into the next track
+)
+
+// DefaultScreenFrequency is the screen ruling of the AM halftone in lines
+// per inch, giving dots of 1.27 mm
+const DefaultScreenFrequency = 20.0
+
+// Layout of the threshold maps
+const (
+	bayerSize     = 8
+	blueNoiseSize = 64
+	amScreenAngle = 45.0 // degrees
+)
+
+// DitherTrack tells a Ditherer where the samples of a track lie. Sample i is
+// at angle 2*pi*i/Samples, like in DecodedTrack.
+type DitherTrack struct {
+	Index   int     // Track number from the start of the spiral
+	Samples int     // Image samples in the track
+	Radius  float64 // mm
+	Phase   int     // Column of the first sample in the ordered pattern, carried along the spiral
+}
+
+// Ditherer chooses the palette level of every image sample from its gray
+// value. Ditherers are safe for concurrent use, except SequentialDitherer.
+type Ditherer interface {
+	// Dither sets levels[k] to the palette level, 0 being the darkest, of
+	// gray[k], the gray value of sample from+k of track t. levels may be
+	// gray.
+	Dither(t DitherTrack, from int, gray, levels []uint8)
+}
+
+// SequentialDitherer is a Ditherer whose levels depend on the samples
+// dithered before them. Dither must be called for every track of the spiral
+// in order, each with all its samples. A pass along the spiral starts from a
+// Clone of the Ditherer returned by NewDitherer.
+type SequentialDitherer interface {
+	Ditherer
+	// Clone returns a copy that continues from the same state
+	Clone() SequentialDitherer
+}
+
+// DitherEngines returns the names of the dithering engines
+func DitherEngines() []string {
+	names := make([]string, 0, len(ditherEngines))
+	for name := range ditherEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ditherEngines creates the engines by name from a seed, the screen
+// frequency in lines per inch and the palette they quantise to
+var ditherEngines = map[string]func(seed int64, lpi float64, p *Palette) Ditherer{
+	DitherOrdered: func(_ int64, _ float64, p *Palette) Ditherer { return orderedDitherer{palette: p} },
+	DitherRandom: func(seed int64, _ float64, p *Palette) Ditherer {
+		return randomDitherer{seed: uint64(seed), palette: p}
+	},
+	DitherThreshold: func(_ int64, _ float64, p *Palette) Ditherer { return thresholdDitherer{palette: p} },
+	DitherBayer:     newBayerDitherer,
+	DitherBlueNoise: newBlueNoiseDitherer,
+	DitherAM:        newAMDitherer,
+	DitherDiffusion: newDiffusionDitherer,
+}
+
+// NewDitherer returns the dithering engine called name, or the ordered
+// pattern for an empty name. seed makes the randomised engines reproducible;
+// lpi is the screen frequency of the AM halftone, 0 for the default. The
+// levels are those of palette, nil for DefaultPalette.
+func NewDitherer(name string, seed int64, lpi float64, palette *Palette) (Ditherer, error) {
+	if name == "" {
+		name = DitherOrdered
+	}
+	engine, ok := ditherEngines[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown dithering engine '%s' (must be one of: %s)", name, strings.Join(DitherEngines(), ", "))
+	}
+	if lpi == 0 {
+		lpi = DefaultScreenFrequency
+	}
+	if lpi < 0 {
+		return nil, fmt.Errorf("screen frequency must be > 0")
+	}
+	if palette == nil {
+		palette = DefaultPalette
+	}
+	return engine(seed, lpi, palette), nil
+}
+
+// orderedDitherer cycles through 17 thresholds per track and 5 along it,
+// the rows advancing with every track and the columns along the spiral
+type orderedDitherer struct {
+	palette *Palette
+}
+
+func (d orderedDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	// The thresholds repeat every 5 samples
+	row := t.Index % 17 * 5
+	var q [5]*[256]uint8
+	for m := range q {
+		q[m] = &d.palette.quantised[row+(t.Phase+from+m)%5]
+	}
+
+	levels = levels[:len(gray)]
+	k := 0
+	for ; k+5 <= len(gray); k += 5 {
+		g, l := (*[5]uint8)(gray[k:]), (*[5]uint8)(levels[k:])
+		l[0] = q[0][g[0]]
+		l[1] = q[1][g[1]]
+		l[2] = q[2][g[2]]
+		l[3] = q[3][g[3]]
+		l[4] = q[4][g[4]]
+	}
+	for ; k < len(gray); k++ {
+		levels[k] = q[k%5][gray[k]]
+	}
+}
+
+// randomDitherer draws a threshold for every sample from a hash of its
+// position, so any sample can be rendered on its own
+type randomDitherer struct {
+	seed    uint64
+	palette *Palette
+}
+
+func (d randomDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	for k, g := range gray {
+		h := mix64(d.seed, uint64(t.Index), uint64(from+k))
+		levels[k] = d.palette.quantiseAt(g, int(h%85))
+	}
+}
+
+// thresholdDitherer rounds every sample to the nearest level
+type thresholdDitherer struct {
+	palette *Palette
+}
+
+func (d thresholdDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	for k, g := range gray {
+		levels[k] = d.palette.quantiseAt(g, 42)
+	}
+}
+
+// mapDitherer tiles a threshold map over the track and sample indexes,
+// starting at a seeded offset
+type mapDitherer struct {
+	size     int
+	tmap     []float64 // Thresholds from 0 to 85, row by row
+	row, col int
+	palette  *Palette
+}
+
+func (d *mapDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	row := d.tmap[(t.Index+d.row)%d.size*d.size:][:d.size]
+	col := (from + d.col) % d.size
+	for k, g := range gray {
+		levels[k] = d.palette.quantise(g, row[col])
+		col++
+		if col == d.size {
+			col = 0
+		}
+	}
+}
+
+// newMapDitherer returns a mapDitherer for ranks, a permutation of 0 to
+// size*size-1
+func newMapDitherer(ranks []int, size int, seed int64, p *Palette) *mapDitherer {
+	d := &mapDitherer{size: size, tmap: make([]float64, len(ranks)), palette: p}
+	for i, rank := range ranks {
+		d.tmap[i] = float64(rank) * 85 / float64(len(ranks))
+	}
+	h := mix64(uint64(seed), 0, 0)
+	d.row = int(h % uint64(size))
+	d.col = int(h / uint64(size) % uint64(size))
+	return d
+}
+
+func newBayerDitherer(seed int64, _ float64, p *Palette) Ditherer {
+	// Each doubling repeats the matrix times 4 in the quadrants, plus 0 at
+	// the top left, 2 at the top right, 3 at the bottom left and 1 at the
+	// bottom right
+	ranks := []int{0}
+	for n := 1; n < bayerSize; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := 0; y < 2*n; y++ {
+			for x := 0; x < 2*n; x++ {
+				quadrant := [4]int{0, 2, 3, 1}[(y/n)*2+x/n]
+				next[y*2*n+x] = 4*ranks[(y%n)*n+x%n] + quadrant
+			}
+		}
+		ranks = next
+	}
+	return newMapDitherer(ranks, bayerSize, seed, p)
+}
+
+func newBlueNoiseDitherer(seed int64, _ float64, p *Palette) Ditherer {
+	return newMapDitherer(voidAndCluster(blueNoiseSize, seed), blueNoiseSize, seed, p)
+}
+
+// voidAndCluster ranks the cells of a size x size torus by Ulichney's
+// void-and-cluster method, so the cells of every rank below a threshold
+// spread out as evenly as blue noise
+func voidAndCluster(size int, seed int64) []int {
+	const sigma = 1.5
+	n := size * size
+
+	// Gaussian energy a cell adds to the others, wrapping around
+	kernel := make([]float64, n)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx := float64(min(x, size-x))
+			dy := float64(min(y, size-y))
+			kernel[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+		}
+	}
+
+	set := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(i int, on bool) {
+		set[i] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		ix, iy := i%size, i/size
+		for y := 0; y < size; y++ {
+			ky := (y - iy + size) % size * size
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * kernel[ky+(x-ix+size)%size]
+			}
+		}
+	}
+
+	// The tightest cluster is the set cell of the highest energy, the
+	// largest void the empty cell of the lowest
+	extreme := func(on bool) int {
+		best := -1
+		for i := range set {
+			if set[i] != on {
+				continue
+			}
+			if best < 0 || (on && energy[i] > energy[best]) || (!on && energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Initial pattern of a tenth of the cells, relaxed until moving the
+	// tightest cluster into the largest void gains nothing
+	rng := rand.New(rand.NewSource(seed))
+	ones := n / 10
+	for _, i := range rng.Perm(n)[:ones] {
+		toggle(i, true)
+	}
+	for iter := 0; iter < 4*n; iter++ {
+		cluster := extreme(true)
+		toggle(cluster, false)
+		void := extreme(false)
+		if void == cluster {
+			toggle(cluster, true)
+			break
+		}
+		toggle(void, true)
+	}
+
+	ranks := make([]int, n)
+	prototype := append([]bool(nil), set...)
+	saved := append([]float64(nil), energy...)
+
+	// Ranks below the initial pattern remove its tightest clusters
+	for rank := ones - 1; rank >= 0; rank-- {
+		i := extreme(true)
+		toggle(i, false)
+		ranks[i] = rank
+	}
+
+	// Ranks above it fill the largest voids
+	copy(set, prototype)
+	copy(energy, saved)
+	for rank := ones; rank < n; rank++ {
+		i := extreme(false)
+		toggle(i, true)
+		ranks[i] = rank
+	}
+	return ranks
+}
+
+// amDitherer is a clustered dot screen laid over the disc, rotated by
+// amScreenAngle. The samples of a cell brighten from its center outward,
+// so the dots are round at low levels and merge into a checkerboard at
+// half of a level step.
+type amDitherer struct {
+	frequency float64 // Cells per mm
+	u0, v0    float64 // Seeded origin of the screen in cells
+	palette   *Palette
+}
+
+func newAMDitherer(seed int64, lpi float64, p *Palette) Ditherer {
+	h := mix64(uint64(seed), 1, 0)
+	return amDitherer{
+		frequency: lpi / 25.4,
+		u0:        float64(h&0xffff) / 0x10000,
+		v0:        float64(h>>16&0xffff) / 0x10000,
+		palette:   p,
+	}
+}
+
+func (d amDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	angle := amScreenAngle * math.Pi / 180
+	sin, cos := math.Sincos(angle)
+	for k, g := range gray {
+		alpha := 2 * math.Pi * float64(from+k) / float64(t.Samples)
+		x := t.Radius * math.Cos(alpha)
+		y := t.Radius * math.Sin(alpha)
+		u := (x*cos+y*sin)*d.frequency + d.u0
+		v := (y*cos-x*sin)*d.frequency + d.v0
+
+		// Spot function, 1 at the center of a cell and 0 at its corners
+		spot := (math.Cos(2*math.Pi*u) + math.Cos(2*math.Pi*v) + 2) / 4
+		levels[k] = d.palette.quantise(g, (1-spot)*84)
+	}
+}
+
+// Error diffusion weights: along the spiral to the next sample and into
+// the next track before, at and after the same angle
+const (
+	diffuseNext   = 7.0 / 16
+	diffuseBefore = 3.0 / 16
+	diffuseBelow  = 5.0 / 16
+	diffuseAfter  = 1.0 / 16
+	diffuseJitter = 0.15 // Seeded threshold noise that breaks up worms
+)
+
+// diffusionDitherer spreads the quantisation error of every sample over
+// the samples after it along the spiral and the samples of the next track
+// around the same angle, like Floyd-Steinberg does over rows of pixels
+type diffusionDitherer struct {
+	seed    uint64
+	palette *Palette
+	carry   []float32 // Error passed to the next track, by sample of the last one
+	next    float64   // Error passed to the next sample
+	below   []float32 // Error collected for the next track
+}
+
+func newDiffusionDitherer(seed int64, _ float64, p *Palette) Ditherer {
+	return &diffusionDitherer{seed: uint64(seed), palette: p}
+}
+
+func (d *diffusionDitherer) Clone() SequentialDitherer {
+	c := *d
+	c.carry = append([]float32(nil), d.carry...)
+	c.below = nil
+	return &c
+}
+
+func (d *diffusionDitherer) Dither(t DitherTrack, from int, gray, levels []uint8) {
+	n := len(gray)
+	if cap(d.below) < n {
+		d.below = make([]float32, n)
+	}
+	below := d.below[:n]
+	clear(below)
+
+	for k, g := range gray {
+		value := float64(g)/85 + d.next
+		if len(d.carry) > 0 {
+			value += float64(d.carry[k*len(d.carry)/n])
+		}
+
+		jitter := float64(mix64(d.seed, uint64(t.Index), uint64(k))%1024)/1024 - 0.5
+		level := d.palette.round(value, 0.5+jitter*2*diffuseJitter)
+		levels[k] = level
+
+		e := value - d.palette.position(level)
+		d.next = e * diffuseNext
+		below[(k+n-1)%n] += float32(e * diffuseBefore)
+		below[k] += float32(e * diffuseBelow)
+		below[(k+1)%n] += float32(e * diffuseAfter)
+	}
+
+	// The buffers swap, so the next track collects into the old carry
+	d.carry, d.below = below, d.carry
+}
+
+// mix64 hashes a seed and two coordinates into 64 well mixed bits
+// (SplitMix64 finalizer)
+func mix64(seed, a, b uint64) uint64 {
+	x := seed ^ a*0x9e3779b97f4a7c15 ^ b*0xc2b2ae3d27d4eb4f
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,110 @@
+package encoder
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// ditherTracks dithers tracks tracks of samples samples of gray values
+// from gray(track, i) in spiral order, as the converter does, and returns
+// their levels
+func ditherTracks(d Ditherer, tracks, samples int, gray func(track, i int) uint8) [][]uint8 {
+	if s, ok := d.(SequentialDitherer); ok {
+		d = s.Clone()
+	}
+	out := make([][]uint8, tracks)
+	phase := 0
+	for track := range out {
+		g := make([]uint8, samples)
+		for i := range g {
+			g[i] = gray(track, i)
+		}
+		out[track] = make([]uint8, samples)
+		t := DitherTrack{Index: track, Samples: samples, Radius: 25 + float64(track)*0.01, Phase: phase}
+		d.Dither(t, 0, g, out[track])
+		phase = (phase + samples) % 5
+	}
+	return out
+}
+
+// meanGray returns the mean gray value the levels stand for
+func meanGray(levels [][]uint8) float64 {
+	sum, n := 0.0, 0
+	for _, track := range levels {
+		for _, l := range track {
+			sum += float64(DefaultPalette.Gray(l))
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func newTestDitherer(t *testing.T, engine string, seed int64) Ditherer {
+	t.Helper()
+	d, err := NewDitherer(engine, seed, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestDitherDeterministic checks that the seeded engines give the same
+// levels for the same seed, also from a new engine, and other levels for
+// another seed
+func TestDitherDeterministic(t *testing.T) {
+	gray := func(track, i int) uint8 { return uint8(40 + (track*7+i)%180) }
+	for _, engine := range []string{DitherRandom, DitherBlueNoise, DitherAM} {
+		want := ditherTracks(newTestDitherer(t, engine, 5), 40, 3000, gray)
+		again := ditherTracks(newTestDitherer(t, engine, 5), 40, 3000, gray)
+		other := ditherTracks(newTestDitherer(t, engine, 6), 40, 3000, gray)
+		same, differs := true, false
+		for i := range want {
+			same = same && bytes.Equal(again[i], want[i])
+			differs = differs || !bytes.Equal(other[i], want[i])
+		}
+		if !same {
+			t.Errorf("%s: seed 5 gives other levels the second time", engine)
+		}
+		if !differs {
+			t.Errorf("%s: seeds 5 and 6 give the same levels", engine)
+		}
+
+		// Any part of a track can be dithered on its own
+		d := newTestDitherer(t, engine, 5)
+		g := make([]uint8, 3000)
+		for i := range g {
+			g[i] = gray(7, i)
+		}
+		part := make([]uint8, 1000)
+		d.Dither(DitherTrack{Index: 7, Samples: 3000, Radius: 25.07}, 1234, g[1234:2234], part)
+		if !bytes.Equal(part, want[7][1234:2234]) {
+			t.Errorf("%s: part of a track dithered differently", engine)
+		}
+	}
+}
+
+// TestDitherMeanGray checks that flat inputs keep their mean gray value
+// through the dithering engines. Without dithering threshold rounds to the
+// nearest level, which keeps the gray values of the levels.
+func TestDitherMeanGray(t *testing.T) {
+	for _, engine := range []string{DitherRandom, DitherBayer, DitherBlueNoise, DitherDiffusion} {
+		d := newTestDitherer(t, engine, 1)
+		for _, g := range []uint8{10, 64, 100, 128, 170, 200, 247} {
+			mean := meanGray(ditherTracks(d, 128, 2048, func(int, int) uint8 { return g }))
+			// Bayer has 64 thresholds, 85/64 gray values apart
+			if math.Abs(mean-float64(g)) > 1.5 {
+				t.Errorf("%s: flat gray %d dithered to a mean of %.2f", engine, g, mean)
+			}
+		}
+	}
+
+	d := newTestDitherer(t, DitherThreshold, 1)
+	for g := 0; g < 256; g++ {
+		mean := meanGray(ditherTracks(d, 4, 100, func(int, int) uint8 { return uint8(g) }))
+		want := math.Round(float64(g)/85) * 85
+		if mean != want {
+			t.Errorf("threshold: flat gray %d rendered as %.2f, expected %g", g, mean, want)
+		}
+	}
+}
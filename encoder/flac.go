@@ -0,0 +1,571 @@
+package encoder
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// FLAC encoder settings. Only the fixed predictors are used: the track data
+// is dithered noise as far as any predictor is concerned, and they keep
+// encoding as fast as the conversion itself.
+const (
+	flacBlockSize         = 4096
+	flacMaxFixedOrder     = 4
+	flacMaxPartitionOrder = 8
+	flacMaxRiceParam      = 14 // 4-bit parameters, 15 is the escape code
+	flacStreamInfoSize    = 34
+)
+
+// Channel assignments in the FLAC frame header
+const (
+	flacIndependent = 1
+	flacLeftSide    = 8
+	flacSideRight   = 9
+	flacMidSide     = 10
+)
+
+// Subframe types
+const (
+	flacSubframeConstant = 0
+	flacSubframeVerbatim = 1
+	flacSubframeFixed    = 8
+	flacSubframeLPC      = 32
+)
+
+// ErrNotFLAC is returned by NewFLACReader for data without a FLAC stream marker
+var ErrNotFLAC = errors.New("not a FLAC file")
+
+// FLACWriter encodes CD-DA written to it as a FLAC stream. The data is
+// compressed losslessly, so decoding it with FLACReader gives back the exact
+// bytes written.
+//
+// When the underlying writer can seek, Close fills in the MD5 signature and
+// frame sizes in the stream header; otherwise they are left as unknown.
+type FLACWriter struct {
+	w            io.Writer
+	totalSamples int64
+	headerPos    int64 // offset of the stream header in w, -1 if w cannot seek
+
+	block   [flacBlockSize * BytesPerFrame]byte
+	n       int // bytes in block
+	frame   uint64
+	samples int64
+	md5     hash.Hash
+
+	minFrame, maxFrame int
+
+	// Scratch space reused for every frame: left, right, side and mid
+	// channels, their residuals and the encoded frame
+	chans [4][]int32
+	res   [4][]int32
+	bits  bitWriter
+}
+
+// flacSubframe describes how one channel of a frame is encoded
+type flacSubframe struct {
+	kind    int
+	order   int
+	porder  int
+	params  [1 << flacMaxPartitionOrder]uint8
+	bits    int
+	samples []int32
+	res     []int32
+}
+
+// NewFLACWriter writes a FLAC stream header to w for totalSamples stereo
+// samples (0 if not known) and returns a writer for the CD-DA data
+func NewFLACWriter(w io.Writer, totalSamples int64) (*FLACWriter, error) {
+	if totalSamples < 0 || totalSamples >= 1<<36 {
+		return nil, fmt.Errorf("track of %d samples is too large for FLAC", totalSamples)
+	}
+
+	fw := &FLACWriter{
+		w:            w,
+		totalSamples: totalSamples,
+		headerPos:    -1,
+		md5:          md5.New(),
+	}
+	for i := range fw.chans {
+		fw.chans[i] = make([]int32, flacBlockSize)
+		fw.res[i] = make([]int32, flacBlockSize)
+	}
+
+	if ws, ok := w.(io.WriteSeeker); ok {
+		if pos, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			fw.headerPos = pos
+		}
+	}
+
+	if _, err := w.Write([]byte("fLaC")); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(fw.streamInfo(nil)); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// streamInfo returns the STREAMINFO metadata block, with the MD5 signature
+// if it is known
+func (fw *FLACWriter) streamInfo(sum []byte) []byte {
+	b := make([]byte, 4+flacStreamInfoSize)
+	b[0] = 0x80 // last metadata block, type 0
+	b[3] = flacStreamInfoSize
+
+	binary.BigEndian.PutUint16(b[4:], flacBlockSize)
+	binary.BigEndian.PutUint16(b[6:], flacBlockSize)
+	putUint24(b[8:], fw.minFrame)
+	putUint24(b[11:], fw.maxFrame)
+	binary.BigEndian.PutUint64(b[14:], uint64(SampleRate)<<44|
+		uint64(Channels-1)<<41|uint64(BitsPerSample-1)<<36|uint64(fw.totalSamples))
+	copy(b[22:], sum)
+	return b
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+// Write encodes p, which continues the stream of 16-bit little-endian
+// stereo samples
+func (fw *FLACWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		c := copy(fw.block[fw.n:], p)
+		fw.n += c
+		p = p[c:]
+
+		if fw.n == len(fw.block) {
+			if err := fw.encodeBlock(fw.block[:]); err != nil {
+				return written, err
+			}
+			fw.n = 0
+		}
+		written += c
+	}
+	return written, nil
+}
+
+// Close encodes the last partial block and completes the stream header. It
+// does not close the underlying writer.
+func (fw *FLACWriter) Close() error {
+	if fw.n%BytesPerFrame != 0 {
+		return fmt.Errorf("FLAC data ends with a partial sample")
+	}
+	if fw.n > 0 {
+		if err := fw.encodeBlock(fw.block[:fw.n]); err != nil {
+			return err
+		}
+		fw.n = 0
+	}
+
+	if fw.totalSamples != 0 && fw.samples != fw.totalSamples {
+		return fmt.Errorf("FLAC stream has %d samples, header announced %d", fw.samples, fw.totalSamples)
+	}
+	if fw.headerPos < 0 {
+		return nil
+	}
+
+	// Go back and fill in what is only known at the end
+	ws := fw.w.(io.WriteSeeker)
+	if _, err := ws.Seek(fw.headerPos+4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(fw.streamInfo(fw.md5.Sum(nil))); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// encodeBlock encodes one frame of samples, choosing the stereo decorrelation
+// that gives the smallest frame
+func (fw *FLACWriter) encodeBlock(p []byte) error {
+	n := len(p) / BytesPerFrame
+	fw.md5.Write(p)
+
+	left, right, side, mid := fw.chans[0][:n], fw.chans[1][:n], fw.chans[2][:n], fw.chans[3][:n]
+	for i := 0; i < n; i++ {
+		l := int32(int16(binary.LittleEndian.Uint16(p[4*i:])))
+		r := int32(int16(binary.LittleEndian.Uint16(p[4*i+2:])))
+		left[i] = l
+		right[i] = r
+		side[i] = l - r
+		mid[i] = (l + r) >> 1
+	}
+
+	var sub [4]flacSubframe
+	sub[0].analyze(left, fw.res[0][:n], BitsPerSample)
+	sub[1].analyze(right, fw.res[1][:n], BitsPerSample)
+	sub[2].analyze(side, fw.res[2][:n], BitsPerSample+1)
+	sub[3].analyze(mid, fw.res[3][:n], BitsPerSample)
+
+	assignment, first, second := flacIndependent, &sub[0], &sub[1]
+	best := sub[0].bits + sub[1].bits
+	if bits := sub[0].bits + sub[2].bits; bits < best {
+		assignment, first, second, best = flacLeftSide, &sub[0], &sub[2], bits
+	}
+	if bits := sub[2].bits + sub[1].bits; bits < best {
+		assignment, first, second, best = flacSideRight, &sub[2], &sub[1], bits
+	}
+	if bits := sub[3].bits + sub[2].bits; bits < best {
+		assignment, first, second = flacMidSide, &sub[3], &sub[2]
+	}
+
+	bw := &fw.bits
+	bw.reset()
+	fw.writeFrameHeader(n, assignment)
+
+	firstBps, secondBps := uint(BitsPerSample), uint(BitsPerSample)
+	switch assignment {
+	case flacLeftSide, flacMidSide:
+		secondBps++
+	case flacSideRight:
+		firstBps++
+	}
+	first.write(bw, firstBps)
+	second.write(bw, secondBps)
+
+	bw.align()
+	crc := crc16(bw.buf)
+	bw.buf = append(bw.buf, byte(crc>>8), byte(crc))
+
+	if size := len(bw.buf); fw.frame == 0 {
+		fw.minFrame, fw.maxFrame = size, size
+	} else {
+		fw.minFrame = min(fw.minFrame, size)
+		fw.maxFrame = max(fw.maxFrame, size)
+	}
+	fw.frame++
+	fw.samples += int64(n)
+
+	_, err := fw.w.Write(bw.buf)
+	return err
+}
+
+// writeFrameHeader starts a frame of n samples
+func (fw *FLACWriter) writeFrameHeader(n, assignment int) {
+	bw := &fw.bits
+
+	// Sync code with fixed block size, 44.1 kHz, 16 bits per sample
+	blockCode := 12 // 4096 samples
+	if n != flacBlockSize {
+		blockCode = 7 // 16-bit size at the end of the header
+	}
+	bw.buf = append(bw.buf, 0xff, 0xf8, byte(blockCode<<4|9), byte(assignment<<4|4<<1))
+	bw.buf = appendUTF8(bw.buf, fw.frame)
+	if blockCode == 7 {
+		bw.buf = append(bw.buf, byte((n-1)>>8), byte(n-1))
+	}
+	bw.buf = append(bw.buf, crc8(bw.buf))
+}
+
+// appendUTF8 appends v in the extended UTF-8 coding used for frame numbers
+func appendUTF8(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	b = append(b, byte(0xff<<(8-n))|byte(v>>(6*(n-1))))
+	for i := n - 2; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3f)
+	}
+	return b
+}
+
+// analyze chooses the encoding of a channel and estimates its size in bits
+func (sf *flacSubframe) analyze(x, res []int32, bps uint) {
+	n := len(x)
+	sf.samples = x
+	sf.res = res
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		sf.kind = flacSubframeConstant
+		sf.bits = 8 + int(bps)
+		return
+	}
+
+	sf.kind = flacSubframeVerbatim
+	sf.bits = 8 + n*int(bps)
+	if n <= flacMaxFixedOrder {
+		return
+	}
+
+	// Pick the fixed predictor with the smallest total error
+	var errs [flacMaxFixedOrder + 1]uint64
+	for i := flacMaxFixedOrder; i < n; i++ {
+		e0 := int64(x[i])
+		e1 := e0 - int64(x[i-1])
+		e2 := e1 - (int64(x[i-1]) - int64(x[i-2]))
+		e3 := e2 - (int64(x[i-1]) - 2*int64(x[i-2]) + int64(x[i-3]))
+		e4 := e3 - (int64(x[i-1]) - 3*int64(x[i-2]) + 3*int64(x[i-3]) - int64(x[i-4]))
+		errs[0] += abs64(e0)
+		errs[1] += abs64(e1)
+		errs[2] += abs64(e2)
+		errs[3] += abs64(e3)
+		errs[4] += abs64(e4)
+	}
+	order := 0
+	for o := 1; o <= flacMaxFixedOrder; o++ {
+		if errs[o] < errs[order] {
+			order = o
+		}
+	}
+	fixedResidual(x, res, order)
+
+	bits, porder := riceEstimate(res, order, &sf.params)
+	if bits += 8 + order*int(bps); bits < sf.bits {
+		sf.kind = flacSubframeFixed
+		sf.order = order
+		sf.porder = porder
+		sf.bits = bits
+	}
+}
+
+// fixedResidual computes the residual of the fixed predictor of the given order
+func fixedResidual(x, res []int32, order int) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 0:
+			res[i] = x[i]
+		case 1:
+			res[i] = x[i] - x[i-1]
+		case 2:
+			res[i] = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			res[i] = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			res[i] = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+	}
+}
+
+// riceEstimate chooses the partition order and Rice parameters for a
+// residual, storing the parameters in params. It returns the estimated size
+// of the residual section in bits and the partition order.
+func riceEstimate(res []int32, order int, params *[1 << flacMaxPartitionOrder]uint8) (int, int) {
+	n := len(res)
+
+	// The partitions must divide the block and the first one must hold at
+	// least the warm-up samples
+	maxOrder := 0
+	for p := 1; p <= flacMaxPartitionOrder; p++ {
+		if n%(1<<p) != 0 || n>>p < order {
+			break
+		}
+		maxOrder = p
+	}
+
+	// Sum the zigzag-coded residuals of the finest partitions, then merge
+	// them pairwise for the coarser orders
+	var sums [1 << flacMaxPartitionOrder]uint64
+	size := n >> maxOrder
+	for p := 0; p < 1<<maxOrder; p++ {
+		from := p * size
+		if p == 0 {
+			from = order
+		}
+		var sum uint64
+		for _, e := range res[from : (p+1)*size] {
+			sum += uint64(zigzag(e))
+		}
+		sums[p] = sum
+	}
+
+	bestBits := -1
+	bestOrder := 0
+	var cur [1 << flacMaxPartitionOrder]uint8
+	for p := maxOrder; p >= 0; p-- {
+		count := n >> p
+		bits := 6 // coding method and partition order
+		for i := 0; i < 1<<p; i++ {
+			c := count
+			if i == 0 {
+				c -= order
+			}
+			k, b := riceParam(c, sums[i])
+			cur[i] = uint8(k)
+			bits += 4 + b
+		}
+		if bestBits < 0 || bits < bestBits {
+			bestBits = bits
+			bestOrder = p
+			copy(params[:1<<p], cur[:1<<p])
+		}
+
+		if p > 0 {
+			for i := 0; i < 1<<(p-1); i++ {
+				sums[i] = sums[2*i] + sums[2*i+1]
+			}
+		}
+	}
+
+	return bestBits, bestOrder
+}
+
+// riceParam returns the Rice parameter for count values summing to sum and
+// the approximate number of bits they take
+func riceParam(count int, sum uint64) (int, int) {
+	bestK := 0
+	bestBits := uint64(count) + sum
+	for k := 1; k <= flacMaxRiceParam; k++ {
+		bits := uint64(count)*uint64(k+1) + sum>>k
+		if bits < bestBits {
+			bestK, bestBits = k, bits
+		}
+	}
+	return bestK, int(min(bestBits, 1<<40))
+}
+
+// write encodes the subframe with samples of bps bits
+func (sf *flacSubframe) write(bw *bitWriter, bps uint) {
+	switch sf.kind {
+	case flacSubframeConstant:
+		bw.writeBits(flacSubframeConstant<<1, 8)
+		bw.writeBits(uint64(sf.samples[0]), bps)
+
+	case flacSubframeVerbatim:
+		bw.writeBits(flacSubframeVerbatim<<1, 8)
+		for _, v := range sf.samples {
+			bw.writeBits(uint64(v), bps)
+		}
+
+	case flacSubframeFixed:
+		bw.writeBits(uint64(flacSubframeFixed+sf.order)<<1, 8)
+		for _, v := range sf.samples[:sf.order] {
+			bw.writeBits(uint64(v), bps)
+		}
+
+		// Rice coding with 4-bit parameters
+		bw.writeBits(0, 2)
+		bw.writeBits(uint64(sf.porder), 4)
+		size := len(sf.samples) >> sf.porder
+		for p := 0; p < 1<<sf.porder; p++ {
+			from := p * size
+			if p == 0 {
+				from = sf.order
+			}
+			k := uint(sf.params[p])
+			bw.writeBits(uint64(k), 4)
+			for _, e := range sf.res[from : (p+1)*size] {
+				u := zigzag(e)
+				bw.writeUnary(u >> k)
+				bw.writeBits(uint64(u), k)
+			}
+		}
+	}
+}
+
+func zigzag(e int32) uint32 {
+	return uint32(e<<1) ^ uint32(e>>31)
+}
+
+func abs64(v int64) uint64 {
+	if v < 0 {
+		return uint64(-v)
+	}
+	return uint64(v)
+}
+
+// bitWriter packs values MSB first into a byte slice
+type bitWriter struct {
+	buf   []byte
+	cache uint64
+	n     uint // bits in cache
+}
+
+func (bw *bitWriter) reset() {
+	bw.buf = bw.buf[:0]
+	bw.cache = 0
+	bw.n = 0
+}
+
+// writeBits writes the low n bits of v, n <= 32
+func (bw *bitWriter) writeBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	bw.cache = bw.cache<<n | v&(1<<n-1)
+	bw.n += n
+	for bw.n >= 8 {
+		bw.n -= 8
+		bw.buf = append(bw.buf, byte(bw.cache>>bw.n))
+	}
+}
+
+// writeUnary writes q zero bits followed by a one bit
+func (bw *bitWriter) writeUnary(q uint32) {
+	for q >= 32 {
+		bw.writeBits(0, 32)
+		q -= 32
+	}
+	bw.writeBits(1, uint(q)+1)
+}
+
+// align pads the last byte with zero bits
+func (bw *bitWriter) align() {
+	if bw.n > 0 {
+		bw.writeBits(0, 8-bw.n)
+	}
+}
+
+// CRC tables for frame headers (CRC-8, polynomial 0x07) and whole frames
+// (CRC-16, polynomial 0x8005)
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8Table[i] = c8
+		crc16Table[i] = c16
+	}
+}
+
+func crc8(b []byte) uint8 {
+	var crc uint8
+	for _, v := range b {
+		crc = crc8Table[crc^v]
+	}
+	return crc
+}
+
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^v]
+	}
+	return crc
+}
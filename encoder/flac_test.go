@@ -0,0 +1,199 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// flacTestData returns CD-DA of the given number of stereo samples
+// following pattern
+func flacTestData(pattern string, samples int) []byte {
+	rng := rand.New(rand.NewSource(int64(samples)))
+	b := make([]byte, samples*BytesPerFrame)
+	for i := 0; i < samples; i++ {
+		var l, r int16
+		switch pattern {
+		case "silence":
+		case "noise":
+			l, r = int16(rng.Uint32()), int16(rng.Uint32())
+		case "extremes":
+			// Full scale steps, the largest residuals a predictor can see
+			l, r = -32768, 32767
+			if i%2 == 1 || rng.Intn(3) == 0 {
+				l, r = r, l
+			}
+		case "mono":
+			l = int16(3000 * (i%200 - 100))
+			r = l
+		case "track":
+			// Palette bytes as the converter writes them
+			for k := 0; k < BytesPerFrame; k++ {
+				b[i*BytesPerFrame+k] = DefaultPalette.Byte(uint8(rng.Intn(DefaultPalette.Len())))
+			}
+			continue
+		}
+		binary.LittleEndian.PutUint16(b[i*BytesPerFrame:], uint16(l))
+		binary.LittleEndian.PutUint16(b[i*BytesPerFrame+2:], uint16(r))
+	}
+	return b
+}
+
+// encodeFLAC encodes data to a FLAC stream in memory, the stream header
+// left without MD5 signature
+func encodeFLAC(t testing.TB, data []byte, chunk int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	fw, err := NewFLACWriter(&buf, int64(len(data)/BytesPerFrame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := data; len(p) > 0; {
+		n := min(chunk, len(p))
+		if _, err := fw.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeFLAC decodes a whole FLAC stream
+func decodeFLAC(t *testing.T, stream []byte) []byte {
+	t.Helper()
+	fr, err := NewFLACReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(fr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	// Odd lengths, exact blocks and a partial last block
+	lengths := []int{1, 7, flacBlockSize - 1, flacBlockSize, flacBlockSize + 1, 3*flacBlockSize + 1235}
+	for _, pattern := range []string{"silence", "noise", "extremes", "mono", "track"} {
+		for _, samples := range lengths {
+			data := flacTestData(pattern, samples)
+			stream := encodeFLAC(t, data, 1000*BytesPerFrame+3)
+			if got := decodeFLAC(t, stream); !bytes.Equal(got, data) {
+				t.Errorf("%s, %d samples: decoded data differs", pattern, samples)
+			}
+		}
+	}
+}
+
+func TestFLACCompressesSilence(t *testing.T) {
+	data := flacTestData("silence", 10*flacBlockSize)
+	if stream := encodeFLAC(t, data, len(data)); len(stream) > len(data)/100 {
+		t.Errorf("%d bytes of silence encoded to %d bytes", len(data), len(stream))
+	}
+}
+
+// TestFLACSignature checks the MD5 signature a seekable writer gets, and
+// that the reader rejects data that does not match it
+func TestFLACSignature(t *testing.T) {
+	data := flacTestData("noise", 2*flacBlockSize+17)
+	name := filepath.Join(t.TempDir(), "track.flac")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := NewFLACWriter(file, int64(len(data)/BytesPerFrame))
+	if err == nil {
+		_, err = fw.Write(data)
+	}
+	if err == nil {
+		err = fw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(stream[26:42], make([]byte, 16)) {
+		t.Fatal("stream header has no MD5 signature")
+	}
+	if got := decodeFLAC(t, stream); !bytes.Equal(got, data) {
+		t.Fatal("decoded data differs")
+	}
+
+	stream[26] ^= 1
+	fr, err := NewFLACReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(fr); err == nil {
+		t.Error("MD5 signature mismatch not reported")
+	}
+}
+
+func TestFLACWriterRejectsPartialSample(t *testing.T) {
+	fw, err := NewFLACWriter(io.Discard, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(make([]byte, 6))
+	if err := fw.Close(); err == nil {
+		t.Error("partial sample not reported")
+	}
+}
+
+func TestFLACReaderNotFLAC(t *testing.T) {
+	if _, err := NewFLACReader(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVE"))); err != ErrNotFLAC {
+		t.Errorf("got %v, expected ErrNotFLAC", err)
+	}
+}
+
+func TestFLACReaderTruncated(t *testing.T) {
+	data := flacTestData("noise", flacBlockSize+100)
+	stream := encodeFLAC(t, data, len(data))
+	for _, n := range []int{len(stream) - 1, len(stream) - 500, 100} {
+		fr, err := NewFLACReader(bytes.NewReader(stream[:n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(fr); err == nil {
+			t.Errorf("stream cut to %d of %d bytes decoded without error", n, len(stream))
+		}
+	}
+}
+
+// FuzzFLACReader decodes arbitrary data, which must fail with an error
+// rather than a panic, and checks that anything decoded whole is CD-DA
+func FuzzFLACReader(f *testing.F) {
+	for _, pattern := range []string{"silence", "noise", "extremes", "mono", "track"} {
+		f.Add(encodeFLAC(f, flacTestData(pattern, 300), 4*BytesPerFrame))
+	}
+	f.Add([]byte("fLaC"))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		fr, err := NewFLACReader(bytes.NewReader(stream))
+		if err != nil {
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(fr, 1<<24))
+		if err != nil {
+			return
+		}
+		if len(data)%BytesPerFrame != 0 {
+			t.Errorf("decoded %d bytes, not whole samples", len(data))
+		}
+	})
+}
@@ -0,0 +1,549 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+// FLACReader decodes a FLAC stream of CD-DA back to 16-bit little-endian
+// stereo samples, the same bytes as a raw track. Streams written by other
+// encoders are supported as long as they hold CD-DA.
+//
+// The MD5 signature of the stream, if present, is checked when the end of
+// the stream is reached.
+type FLACReader struct {
+	br           bitReader
+	totalSamples int64
+	sum          [16]byte
+
+	samples int64
+	md5     hash.Hash
+	out     []byte // decoded bytes not yet read
+	pos     int
+	err     error
+
+	chans [2][]int32
+}
+
+// NewFLACReader reads the stream header from r. Data without a FLAC stream
+// marker yields ErrNotFLAC.
+func NewFLACReader(r io.Reader) (*FLACReader, error) {
+	fr := &FLACReader{md5: md5.New()}
+	fr.br.r = bufio.NewReaderSize(r, 64*1024)
+
+	var marker [4]byte
+	if _, err := io.ReadFull(fr.br.r, marker[:]); err != nil || string(marker[:]) != "fLaC" {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, ErrNotFLAC
+	}
+
+	haveInfo := false
+	for last := false; !last; {
+		var header [4]byte
+		if _, err := io.ReadFull(fr.br.r, header[:]); err != nil {
+			return nil, fmt.Errorf("failed to read FLAC metadata: %w", err)
+		}
+		last = header[0]&0x80 != 0
+		kind := header[0] & 0x7f
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if kind != 0 {
+			if _, err := io.CopyN(io.Discard, fr.br.r, size); err != nil {
+				return nil, fmt.Errorf("failed to read FLAC metadata: %w", err)
+			}
+			continue
+		}
+
+		if size != flacStreamInfoSize {
+			return nil, errors.New("invalid FLAC stream header")
+		}
+		var info [flacStreamInfoSize]byte
+		if _, err := io.ReadFull(fr.br.r, info[:]); err != nil {
+			return nil, fmt.Errorf("failed to read FLAC stream header: %w", err)
+		}
+		v := binary.BigEndian.Uint64(info[10:])
+		rate := v >> 44
+		channels := v>>41&7 + 1
+		bps := v>>36&31 + 1
+		if rate != SampleRate || channels != Channels || bps != BitsPerSample {
+			return nil, fmt.Errorf("unsupported FLAC format: %d channels, %d Hz, %d bits (need CD-DA: 2 channels, 44100 Hz, 16 bits)",
+				channels, rate, bps)
+		}
+		fr.totalSamples = int64(v & (1<<36 - 1))
+		copy(fr.sum[:], info[18:])
+		haveInfo = true
+	}
+	if !haveInfo {
+		return nil, errors.New("FLAC stream header missing")
+	}
+
+	for i := range fr.chans {
+		fr.chans[i] = make([]int32, 0, flacBlockSize)
+	}
+	return fr, nil
+}
+
+// Samples returns the number of stereo samples in the stream, or 0 if the
+// stream header does not say
+func (fr *FLACReader) Samples() int64 {
+	return fr.totalSamples
+}
+
+// Read reads decoded CD-DA
+func (fr *FLACReader) Read(p []byte) (int, error) {
+	for fr.pos == len(fr.out) {
+		if fr.err != nil {
+			return 0, fr.err
+		}
+		fr.err = fr.decodeFrame()
+	}
+
+	n := copy(p, fr.out[fr.pos:])
+	fr.pos += n
+	return n, nil
+}
+
+// decodeFrame decodes the next frame into fr.out. At the end of the stream
+// it checks the sample count and MD5 signature and returns io.EOF.
+func (fr *FLACReader) decodeFrame() error {
+	br := &fr.br
+	br.crc8, br.crc16 = 0, 0
+
+	sync, err := br.readBits(14)
+	if err == io.EOF {
+		return fr.finish()
+	}
+	if err == nil {
+		err = fr.readFrame(sync)
+	}
+	if err == io.EOF {
+		// The stream ends inside a frame
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readFrame decodes the rest of a frame after its sync code
+func (fr *FLACReader) readFrame(sync uint64) error {
+	br := &fr.br
+	if sync != 0x3ffe {
+		return errors.New("FLAC frame sync lost")
+	}
+
+	h, err := br.readBits(18)
+	if err != nil {
+		return err
+	}
+	blockCode := h >> 12 & 15
+	rateCode := h >> 8 & 15
+	assignment := int(h >> 4 & 15)
+	sizeCode := h >> 1 & 7
+	if sizeCode != 0 && sizeCode != 4 {
+		return errors.New("FLAC frame is not 16 bits per sample")
+	}
+	if err := br.skipUTF8(); err != nil {
+		return err
+	}
+
+	var n int
+	switch {
+	case blockCode == 1:
+		n = 192
+	case blockCode >= 2 && blockCode <= 5:
+		n = 576 << (blockCode - 2)
+	case blockCode == 6:
+		v, err := br.readBits(8)
+		if err != nil {
+			return err
+		}
+		n = int(v) + 1
+	case blockCode == 7:
+		v, err := br.readBits(16)
+		if err != nil {
+			return err
+		}
+		n = int(v) + 1
+	case blockCode >= 8:
+		n = 256 << (blockCode - 8)
+	default:
+		return errors.New("invalid FLAC block size")
+	}
+	switch rateCode {
+	case 12:
+		_, err = br.readBits(8)
+	case 13, 14:
+		_, err = br.readBits(16)
+	}
+	if err != nil {
+		return err
+	}
+
+	crc := br.crc8
+	if v, err := br.readBits(8); err != nil {
+		return err
+	} else if uint8(v) != crc {
+		return errors.New("FLAC frame header checksum mismatch")
+	}
+
+	// Subframes, with the side channel one bit wider
+	var bps [2]uint
+	switch assignment {
+	case flacIndependent:
+		bps = [2]uint{BitsPerSample, BitsPerSample}
+	case flacLeftSide, flacMidSide:
+		bps = [2]uint{BitsPerSample, BitsPerSample + 1}
+	case flacSideRight:
+		bps = [2]uint{BitsPerSample + 1, BitsPerSample}
+	default:
+		return fmt.Errorf("unsupported FLAC channel assignment %d", assignment)
+	}
+	for c := range fr.chans {
+		if cap(fr.chans[c]) < n {
+			fr.chans[c] = make([]int32, 0, n)
+		}
+		fr.chans[c] = fr.chans[c][:n]
+		if err := br.readSubframe(fr.chans[c], bps[c]); err != nil {
+			return err
+		}
+	}
+
+	br.align()
+	crc16 := br.crc16
+	if v, err := br.readBits(16); err != nil {
+		return err
+	} else if uint16(v) != crc16 {
+		return errors.New("FLAC frame checksum mismatch")
+	}
+
+	// Undo the stereo decorrelation and interleave
+	a, b := fr.chans[0], fr.chans[1]
+	if cap(fr.out) < n*BytesPerFrame {
+		fr.out = make([]byte, n*BytesPerFrame)
+	}
+	fr.out = fr.out[:n*BytesPerFrame]
+	for i := 0; i < n; i++ {
+		var l, r int32
+		switch assignment {
+		case flacIndependent:
+			l, r = a[i], b[i]
+		case flacLeftSide:
+			l, r = a[i], a[i]-b[i]
+		case flacSideRight:
+			l, r = a[i]+b[i], b[i]
+		case flacMidSide:
+			m := a[i]<<1 | b[i]&1
+			l, r = (m+b[i])>>1, (m-b[i])>>1
+		}
+		binary.LittleEndian.PutUint16(fr.out[4*i:], uint16(l))
+		binary.LittleEndian.PutUint16(fr.out[4*i+2:], uint16(r))
+	}
+	fr.pos = 0
+	fr.samples += int64(n)
+	fr.md5.Write(fr.out)
+
+	return nil
+}
+
+// finish verifies the decoded stream against its header
+func (fr *FLACReader) finish() error {
+	if fr.totalSamples != 0 && fr.samples != fr.totalSamples {
+		return fmt.Errorf("FLAC stream has %d samples, header announced %d: %w",
+			fr.samples, fr.totalSamples, io.ErrUnexpectedEOF)
+	}
+	if fr.sum != [16]byte{} && !bytes.Equal(fr.md5.Sum(nil), fr.sum[:]) {
+		return errors.New("FLAC MD5 signature mismatch")
+	}
+	return io.EOF
+}
+
+// readSubframe decodes one channel of a frame into x
+func (br *bitReader) readSubframe(x []int32, bps uint) error {
+	h, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if h&0x80 != 0 {
+		return errors.New("invalid FLAC subframe header")
+	}
+	kind := int(h >> 1 & 0x3f)
+
+	// Wasted bits per sample are dropped from every value
+	wasted := uint(0)
+	if h&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return errors.New("invalid FLAC wasted bits")
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == flacSubframeConstant:
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range x {
+			x[i] = v
+		}
+
+	case kind == flacSubframeVerbatim:
+		for i := range x {
+			if x[i], err = br.readSigned(bps); err != nil {
+				return err
+			}
+		}
+
+	case kind >= flacSubframeFixed && kind <= flacSubframeFixed+flacMaxFixedOrder:
+		order := kind - flacSubframeFixed
+		if order > len(x) {
+			return errors.New("invalid FLAC predictor order")
+		}
+		for i := 0; i < order; i++ {
+			if x[i], err = br.readSigned(bps); err != nil {
+				return err
+			}
+		}
+		if err := br.readResidual(x, order); err != nil {
+			return err
+		}
+		for i := order; i < len(x); i++ {
+			switch order {
+			case 1:
+				x[i] += x[i-1]
+			case 2:
+				x[i] += 2*x[i-1] - x[i-2]
+			case 3:
+				x[i] += 3*x[i-1] - 3*x[i-2] + x[i-3]
+			case 4:
+				x[i] += 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+			}
+		}
+
+	case kind >= flacSubframeLPC:
+		order := kind - flacSubframeLPC + 1
+		if order > len(x) {
+			return errors.New("invalid FLAC predictor order")
+		}
+		for i := 0; i < order; i++ {
+			if x[i], err = br.readSigned(bps); err != nil {
+				return err
+			}
+		}
+		p, err := br.readBits(4)
+		if err != nil {
+			return err
+		}
+		if p == 15 {
+			return errors.New("invalid FLAC coefficient precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("negative FLAC predictor shift")
+		}
+		coefs := make([]int64, order)
+		for j := range coefs {
+			c, err := br.readSigned(uint(p) + 1)
+			if err != nil {
+				return err
+			}
+			coefs[j] = int64(c)
+		}
+		if err := br.readResidual(x, order); err != nil {
+			return err
+		}
+		for i := order; i < len(x); i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += c * int64(x[i-1-j])
+			}
+			x[i] += int32(sum >> shift)
+		}
+
+	default:
+		return fmt.Errorf("reserved FLAC subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range x {
+			x[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// readResidual decodes the Rice-coded residual of a predictor of the given
+// order into x[order:]
+func (br *bitReader) readResidual(x []int32, order int) error {
+	method, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	paramBits := uint(4)
+	switch method {
+	case 0:
+	case 1:
+		paramBits = 5
+	default:
+		return errors.New("reserved FLAC residual coding method")
+	}
+	escape := uint64(1)<<paramBits - 1
+
+	porder, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	size := len(x) >> porder
+	if size<<porder != len(x) || size < order {
+		return errors.New("invalid FLAC partition order")
+	}
+
+	for p := 0; p < 1<<porder; p++ {
+		from := p * size
+		if p == 0 {
+			from = order
+		}
+		part := x[from : (p+1)*size]
+
+		k, err := br.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			// Unencoded partition of fixed-width values
+			width, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for i := range part {
+				if part[i], err = br.readSigned(uint(width)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		for i := range part {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.readBits(uint(k))
+			if err != nil {
+				return err
+			}
+			u := uint32(q<<k | low)
+			part[i] = int32(u>>1) ^ -int32(u&1)
+		}
+	}
+	return nil
+}
+
+// bitReader reads values MSB first. Bytes are only taken from r when their
+// bits are needed, so the running CRCs cover exactly the bytes consumed.
+type bitReader struct {
+	r     *bufio.Reader
+	cache uint64
+	n     uint // unread bits in the low end of cache, always < 8 between calls
+
+	crc8  uint8
+	crc16 uint16
+}
+
+func (br *bitReader) loadByte() error {
+	b, err := br.r.ReadByte()
+	if err != nil {
+		if err == io.EOF && br.n > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	br.crc8 = crc8Table[br.crc8^b]
+	br.crc16 = br.crc16<<8 ^ crc16Table[byte(br.crc16>>8)^b]
+	br.cache = br.cache<<8 | uint64(b)
+	br.n += 8
+	return nil
+}
+
+// readBits reads an n-bit unsigned value, n <= 56
+func (br *bitReader) readBits(n uint) (uint64, error) {
+	for br.n < n {
+		if err := br.loadByte(); err != nil {
+			return 0, err
+		}
+	}
+	br.n -= n
+	return br.cache >> br.n & (1<<n - 1), nil
+}
+
+// readSigned reads an n-bit two's complement value
+func (br *bitReader) readSigned(n uint) (int32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	return int32(int64(v<<(64-n)) >> (64 - n)), nil
+}
+
+// readUnary counts the zero bits before the next one bit
+func (br *bitReader) readUnary() (uint64, error) {
+	var q uint64
+	for {
+		if br.n == 0 {
+			if err := br.loadByte(); err != nil {
+				return 0, err
+			}
+		}
+		rest := br.cache & (1<<br.n - 1)
+		if rest == 0 {
+			q += uint64(br.n)
+			br.n = 0
+			continue
+		}
+		zeros := br.n - uint(bits.Len64(rest))
+		br.n -= zeros + 1
+		return q + uint64(zeros), nil
+	}
+}
+
+// skipUTF8 skips a frame or sample number in extended UTF-8 coding
+func (br *bitReader) skipUTF8() error {
+	first, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	extra := bits.LeadingZeros8(^uint8(first))
+	if extra == 1 || extra > 7 {
+		return errors.New("invalid FLAC frame number")
+	}
+	for i := 1; i < extra; i++ {
+		if _, err := br.readBits(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// align skips to the next byte boundary
+func (br *bitReader) align() {
+	br.n = 0
+}
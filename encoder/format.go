@@ -0,0 +1,47 @@
+package encoder
+
+import (
+	"fmt"
+	"io"
+)
+
+// Output formats
+const (
+	FormatRaw  = "raw"  // Headerless CD-DA
+	FormatWAV  = "wav"  // CD-DA in a RIFF/WAVE container
+	FormatBIN  = "bin"  // CD-DA padded to whole sectors, for use with a cue sheet
+	FormatFLAC = "flac" // CD-DA compressed losslessly with FLAC
+)
+
+// writeHeader writes the container header for the configured output format
+// and returns the writer the track data goes to
+func (conv *Converter) writeHeader(w io.Writer) (io.Writer, error) {
+	switch conv.format {
+	case "", FormatRaw, FormatBIN:
+		return w, nil
+	case FormatWAV:
+		return w, WriteWAVHeader(w, conv.TrackSize())
+	case FormatFLAC:
+		return NewFLACWriter(w, conv.TrackSize()/BytesPerFrame)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", conv.format)
+	}
+}
+
+// writeTrailer completes the output after the last track data. w is the
+// writer returned by writeHeader.
+func (conv *Converter) writeTrailer(w io.Writer) error {
+	if fw, ok := w.(*FLACWriter); ok {
+		return fw.Close()
+	}
+	if conv.format != FormatBIN {
+		return nil
+	}
+
+	// Pad the last sector with silence
+	if rem := conv.TrackSize() % SectorSize; rem != 0 {
+		_, err := w.Write(make([]byte, SectorSize-rem))
+		return err
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package encoder
 
 import (
 	"fmt"
@@ -14,8 +14,8 @@ import (
 	"github.com/disintegration/imaging"
 )
 
-// loadImage loads an image file and returns an image.Image
-func loadImage(filename string) (image.Image, error) {
+// LoadImage loads an image file and returns an image.Image
+func LoadImage(filename string) (image.Image, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open image file: %w", err)
@@ -90,8 +90,9 @@ func processImageForDisc(img image.Image, discType string) image.Image {
 	return processedImg
 }
 
-// Enhanced image processing that mimics the original CD preview behavior
-func createDiscImage(img image.Image, discType string) image.Image {
+// CreateDiscImage scales and centers img on the 3000x3000 grayscale disc
+// raster that Converter samples. It mimics the original CD preview behavior.
+func CreateDiscImage(img image.Image, discType string) image.Image {
 	// Create a 3000x3000 disc image (matching original code)
 	discSize := 3000
 	discImg := imaging.New(discSize, discSize, color.RGBA{255, 255, 255, 255})
@@ -102,10 +103,7 @@ func createDiscImage(img image.Image, discType string) image.Image {
 	imgHeight := bounds.Dy()
 	
 	// Calculate scaling - fit image to roughly half the disc radius
-	maxRadius := 1200.0 // Usable radius for image
-	if discType == "dvd" {
-		maxRadius = 1300.0 // DVD has slightly larger usable area
-	}
+	maxRadius := fitRadius(discType)
 	
 	// Scale image to fit within the usable area
 	maxDimension := math.Max(float64(imgWidth), float64(imgHeight))
@@ -0,0 +1,215 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PaletteEntry is a byte written into the track and how much light the disc
+// reflects where it is burned. The reflectance may be in any unit, e.g.
+// percent or the gray value of a scan; only the spacing of the entries of a
+// palette matters.
+type PaletteEntry struct {
+	Byte        byte
+	Reflectance float64
+}
+
+// Palette holds the bytes a track is written with as levels ordered by
+// reflectance, 0 being the darkest. Gray 0 maps to the darkest level, gray
+// 255 to the brightest and the gray values in between to the levels around
+// them, in proportion to their reflectance.
+type Palette struct {
+	entries []PaletteEntry   // Darkest first
+	gray    []uint8          // Gray value of each level
+	bytes   [256]byte        // Byte of each level, indexed without a bounds check
+	levels  [256]uint8       // Level of each byte, or LevelUnknown
+	steps   [256]paletteStep // Levels around each gray value
+
+	// quantised holds quantise of each gray value for the whole thresholds
+	// 0 to 84, which most dithering engines use
+	quantised [85][256]uint8
+}
+
+// paletteStep places a gray value between two neighbouring levels
+type paletteStep struct {
+	level uint8   // Level at or below the gray value
+	mod   float64 // Distance above it, 0 at level to 85 at the next level
+}
+
+// DefaultPalette holds the bytes of the original converter, taken to be
+// evenly spaced
+var DefaultPalette = mustPalette([]PaletteEntry{
+	{0x10, 0},
+	{0x21, 1},
+	{0x28, 2},
+	{0xAA, 3},
+})
+
+func mustPalette(entries []PaletteEntry) *Palette {
+	p, err := NewPalette(entries)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// NewPalette returns the palette of entries, in any order. The bytes must
+// differ and the reflectances must lie far enough apart to give every level
+// its own gray value. Byte 00 cannot be used: Deinterleaver returns it for
+// the bytes lost at the end of a track.
+func NewPalette(entries []PaletteEntry) (*Palette, error) {
+	if len(entries) < 2 || len(entries) >= LevelUnknown {
+		return nil, fmt.Errorf("palette must have 2 to %d levels, not %d", LevelUnknown-1, len(entries))
+	}
+
+	p := &Palette{entries: append([]PaletteEntry(nil), entries...)}
+	sort.SliceStable(p.entries, func(i, j int) bool {
+		return p.entries[i].Reflectance < p.entries[j].Reflectance
+	})
+
+	for i := range p.levels {
+		p.levels[i] = LevelUnknown
+	}
+	dark := p.entries[0].Reflectance
+	bright := p.entries[len(p.entries)-1].Reflectance
+	for level, e := range p.entries {
+		if math.IsNaN(e.Reflectance) || math.IsInf(e.Reflectance, 0) {
+			return nil, fmt.Errorf("reflectance of byte %02X is not a number", e.Byte)
+		}
+		if e.Byte == 0 {
+			return nil, fmt.Errorf("byte 00 cannot be in a palette, it marks lost bytes when decoding")
+		}
+		if p.levels[e.Byte] != LevelUnknown {
+			return nil, fmt.Errorf("byte %02X is in the palette twice", e.Byte)
+		}
+		p.levels[e.Byte] = uint8(level)
+		p.bytes[level] = e.Byte
+
+		gray := uint8(math.Round(255 * (e.Reflectance - dark) / (bright - dark)))
+		if level > 0 && gray <= p.gray[level-1] {
+			return nil, fmt.Errorf("bytes %02X and %02X reflect too similar amounts of light", p.entries[level-1].Byte, e.Byte)
+		}
+		p.gray = append(p.gray, gray)
+	}
+
+	level := 0
+	for g := range p.steps {
+		for level < len(p.gray)-2 && g >= int(p.gray[level+1]) {
+			level++
+		}
+		lo, hi := int(p.gray[level]), int(p.gray[level+1])
+		p.steps[g] = paletteStep{
+			level: uint8(level),
+			mod:   float64(g-lo) * 85 / float64(hi-lo),
+		}
+	}
+	for t := range p.quantised {
+		for g := range p.quantised[t] {
+			p.quantised[t][g] = p.quantise(uint8(g), float64(t))
+		}
+	}
+	return p, nil
+}
+
+// ParsePalette reads a palette written as comma separated byte:reflectance
+// pairs, the byte in hex, e.g. "10:0,21:1,28:2,AA:3"
+func ParsePalette(s string) (*Palette, error) {
+	var entries []PaletteEntry
+	for _, item := range strings.Split(s, ",") {
+		b, r, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("palette entry '%s' is not byte:reflectance", item)
+		}
+		value, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(b), "0x"), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("palette entry '%s': invalid byte", item)
+		}
+		reflectance, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, fmt.Errorf("palette entry '%s': invalid reflectance", item)
+		}
+		entries = append(entries, PaletteEntry{Byte: byte(value), Reflectance: reflectance})
+	}
+	return NewPalette(entries)
+}
+
+// String returns the palette in the form read by ParsePalette
+func (p *Palette) String() string {
+	items := make([]string, len(p.entries))
+	for i, e := range p.entries {
+		items[i] = fmt.Sprintf("%02X:%g", e.Byte, e.Reflectance)
+	}
+	return strings.Join(items, ",")
+}
+
+// Entries returns the entries of the palette, darkest first
+func (p *Palette) Entries() []PaletteEntry {
+	return append([]PaletteEntry(nil), p.entries...)
+}
+
+// Len returns the number of levels
+func (p *Palette) Len() int {
+	return len(p.entries)
+}
+
+// Byte returns the byte written for level
+func (p *Palette) Byte(level uint8) byte {
+	return p.entries[level].Byte
+}
+
+// Level returns the level of a track byte, or LevelUnknown if b is not in
+// the palette
+func (p *Palette) Level(b byte) uint8 {
+	return p.levels[b]
+}
+
+// Gray returns the gray value level stands for
+func (p *Palette) Gray(level uint8) uint8 {
+	return p.gray[level]
+}
+
+// quantise returns the level of gray for the threshold t, 0 to 84: the
+// level below gray, or the one above if the remainder exceeds t. Gray values
+// within 1/85 of a step below the next level always round up.
+func (p *Palette) quantise(gray uint8, t float64) uint8 {
+	s := p.steps[gray]
+	if int(s.level) < len(p.gray)-1 && (s.mod > t || s.mod >= 84) {
+		return s.level + 1
+	}
+	return s.level
+}
+
+// quantiseAt is quantise for a whole threshold t, 0 to 84, from a table
+func (p *Palette) quantiseAt(gray uint8, t int) uint8 {
+	return p.quantised[t][gray]
+}
+
+// round returns the level nearest to value, a gray value divided by 85,
+// rounding up from the fraction threshold of the step between two levels on
+func (p *Palette) round(value, threshold float64) uint8 {
+	top := len(p.gray) - 1
+	if value <= p.position(0) {
+		return 0
+	}
+	if value >= p.position(uint8(top)) {
+		return uint8(top)
+	}
+	level := p.steps[min(int(value*85), 255)].level
+	for int(level) < top-1 && value >= p.position(level+1) {
+		level++
+	}
+	lo, hi := p.position(level), p.position(level+1)
+	if (value-lo)/(hi-lo) > threshold {
+		return level + 1
+	}
+	return level
+}
+
+// position returns the gray value of level divided by 85, the scale of the
+// error diffusion
+func (p *Palette) position(level uint8) float64 {
+	return float64(p.gray[level]) / 85
+}
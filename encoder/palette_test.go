@@ -0,0 +1,60 @@
+package encoder
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNewPaletteRejects(t *testing.T) {
+	tests := map[string][]PaletteEntry{
+		"one level":     {{0x10, 0}},
+		"byte 00":       {{0x00, 0}, {0x21, 1}, {0xAA, 2}},
+		"repeated byte": {{0x10, 0}, {0x21, 1}, {0x10, 2}},
+		"too similar":   {{0x10, 0}, {0x21, 1000}, {0x28, 1000.1}},
+	}
+	for name, entries := range tests {
+		if _, err := NewPalette(entries); err == nil {
+			t.Errorf("%s: palette accepted", name)
+		}
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	p, err := ParsePalette("AA:70, 10:12,0x21:30,28:33")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.String(); s != "10:12,21:30,28:33,AA:70" {
+		t.Errorf("palette reads back as %s", s)
+	}
+	if p.Byte(0) != 0x10 || p.Byte(3) != 0xAA || p.Level(0x28) != 2 || p.Level(0x00) != LevelUnknown {
+		t.Error("levels not ordered by reflectance")
+	}
+	if p.Gray(0) != 0 || p.Gray(3) != 255 {
+		t.Errorf("levels span gray %d to %d", p.Gray(0), p.Gray(3))
+	}
+}
+
+// TestLostBytesUnknown checks that the bytes lost at the end of a track
+// decode as LevelUnknown
+func TestLostBytesUnknown(t *testing.T) {
+	opts := testOptions()
+	opts.totalSize = 1 << 16
+	img := testImage()
+	raw := convert(t, NewConverter(opts), img)
+
+	got, err := io.ReadAll(NewDeinterleaver(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := 0
+	for _, b := range got {
+		if DefaultPalette.Level(b) == LevelUnknown {
+			unknown++
+		}
+	}
+	if unknown == 0 || unknown > (maxLag+1)*24 {
+		t.Errorf("%d bytes decode as unknown", unknown)
+	}
+}
@@ -0,0 +1,94 @@
+package encoder
+
+import (
+	"image"
+	"math"
+)
+
+// Intermediate resolutions of LoadSourceImage for direct sampling, the
+// longest side of the reduced image in pixels. The disc raster of
+// CreateDiscImage spans 3000 pixels.
+const (
+	CDResolution  = 6000
+	DVDResolution = 12000
+)
+
+// DefaultResolution returns the intermediate resolution for discType
+func DefaultResolution(discType string) int {
+	if discType == "dvd" {
+		return DVDResolution
+	}
+	return CDResolution
+}
+
+// Placement positions a source image on the disc, which the converter then
+// samples directly instead of the disc raster of CreateDiscImage. The zero
+// Placement centres the image at the size CreateDiscImage gives it.
+type Placement struct {
+	Scale    float64 // Size relative to the fit of CreateDiscImage, 0 for 1
+	OffsetX  float64 // Centre of the image right of the disc centre in mm
+	OffsetY  float64 // Centre of the image below the disc centre in mm
+	Rotation float64 // Clockwise rotation of the image in degrees
+}
+
+// fitRadius returns half the longest side of the image CreateDiscImage
+// places on the disc raster, in pixels
+func fitRadius(discType string) float64 {
+	if discType == "dvd" {
+		return 1300.0 // DVD has slightly larger usable area
+	}
+	return 1200.0
+}
+
+// affine maps a point of the disc raster, given from the disc centre, to a
+// point of the image sampled
+type affine struct {
+	xx, xy, x0 float64
+	yx, yy, y0 float64
+}
+
+// apply returns the point of the image at (dx, dy) from the disc centre
+func (m affine) apply(dx, dy float64) (x, y float64) {
+	return m.xx*dx + m.xy*dy + m.x0, m.yx*dx + m.yy*dy + m.y0
+}
+
+// raster returns the raster of img placed on a disc of discType. img is
+// best a SourceImage; any other image is converted to gray values at full
+// size.
+func (p Placement) raster(img image.Image, discType string) *raster {
+	src, ok := img.(*SourceImage)
+	if !ok {
+		bounds := img.Bounds()
+		src = &SourceImage{
+			Gray:      grayRaster(img),
+			Width:     bounds.Dx(),
+			Height:    bounds.Dy(),
+			Reduction: 1,
+		}
+	}
+
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	// Pixels of the gray image per pixel of the disc raster, and the offset
+	// of the image centre in pixels of the disc raster
+	s := float64(max(src.Width, src.Height)) / (2 * fitRadius(discType) * scale) / src.Reduction
+	ox := p.OffsetX * imageRadius / discRadius
+	oy := p.OffsetY * imageRadius / discRadius
+
+	// The image is turned clockwise on the disc, so the disc is turned back
+	// into the image
+	sin, cos := math.Sincos(p.Rotation * math.Pi / 180)
+	return &raster{
+		Gray: src.Gray,
+		toImage: affine{
+			xx: s * cos, xy: s * sin, x0: float64(src.Width)/(2*src.Reduction) - s*(ox*cos+oy*sin),
+			yx: -s * sin, yy: s * cos, y0: float64(src.Height)/(2*src.Reduction) - s*(oy*cos-ox*sin),
+		},
+		turn:   turn{sin: -sin, cos: cos},
+		scale:  s,
+		placed: true,
+	}
+}
@@ -0,0 +1,394 @@
+package encoder
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image/color"
+	"io"
+)
+
+// errNotPNG is returned by newPNGStream for data without the PNG signature
+var errNotPNG = errors.New("not a PNG file")
+
+// PNG color types
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// pngStream decodes a PNG image one row at a time into the gray values of
+// grayRaster, holding two rows of the image in memory. Interlaced images
+// are only recognised; their rows are not stored in order.
+type pngStream struct {
+	width, height int
+	interlaced    bool
+
+	colorType byte
+	depth     int        // Bits per sample
+	palette   [256]uint8 // Gray values of the palette entries
+	key       [3]uint16  // Transparent color of gray and RGB images
+	keyed     bool
+
+	z         io.Reader
+	cur, prev []byte // Filter type and bytes of the current and previous row
+	bpp       int    // Bytes per pixel for the filters, at least 1
+}
+
+// newPNGStream reads the chunks of a PNG image up to its image data
+func newPNGStream(r io.Reader) (*pngStream, error) {
+	var signature [8]byte
+	if _, err := io.ReadFull(r, signature[:]); err != nil || string(signature[:]) != "\x89PNG\r\n\x1a\n" {
+		return nil, errNotPNG
+	}
+
+	s := &pngStream{}
+	var rgb [256][3]uint8
+	var alpha [256]uint8
+	for i := range alpha {
+		alpha[i] = 0xff
+	}
+	entries := 0
+	for first := true; ; first = false {
+		length, typ, err := readChunkHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if first != (typ == "IHDR") {
+			return nil, fmt.Errorf("IHDR is not the first chunk")
+		}
+
+		switch typ {
+		case "IHDR", "PLTE", "tRNS":
+			if length > 3*256 {
+				return nil, fmt.Errorf("%s chunk too long", typ)
+			}
+			data := make([]byte, length)
+			if err := readChunkData(r, typ, data); err != nil {
+				return nil, err
+			}
+			switch typ {
+			case "IHDR":
+				if err := s.parseHeader(data); err != nil || s.interlaced {
+					return s, err
+				}
+			case "PLTE":
+				if length%3 != 0 {
+					return nil, fmt.Errorf("bad palette length")
+				}
+				entries = int(length / 3)
+				for i := 0; i < entries; i++ {
+					copy(rgb[i][:], data[3*i:])
+				}
+			case "tRNS":
+				switch s.colorType {
+				case pngPaletted:
+					copy(alpha[:], data)
+				case pngGray:
+					if length >= 2 {
+						s.key[0], s.keyed = binary.BigEndian.Uint16(data), true
+					}
+				case pngRGB:
+					if length >= 6 {
+						for c := range s.key {
+							s.key[c] = binary.BigEndian.Uint16(data[2*c:])
+						}
+						s.keyed = true
+					}
+				}
+			}
+
+		case "IDAT":
+			if s.colorType == pngPaletted && entries == 0 {
+				return nil, fmt.Errorf("missing palette")
+			}
+
+			// Entries past the palette read as opaque black, as in image/png
+			for i := 0; i < entries; i++ {
+				r, g, b, _ := color.NRGBA{rgb[i][0], rgb[i][1], rgb[i][2], alpha[i]}.RGBA()
+				s.palette[i] = luma(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			}
+			idat := &idatReader{r: r, remain: length, crc: crc32.NewIEEE()}
+			idat.crc.Write([]byte(typ))
+			z, err := zlib.NewReader(idat)
+			if err != nil {
+				return nil, err
+			}
+			s.z = z
+			return s, nil
+
+		case "IEND":
+			return nil, fmt.Errorf("no image data")
+
+		default:
+			// Ancillary chunks carry nothing the gray values depend on
+			if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// parseHeader reads the IHDR chunk
+func (s *pngStream) parseHeader(data []byte) error {
+	if len(data) != 13 {
+		return fmt.Errorf("bad IHDR length")
+	}
+	width := binary.BigEndian.Uint32(data[0:4])
+	height := binary.BigEndian.Uint32(data[4:8])
+	s.depth, s.colorType = int(data[8]), data[9]
+	if width == 0 || height == 0 || width > 1<<24 || height > 1<<24 {
+		return fmt.Errorf("unsupported image size %dx%d", width, height)
+	}
+	if data[10] != 0 || data[11] != 0 || data[12] > 1 {
+		return fmt.Errorf("unsupported compression, filter or interlace method")
+	}
+	s.width, s.height = int(width), int(height)
+	s.interlaced = data[12] == 1
+
+	channels := 0
+	switch {
+	case s.colorType == pngGray && (s.depth == 1 || s.depth == 2 || s.depth == 4 || s.depth == 8 || s.depth == 16):
+		channels = 1
+	case s.colorType == pngPaletted && (s.depth == 1 || s.depth == 2 || s.depth == 4 || s.depth == 8):
+		channels = 1
+	case s.colorType == pngGrayAlpha && (s.depth == 8 || s.depth == 16):
+		channels = 2
+	case s.colorType == pngRGB && (s.depth == 8 || s.depth == 16):
+		channels = 3
+	case s.colorType == pngRGBA && (s.depth == 8 || s.depth == 16):
+		channels = 4
+	default:
+		return fmt.Errorf("unsupported color type %d with bit depth %d", s.colorType, s.depth)
+	}
+
+	rowBytes := (s.width*channels*s.depth + 7) / 8
+	s.cur = make([]byte, 1+rowBytes)
+	s.prev = make([]byte, 1+rowBytes)
+	s.bpp = max(channels*s.depth/8, 1)
+	return nil
+}
+
+// grayRow decodes the next row of the image into row, which has a byte for
+// each pixel
+func (s *pngStream) grayRow(row []byte) error {
+	if _, err := io.ReadFull(s.z, s.cur); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := unfilter(s.cur[0], s.cur[1:], s.prev[1:], s.bpp); err != nil {
+		return err
+	}
+	s.toGray(s.cur[1:], row[:s.width])
+	s.cur, s.prev = s.prev, s.cur
+	return nil
+}
+
+// toGray converts the bytes of an unfiltered row to gray values like
+// grayRaster converts the image image/png decodes: transparent pixels are
+// premultiplied to black and 16-bit samples cut to their high byte
+func (s *pngStream) toGray(data, row []byte) {
+	wide := s.depth == 16
+	switch s.colorType {
+	case pngGray:
+		mask := 1<<min(s.depth, 8) - 1
+		for x := range row {
+			var v, key int
+			switch {
+			case wide:
+				v, key = int(data[2*x]), int(binary.BigEndian.Uint16(data[2*x:]))
+			case s.depth == 8:
+				v, key = int(data[x]), int(data[x])
+			default:
+				bit := x * s.depth
+				key = int(data[bit/8]>>(8-s.depth-bit%8)) & mask
+				v = key * 0xff / mask
+			}
+			if s.keyed && uint16(key) == s.key[0] {
+				row[x] = 0
+				continue
+			}
+			row[x] = luma(uint8(v), uint8(v), uint8(v))
+		}
+
+	case pngPaletted:
+		mask := 1<<s.depth - 1
+		for x := range row {
+			bit := x * s.depth
+			row[x] = s.palette[int(data[bit/8]>>(8-s.depth-bit%8))&mask]
+		}
+
+	case pngRGB:
+		for x := range row {
+			var rgb, key [3]uint16
+			for c := range rgb {
+				if wide {
+					key[c] = binary.BigEndian.Uint16(data[6*x+2*c:])
+					rgb[c] = key[c] >> 8
+				} else {
+					key[c] = uint16(data[3*x+c])
+					rgb[c] = key[c]
+				}
+			}
+			if s.keyed && key == s.key {
+				row[x] = 0
+				continue
+			}
+			row[x] = luma(uint8(rgb[0]), uint8(rgb[1]), uint8(rgb[2]))
+		}
+
+	case pngGrayAlpha, pngRGBA:
+		channels := 2
+		if s.colorType == pngRGBA {
+			channels = 4
+		}
+		for x := range row {
+			var c [4]uint16
+			for i := range c[:channels] {
+				if wide {
+					c[i] = binary.BigEndian.Uint16(data[2*(channels*x+i):])
+				} else {
+					c[i] = uint16(data[channels*x+i]) * 0x101
+				}
+			}
+			if channels == 2 {
+				c = [4]uint16{c[0], c[0], c[0], c[1]}
+			}
+			r, g, b, _ := color.NRGBA64{c[0], c[1], c[2], c[3]}.RGBA()
+			row[x] = luma(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		}
+	}
+}
+
+// unfilter reverses the filter of a row in place, prev being the previous
+// unfiltered row, zero for the first one
+func unfilter(filter byte, cur, prev []byte, bpp int) error {
+	switch filter {
+	case 0: // None
+	case 1: // Sub
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2: // Up
+		for i, p := range prev {
+			cur[i] += p
+		}
+	case 3: // Average
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(prev[i])) / 2)
+		}
+	case 4: // Paeth
+		for i := 0; i < bpp; i++ {
+			cur[i] += prev[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += paeth(cur[i-bpp], prev[i], prev[i-bpp])
+		}
+	default:
+		return fmt.Errorf("bad filter type %d", filter)
+	}
+	return nil
+}
+
+// paeth returns whichever of the left, upper and upper left bytes is
+// closest to their linear prediction
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// readChunkHeader reads the length and type of the next chunk
+func readChunkHeader(r io.Reader) (uint32, string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, "", err
+	}
+	return binary.BigEndian.Uint32(header[:4]), string(header[4:]), nil
+}
+
+// readChunkData reads the data of a chunk into data and checks its CRC
+func readChunkData(r io.Reader, typ string, data []byte) error {
+	var crc [4]byte
+	if _, err := io.ReadFull(r, data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(r, crc[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	sum := crc32.Update(crc32.ChecksumIEEE([]byte(typ)), crc32.IEEETable, data)
+	if sum != binary.BigEndian.Uint32(crc[:]) {
+		return fmt.Errorf("%s chunk checksum mismatch", typ)
+	}
+	return nil
+}
+
+// idatReader reads the image data split over consecutive IDAT chunks,
+// checking the CRC of each
+type idatReader struct {
+	r      io.Reader
+	remain uint32 // Bytes left in the current chunk
+	crc    hash.Hash32
+	done   bool
+}
+
+func (ir *idatReader) Read(p []byte) (int, error) {
+	for ir.remain == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		var crc [4]byte
+		if _, err := io.ReadFull(ir.r, crc[:]); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if ir.crc.Sum32() != binary.BigEndian.Uint32(crc[:]) {
+			return 0, fmt.Errorf("IDAT chunk checksum mismatch")
+		}
+		length, typ, err := readChunkHeader(ir.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			ir.done = true
+			return 0, io.EOF
+		}
+		ir.remain = length
+		ir.crc.Reset()
+		ir.crc.Write([]byte(typ))
+	}
+
+	n, err := ir.r.Read(p[:min(uint32(len(p)), ir.remain)])
+	ir.crc.Write(p[:n])
+	ir.remain -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
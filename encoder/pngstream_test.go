@@ -0,0 +1,209 @@
+package encoder
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// pngTest describes a PNG image written by writePNG
+type pngTest struct {
+	colorType byte
+	depth     int
+	entries   int  // Palette entries of a paletted image
+	trns      bool // Transparent palette entries or color key
+}
+
+// channels returns the samples per pixel of the color type
+func (p pngTest) channels() int {
+	return map[byte]int{pngGray: 1, pngRGB: 3, pngPaletted: 1, pngGrayAlpha: 2, pngRGBA: 4}[p.colorType]
+}
+
+// writePNG returns a PNG of random pixels. Row y is filtered with filter
+// type y%5, and the image data is split into IDAT chunks of idatSize bytes.
+func writePNG(p pngTest, width, height, idatSize int, rng *rand.Rand) []byte {
+	rowBytes := (width*p.channels()*p.depth + 7) / 8
+	bpp := max(p.channels()*p.depth/8, 1)
+
+	rows := make([][]byte, height)
+	for y := range rows {
+		rows[y] = make([]byte, rowBytes)
+		rng.Read(rows[y])
+	}
+
+	var out bytes.Buffer
+	chunk := func(typ string, data []byte) {
+		binary.Write(&out, binary.BigEndian, uint32(len(data)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(typ))
+		crc.Write(data)
+		out.WriteString(typ)
+		out.Write(data)
+		binary.Write(&out, binary.BigEndian, crc.Sum32())
+	}
+	out.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = byte(p.depth), p.colorType
+	chunk("IHDR", ihdr)
+	chunk("tEXt", []byte("Comment\x00skipped"))
+
+	if p.colorType == pngPaletted {
+		plte := make([]byte, 3*p.entries)
+		rng.Read(plte)
+		chunk("PLTE", plte)
+	}
+	if p.trns {
+		switch p.colorType {
+		case pngPaletted:
+			alpha := make([]byte, p.entries/2)
+			rng.Read(alpha)
+			alpha[0] = 0
+			chunk("tRNS", alpha)
+		case pngGray, pngRGB:
+			// The first pixel is the transparent color, found again wherever
+			// the image repeats it
+			key := make([]byte, 2*p.channels())
+			for c := 0; c < p.channels(); c++ {
+				if p.depth == 16 {
+					copy(key[2*c:], rows[0][2*c:2*c+2])
+				} else {
+					key[2*c+1] = rows[0][c] >> (8 - p.depth)
+				}
+			}
+			chunk("tRNS", key)
+			copy(rows[height-1], rows[0][:bpp])
+		}
+	}
+
+	var data bytes.Buffer
+	z := zlib.NewWriter(&data)
+	prev := make([]byte, rowBytes)
+	filtered := make([]byte, 1+rowBytes)
+	for y, cur := range rows {
+		filtered[0] = byte(y % 5)
+		for i := range cur {
+			var a, b, c byte
+			if i >= bpp {
+				a, c = cur[i-bpp], prev[i-bpp]
+			}
+			b = prev[i]
+			switch filtered[0] {
+			case 0:
+				filtered[1+i] = cur[i]
+			case 1:
+				filtered[1+i] = cur[i] - a
+			case 2:
+				filtered[1+i] = cur[i] - b
+			case 3:
+				filtered[1+i] = cur[i] - uint8((int(a)+int(b))/2)
+			case 4:
+				filtered[1+i] = cur[i] - paeth(a, b, c)
+			}
+		}
+		z.Write(filtered)
+		prev = cur
+	}
+	z.Close()
+
+	for idat := data.Bytes(); len(idat) > 0; {
+		n := min(idatSize, len(idat))
+		chunk("IDAT", idat[:n])
+		idat = idat[n:]
+	}
+	chunk("IEND", nil)
+	return out.Bytes()
+}
+
+// TestPNGStream checks the rows of pngStream against the image image/png
+// decodes, converted by grayRaster
+func TestPNGStream(t *testing.T) {
+	tests := []pngTest{
+		{pngGray, 1, 0, false},
+		{pngGray, 2, 0, true},
+		{pngGray, 4, 0, false},
+		{pngGray, 8, 0, true},
+		{pngGray, 16, 0, false},
+		{pngGray, 16, 0, true},
+		{pngGrayAlpha, 8, 0, false},
+		{pngGrayAlpha, 16, 0, false},
+		{pngRGB, 8, 0, true},
+		{pngRGB, 16, 0, false},
+		{pngRGB, 16, 0, true},
+		{pngRGBA, 8, 0, false},
+		{pngRGBA, 16, 0, false},
+		{pngPaletted, 1, 2, false},
+		{pngPaletted, 2, 4, true},
+		{pngPaletted, 4, 16, true},
+		{pngPaletted, 8, 256, true},
+		{pngPaletted, 8, 100, false}, // Indices past the palette are black
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		width, height := 37, 23
+		file := writePNG(tt, width, height, 100, rng)
+
+		img, err := png.Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%+v: image/png: %v", tt, err)
+		}
+		want := grayRaster(img)
+
+		s, err := newPNGStream(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%+v: %v", tt, err)
+		}
+		if s.width != width || s.height != height || s.interlaced {
+			t.Fatalf("%+v: header %dx%d, interlaced %t", tt, s.width, s.height, s.interlaced)
+		}
+		row := make([]byte, width)
+		for y := 0; y < height; y++ {
+			if err := s.grayRow(row); err != nil {
+				t.Fatalf("%+v: row %d: %v", tt, y, err)
+			}
+			if wantRow := want.Pix[y*want.Stride:][:width]; !bytes.Equal(row, wantRow) {
+				t.Fatalf("%+v: row %d (filter %d) is %v, expected %v", tt, y, y%5, row, wantRow)
+			}
+		}
+	}
+}
+
+func TestPNGStreamErrors(t *testing.T) {
+	file := writePNG(pngTest{pngRGB, 8, 0, false}, 20, 10, 50, rand.New(rand.NewSource(2)))
+	if _, err := newPNGStream(bytes.NewReader([]byte("GIF89a"))); err != errNotPNG {
+		t.Errorf("GIF: got %v, expected errNotPNG", err)
+	}
+
+	// A damaged IDAT chunk fails its CRC before the end of the image
+	damaged := bytes.Clone(file)
+	damaged[bytes.Index(damaged, []byte("IDAT"))+10] ^= 0xff
+	if err := readPNGRows(damaged); err == nil {
+		t.Error("damaged image data decoded without error")
+	}
+
+	truncated := file[:len(file)-40]
+	if err := readPNGRows(truncated); err == nil {
+		t.Error("truncated image decoded without error")
+	}
+}
+
+// readPNGRows decodes every row of a PNG with pngStream
+func readPNGRows(file []byte) error {
+	s, err := newPNGStream(bytes.NewReader(file))
+	if err != nil {
+		return err
+	}
+	row := make([]byte, s.width)
+	for y := 0; y < s.height; y++ {
+		if err := s.grayRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package encoder
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// rotationRun is the number of samples of a track turned from the exact
+// point at the first of them
+const rotationRun = 256
+
+// edgeMargin is how close to a pixel edge a turned sample point is
+// recomputed from its exact angle, 2^-20 pixels in 32.32 fixed point. A
+// turned point lies less than 1e-9 pixels from the exact one.
+const edgeMargin = 1 << 12
+
+// raster is the gray image the converter samples and where its pixels lie
+// on the disc raster. Without a Placement it is the disc raster itself.
+type raster struct {
+	*image.Gray
+	toImage affine  // Disc raster to image pixels
+	turn    turn    // Rotation of directions on the disc raster into the image
+	scale   float64 // Image pixels per disc raster pixel
+	placed  bool    // Placed through a Placement, white around it
+}
+
+// discRaster returns the raster of img, a disc raster as returned by
+// CreateDiscImage
+func discRaster(img image.Image) *raster {
+	gray := grayRaster(img)
+	return &raster{
+		Gray: gray,
+		toImage: affine{
+			xx: 1, x0: float64(gray.Rect.Dx()) / 2,
+			yy: 1, y0: float64(gray.Rect.Dy()) / 2,
+		},
+		turn:  turn{cos: 1},
+		scale: 1,
+	}
+}
+
+// background is the gray value around a placed image, the white of the
+// disc raster
+const background = 0xff
+
+// grayRaster converts img to the gray values the converter samples: the
+// luminance of the 8-bit RGB of each pixel, truncated as by the original
+// converter. Converting once spares the sampling loops a call to At and a
+// color conversion for every sample. The result starts at (0, 0).
+func grayRaster(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+
+	switch src := img.(type) {
+	case *image.Gray:
+		var lut [256]uint8
+		for i := range lut {
+			lut[i] = luma(uint8(i), uint8(i), uint8(i))
+		}
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:w]
+			out := gray.Pix[y*gray.Stride:][:w]
+			for x, v := range row {
+				out[x] = lut[v]
+			}
+		}
+	case *image.NRGBA:
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:4*w]
+			out := gray.Pix[y*gray.Stride:][:w]
+			for x := range out {
+				p := row[4*x : 4*x+4]
+				if p[3] == 0xff {
+					out[x] = luma(p[0], p[1], p[2])
+					continue
+				}
+				r, g, b, _ := color.NRGBA{p[0], p[1], p[2], p[3]}.RGBA()
+				out[x] = luma(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):][:4*w]
+			out := gray.Pix[y*gray.Stride:][:w]
+			for x := range out {
+				out[x] = luma(row[4*x], row[4*x+1], row[4*x+2])
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			out := gray.Pix[y*gray.Stride:][:w]
+			for x := range out {
+				r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				out[x] = luma(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			}
+		}
+	}
+
+	return gray
+}
+
+// luma is the luminance formula of the original converter, 0.299*R +
+// 0.587*G + 0.114*B truncated
+func luma(r, g, b uint8) uint8 {
+	return byte(float64(r)*0.299 + float64(g)*0.587 + float64(b)*0.114)
+}
+
+// nearEdge reports whether v, in 32.32 fixed point pixels, lies within
+// edgeMargin of a pixel edge, where the pixel of a turned point may differ
+// from the exact one
+func nearEdge(v int64) bool {
+	return uint32(v+edgeMargin) < 2*edgeMargin
+}
+
+// turnTable holds the sine and cosine of the first rotationRun multiples of
+// the sample angle of a track. A run of samples is the point of its first
+// sample turned by the entries of the table, without a dependency from one
+// sample to the next.
+type turnTable [rotationRun]turn
+
+// turn is a rotation by an angle
+type turn struct{ sin, cos float64 }
+
+// fill sets the first count entries of the table for a track of samples
+// samples by repeated rotation, which is exact to well below 1e-9 pixels
+// over a run
+func (t *turnTable) fill(samples, count int) {
+	dsin, dcos := math.Sincos(2 * math.Pi / float64(samples))
+	sin, cos := 0.0, 1.0
+	for j := range t[:min(count, rotationRun)] {
+		t[j].sin, t[j].cos = sin, cos
+		sin, cos = sin*dcos+cos*dsin, cos*dcos-sin*dsin
+	}
+}
@@ -0,0 +1,154 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Filters selectable in Options.Sampling to read the disc raster between
+// its pixels
+const (
+	SamplingNearest  = "nearest"  // The pixel the sample falls in, as the original converter
+	SamplingBilinear = "bilinear" // Linear between the 2x2 pixels around the sample
+	SamplingBicubic  = "bicubic"  // Catmull-Rom spline through the 4x4 pixels around the sample
+)
+
+// SamplingFilters returns the names of the sampling filters
+func SamplingFilters() []string {
+	return []string{SamplingNearest, SamplingBilinear, SamplingBicubic}
+}
+
+// Limits of the area prefilter
+const (
+	areaStep       = 0.5 // Largest distance between the points averaged, in pixels
+	maxAreaSamples = 16  // Points averaged along each side of the footprint
+)
+
+// sampler reads the gray value of the disc raster at any point
+type sampler struct {
+	filter string
+	area   bool // Average over the footprint of the sample
+}
+
+// newSampler checks the filter name, empty meaning nearest
+func newSampler(filter string, area bool) (sampler, error) {
+	filter = strings.ToLower(filter)
+	switch filter {
+	case "":
+		filter = SamplingNearest
+	case SamplingNearest, SamplingBilinear, SamplingBicubic:
+	default:
+		return sampler{}, fmt.Errorf("unknown sampling filter '%s' (must be one of: %s)", filter, strings.Join(SamplingFilters(), ", "))
+	}
+	return sampler{filter: filter, area: area}, nil
+}
+
+// exact reports whether the sampler reads single pixels like the original
+// converter, which sampleTrack does without the filter code
+func (s sampler) exact() bool {
+	return s.filter == SamplingNearest && !s.area
+}
+
+// footprint is the part of the disc a sample stands for, in pixels of the
+// raster: the track pitch across the track and the sample spacing along it
+type footprint struct {
+	radial, tangential float64
+	nr, nt             int // Points averaged across and along the track
+}
+
+// newFootprint returns the footprint of the samples of a track of samples
+// at radius ri pixels, tracks lying pitch pixels apart
+func newFootprint(ri, pitch float64, samples int) footprint {
+	f := footprint{
+		radial:     pitch,
+		tangential: 2 * math.Pi * ri / float64(samples),
+	}
+	f.nr = min(max(int(math.Ceil(f.radial/areaStep)), 1), maxAreaSamples)
+	f.nt = min(max(int(math.Ceil(f.tangential/areaStep)), 1), maxAreaSamples)
+	return f
+}
+
+// sample returns the gray value at (x, y), a sample whose track runs along
+// the direction at angle alpha, whose sine and cosine are given. Without
+// the area prefilter the footprint is not used.
+func (s sampler) sample(src *raster, x, y, sin, cos float64, f footprint) byte {
+	if !s.area || f.nr*f.nt == 1 {
+		return grayByte(s.at(src, x, y))
+	}
+
+	// Average a grid of points over the footprint, across the track along
+	// the radius (cos, sin) and along it
+	sum := 0.0
+	for i := 0; i < f.nr; i++ {
+		dr := ((float64(i)+0.5)/float64(f.nr) - 0.5) * f.radial
+		for j := 0; j < f.nt; j++ {
+			dt := ((float64(j)+0.5)/float64(f.nt) - 0.5) * f.tangential
+			sum += s.at(src, x+dr*cos-dt*sin, y+dr*sin+dt*cos)
+		}
+	}
+	return grayByte(sum / float64(f.nr*f.nt))
+}
+
+// at interpolates the luminance at (x, y), pixel (i, j) covering
+// [i, i+1) x [j, j+1)
+func (s sampler) at(src *raster, x, y float64) float64 {
+	switch s.filter {
+	case SamplingBilinear:
+		x, y = x-0.5, y-0.5
+		x0, y0 := math.Floor(x), math.Floor(y)
+		fx, fy := x-x0, y-y0
+		i, j := int(x0), int(y0)
+		top := lerp(luminance(src, i, j), luminance(src, i+1, j), fx)
+		bottom := lerp(luminance(src, i, j+1), luminance(src, i+1, j+1), fx)
+		return lerp(top, bottom, fy)
+	case SamplingBicubic:
+		x, y = x-0.5, y-0.5
+		x0, y0 := math.Floor(x), math.Floor(y)
+		wx, wy := catmullRom(x-x0), catmullRom(y-y0)
+		i, j := int(x0), int(y0)
+		v := 0.0
+		for m := 0; m < 4; m++ {
+			row := 0.0
+			for n := 0; n < 4; n++ {
+				row += wx[n] * luminance(src, i-1+n, j-1+m)
+			}
+			v += wy[m] * row
+		}
+		return v
+	}
+	return luminance(src, int(math.Floor(x)), int(math.Floor(y)))
+}
+
+// luminance returns the gray value of pixel (x, y) of the gray raster,
+// clamped to the disc raster. A placed image lies on white.
+func luminance(src *raster, x, y int) float64 {
+	if src.placed && (uint(x) >= uint(src.Rect.Dx()) || uint(y) >= uint(src.Rect.Dy())) {
+		return background
+	}
+	x = min(max(x, 0), src.Rect.Dx()-1)
+	y = min(max(y, 0), src.Rect.Dy()-1)
+	return float64(src.Pix[y*src.Stride+x])
+}
+
+// catmullRom returns the weights of the 4 pixels around a point t of the
+// way from the second to the third
+func catmullRom(t float64) [4]float64 {
+	t2, t3 := t*t, t*t*t
+	return [4]float64{
+		(-t3 + 2*t2 - t) / 2,
+		(3*t3 - 5*t2 + 2) / 2,
+		(-3*t3 + 4*t2 + t) / 2,
+		(t3 - t2) / 2,
+	}
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
+
+// grayByte rounds a luminance to a gray value, clamping the overshoot of
+// the bicubic filter
+func grayByte(v float64) byte {
+	return byte(min(max(math.Round(v), 0), 255))
+}
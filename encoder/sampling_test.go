@@ -0,0 +1,121 @@
+package encoder
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testRaster returns a w x h disc raster of the gray values of gray(x, y)
+func testRaster(w, h int, gray func(x, y int) uint8) *raster {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Pix[y*img.Stride+x] = gray(x, y)
+		}
+	}
+	return &raster{Gray: img, turn: turn{cos: 1}, scale: 1}
+}
+
+func newTestSampler(t *testing.T, filter string, area bool) sampler {
+	t.Helper()
+	s, err := newSampler(filter, area)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// TestSamplingPixelCenters checks that every filter gives the pixel itself
+// at its center, the rim of the raster included
+func TestSamplingPixelCenters(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	src := testRaster(13, 9, func(x, y int) uint8 { return uint8(rng.Intn(256)) })
+	for _, filter := range SamplingFilters() {
+		s := newTestSampler(t, filter, false)
+		for y := 0; y < 9; y++ {
+			for x := 0; x < 13; x++ {
+				if got, want := s.at(src, float64(x)+0.5, float64(y)+0.5), float64(src.GrayAt(x, y).Y); math.Abs(got-want) > 1e-9 {
+					t.Fatalf("%s: %g at the center of pixel %d,%d of gray %g", filter, got, x, y, want)
+				}
+			}
+		}
+	}
+
+	// Bilinear is linear between the centers
+	s := newTestSampler(t, SamplingBilinear, false)
+	want := (float64(src.GrayAt(4, 2).Y) + float64(src.GrayAt(5, 2).Y)) / 2
+	if got := s.at(src, 5, 2.5); math.Abs(got-want) > 1e-9 {
+		t.Errorf("bilinear: %g between two pixels, expected %g", got, want)
+	}
+}
+
+// TestSamplingConstant checks that a flat raster stays flat through every
+// filter and the area prefilter, also around its rim
+func TestSamplingConstant(t *testing.T) {
+	src := testRaster(16, 16, func(x, y int) uint8 { return 137 })
+	rng := rand.New(rand.NewSource(2))
+	for _, filter := range SamplingFilters() {
+		for _, area := range []bool{false, true} {
+			s := newTestSampler(t, filter, area)
+			f := newFootprint(6, 2.3, 7)
+			for i := 0; i < 1000; i++ {
+				x, y := rng.Float64()*20-2, rng.Float64()*20-2
+				sin, cos := math.Sincos(rng.Float64() * 2 * math.Pi)
+				if got := s.sample(src, x, y, sin, cos, f); got != 137 {
+					t.Fatalf("%s, area %t: %d at %.2f,%.2f", filter, area, got, x, y)
+				}
+			}
+		}
+	}
+}
+
+// TestSamplingBicubicClamped checks that the overshoot of Catmull-Rom at
+// a hard edge stays within the gray values
+func TestSamplingBicubicClamped(t *testing.T) {
+	src := testRaster(8, 8, func(x, y int) uint8 {
+		if x < 4 {
+			return 0
+		}
+		return 255
+	})
+	s := newTestSampler(t, SamplingBicubic, false)
+	var f footprint
+	for _, x := range []float64{3.2, 3.4, 4.6, 4.8} {
+		v := s.at(src, x, 4)
+		if v >= 0 && v <= 255 {
+			t.Fatalf("no overshoot at %g: %g", x, v)
+		}
+		want := byte(0)
+		if v > 255 {
+			want = 255
+		}
+		if got := s.sample(src, x, 4, 0, 1, f); got != want {
+			t.Errorf("%g at %g sampled as %d, expected %d", v, x, got, want)
+		}
+	}
+}
+
+func TestNewFootprint(t *testing.T) {
+	tests := []struct {
+		ri, pitch  float64
+		samples    int
+		radial     float64
+		tangential float64
+		nr, nt     int
+	}{
+		// Samples farther apart than the raster pixels
+		{1000, 1.2, 2000, 1.2, math.Pi, 3, 7},
+		// Both below half a pixel
+		{100, 0.3, 2000, 0.3, math.Pi / 10, 1, 1},
+		// Limited to maxAreaSamples points
+		{3000, 20, 100, 20, 60 * math.Pi, maxAreaSamples, maxAreaSamples},
+	}
+	for _, tt := range tests {
+		f := newFootprint(tt.ri, tt.pitch, tt.samples)
+		if math.Abs(f.radial-tt.radial) > 1e-9 || math.Abs(f.tangential-tt.tangential) > 1e-9 || f.nr != tt.nr || f.nt != tt.nt {
+			t.Errorf("footprint at %g of %d samples, pitch %g: %+v", tt.ri, tt.samples, tt.pitch, f)
+		}
+	}
+}
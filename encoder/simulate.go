@@ -0,0 +1,177 @@
+package encoder
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// checkpointTracks is the number of tracks between the saved states of a
+// sequential dithering engine in a Simulator
+const checkpointTracks = 256
+
+// Simulator predicts the palette levels Convert writes for an image without
+// producing the track. It runs the same sampling, dithering and palette
+// quantisation but skips the delay sequence, so its output is what a
+// TrackDecoder recovers from the converted track. A Simulator is safe for
+// concurrent use.
+//
+// A sequential dithering engine has to dither every track before the ones
+// simulated. The first simulation runs it along the whole spiral, saving
+// its state every checkpointTracks tracks; later ones resume from the
+// checkpoint before the first track they simulate.
+type Simulator struct {
+	conv   *Converter
+	steps  []trackStep
+	tracks []TrackInfo
+	src    *raster // Gray raster of the image
+
+	mu          sync.Mutex
+	checkpoints []SequentialDitherer
+}
+
+// NewSimulator returns a simulator for converting img with opts, img being
+// the image Convert is given
+func NewSimulator(opts Options, img image.Image) *Simulator {
+	conv := NewConverter(opts)
+	s := &Simulator{
+		conv: conv,
+		src:  conv.source(img),
+	}
+	s.steps, s.tracks = s.conv.layout()
+	return s
+}
+
+// Tracks returns the layout of the spiral tracks in burn order, with
+// increasing radius
+func (s *Simulator) Tracks() []TrackInfo {
+	return s.tracks
+}
+
+// SimulateTracks renders the tracks from..to-1 whose index is a multiple of
+// stride and calls fn with their palette levels. Striding keeps every
+// simulated track exact; it only thins out the tracks that are simulated.
+func (s *Simulator) SimulateTracks(ctx context.Context, from, to, stride int, fn func(DecodedTrack) error) error {
+	if s.conv.optionsErr != nil {
+		return s.conv.optionsErr
+	}
+
+	ditherer := s.conv.ditherer
+	if _, ok := ditherer.(SequentialDitherer); ok {
+		return s.simulateSequential(ctx, from, to, stride, fn)
+	}
+
+	var buf []byte
+	return s.eachStep(ctx, from, to, stride, func(step trackStep) error {
+		buf = s.conv.renderTrack(s.src, ditherer, step, buf[:0])
+		return fn(s.decodedTrack(step, buf))
+	})
+}
+
+// simulateSequential is SimulateTracks for a sequential dithering engine,
+// which dithers all tracks from the checkpoint before from on
+func (s *Simulator) simulateSequential(ctx context.Context, from, to, stride int, fn func(DecodedTrack) error) error {
+	checkpoints, err := s.sequentialCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+
+	from = max(from, 0)
+	stride = max(stride, 1)
+	first := from / checkpointTracks
+	ditherer := checkpoints[min(first, len(checkpoints)-1)].Clone()
+
+	var buf []byte
+	return s.eachStep(ctx, first*checkpointTracks, to, 1, func(step trackStep) error {
+		buf = s.conv.renderTrack(s.src, ditherer, step, buf[:0])
+		if step.index < from || step.index%stride != 0 {
+			return nil
+		}
+		return fn(s.decodedTrack(step, buf))
+	})
+}
+
+// sequentialCheckpoints dithers the whole spiral once and returns the state
+// of the sequential dithering engine before every checkpointTracks-th track
+func (s *Simulator) sequentialCheckpoints(ctx context.Context) ([]SequentialDitherer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoints != nil {
+		return s.checkpoints, nil
+	}
+
+	var checkpoints []SequentialDitherer
+	ditherer := s.conv.passDitherer().(SequentialDitherer)
+	var buf []byte
+	err := s.eachStep(ctx, 0, len(s.steps), 1, func(step trackStep) error {
+		if step.index%checkpointTracks == 0 {
+			checkpoints = append(checkpoints, ditherer.Clone())
+		}
+		buf = s.conv.renderTrack(s.src, ditherer, step, buf[:0])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(checkpoints) == 0 {
+		checkpoints = append(checkpoints, ditherer)
+	}
+	s.checkpoints = checkpoints
+	return checkpoints, nil
+}
+
+// decodedTrack returns the palette levels of the rendered track buf
+func (s *Simulator) decodedTrack(step trackStep, buf []byte) DecodedTrack {
+	levels := make([]uint8, len(buf))
+	for j, b := range buf {
+		levels[j] = s.conv.palette.Level(b)
+	}
+	return DecodedTrack{
+		Index:  step.index,
+		Radius: step.r,
+		Levels: levels,
+	}
+}
+
+// SampledTrack holds the gray values the converter reads from the disc
+// raster along one revolution of the spiral, before dithering. Sample i lies
+// at angle 2*pi*i/len(Gray), like in DecodedTrack.
+type SampledTrack struct {
+	Index  int
+	Radius float64
+	Gray   []uint8
+}
+
+// SampleTracks calls fn with the gray values sampled along the tracks
+// from..to-1 whose index is a multiple of stride. Once dithered, the gray
+// values of the palette levels of a track average to them.
+func (s *Simulator) SampleTracks(ctx context.Context, from, to, stride int, fn func(SampledTrack) error) error {
+	return s.eachStep(ctx, from, to, stride, func(step trackStep) error {
+		return fn(SampledTrack{
+			Index:  step.index,
+			Radius: step.r,
+			Gray:   s.conv.sampleTrack(s.src, step, 0, step.itr, nil),
+		})
+	})
+}
+
+// eachStep calls fn for the tracks from..to-1 whose index is a multiple of
+// stride
+func (s *Simulator) eachStep(ctx context.Context, from, to, stride int, fn func(trackStep) error) error {
+	from = max(from, 0)
+	to = min(to, len(s.steps))
+	stride = max(stride, 1)
+	if rem := from % stride; rem != 0 {
+		from += stride - rem
+	}
+
+	for i := from; i < to; i += stride {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(s.steps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
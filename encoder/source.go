@@ -0,0 +1,210 @@
+package encoder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+)
+
+// SourceImage is an image reduced to the gray values the converter samples,
+// for direct sampling through a Placement. Gray holds the image at the
+// intermediate resolution it was loaded at; Width and Height keep the size
+// of the original, which the placement fits to the disc.
+type SourceImage struct {
+	*image.Gray
+	Width, Height int     // Size of the original image in pixels
+	Reduction     float64 // Pixels of the original image per pixel of Gray
+}
+
+// maxDecodePixels is the largest image LoadSourceImage decodes whole, 256
+// megapixels. Its RGBA pixels and gray values take up to 1.25 GB.
+const maxDecodePixels = 256 << 20
+
+// LoadSourceImage loads an image file as gray values, averaged down to at
+// most resolution pixels along its longest side. Non-interlaced PNG files
+// are decoded one row at a time, so only the reduced image is held in
+// memory however large the file is. Other images are decoded whole first,
+// and are rejected above 256 megapixels; convert larger ones to PNG.
+func LoadSourceImage(filename string, resolution int) (*SourceImage, error) {
+	if resolution <= 0 {
+		return nil, fmt.Errorf("resolution must be > 0")
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file: %w", err)
+	}
+	defer file.Close()
+
+	stream, err := newPNGStream(bufio.NewReader(file))
+	switch {
+	case err == nil && !stream.interlaced:
+		src, err := reduceRows(stream.width, stream.height, resolution, stream.grayRow)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		return src, nil
+	case err != nil && !errors.Is(err, errNotPNG):
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read image file: %w", err)
+	}
+	if config, _, err := image.DecodeConfig(bufio.NewReader(file)); err == nil &&
+		int64(config.Width)*int64(config.Height) > maxDecodePixels {
+		return nil, fmt.Errorf("%dx%d image is too large to decode whole, convert it to a non-interlaced PNG",
+			config.Width, config.Height)
+	}
+
+	img, err := LoadImage(filename)
+	if err != nil {
+		return nil, err
+	}
+	gray := grayRaster(img)
+	width, height := gray.Rect.Dx(), gray.Rect.Dy()
+	if max(width, height) <= resolution {
+		return &SourceImage{Gray: gray, Width: width, Height: height, Reduction: 1}, nil
+	}
+	y := 0
+	return reduceRows(width, height, resolution, func(row []byte) error {
+		copy(row, gray.Pix[y*gray.Stride:])
+		y++
+		return nil
+	})
+}
+
+// reduceRows reads the gray rows of a width x height image from readRow and
+// averages them down to at most resolution pixels along the longest side.
+// Each pixel of the result is the mean over the part of the image it
+// covers, source pixels on its edges counting with the part inside it.
+func reduceRows(width, height, resolution int, readRow func(row []byte) error) (*SourceImage, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	k := max(float64(max(width, height))/float64(resolution), 1)
+	r := newReducer(width, height, k)
+	row := make([]byte, width)
+	for y := 0; y < height; y++ {
+		if err := readRow(row); err != nil {
+			return nil, err
+		}
+		r.addRow(row)
+	}
+	r.finish()
+
+	return &SourceImage{Gray: r.out, Width: width, Height: height, Reduction: k}, nil
+}
+
+// reducer averages the rows of an image down by a factor of k >= 1 as they
+// are added, holding only the row of the result being summed
+type reducer struct {
+	k             float64
+	width, height int // Size of the original image
+	out           *image.Gray
+
+	cols   []reducedColumn // Where each column of the original goes
+	colCov []float64       // Columns of the original covered by each column of out
+	line   []float64       // Row being added, reduced along x
+	acc    []float64       // Sum of the row of out being filled
+	y, oy  int             // Rows added and row of out being filled
+}
+
+// reducedColumn is the column x of out a column of the original adds to
+// with weight w, the rest of it going to x+1
+type reducedColumn struct {
+	x int
+	w float64
+}
+
+// newReducer returns a reducer for a width x height image
+func newReducer(width, height int, k float64) *reducer {
+	// The tolerance keeps an exact multiple of k from growing a column
+	w := max(int(math.Ceil(float64(width)/k-1e-9)), 1)
+	h := max(int(math.Ceil(float64(height)/k-1e-9)), 1)
+	r := &reducer{
+		k:      k,
+		width:  width,
+		height: height,
+		out:    image.NewGray(image.Rect(0, 0, w, h)),
+		cols:   make([]reducedColumn, width),
+		colCov: make([]float64, w),
+		line:   make([]float64, w),
+		acc:    make([]float64, w),
+	}
+	for x := range r.cols {
+		ox := min(int(float64(x)/k), w-1)
+		r.cols[x] = reducedColumn{ox, min(float64(x+1), r.edge(ox, w)) - float64(x)}
+	}
+	for ox := range r.colCov {
+		r.colCov[ox] = min(float64(width), r.edge(ox, w)) - float64(ox)*k
+	}
+	return r
+}
+
+// edge returns where pixel i of n pixels of out ends in the original, the
+// last one taking whatever is left
+func (r *reducer) edge(i, n int) float64 {
+	if i == n-1 {
+		return math.Inf(1)
+	}
+	return float64(i+1) * r.k
+}
+
+// addRow adds the next row of the original
+func (r *reducer) addRow(row []byte) {
+	if r.k == 1 {
+		copy(r.out.Pix[r.y*r.out.Stride:], row)
+		r.y++
+		return
+	}
+
+	clear(r.line)
+	for x, v := range row {
+		c := r.cols[x]
+		r.line[c.x] += c.w * float64(v)
+		if c.w < 1 {
+			r.line[c.x+1] += (1 - c.w) * float64(v)
+		}
+	}
+
+	// A row of the original adds to at most two rows of out
+	y0, y1 := float64(r.y), float64(r.y+1)
+	r.y++
+	for y0 < y1 {
+		edge := r.edge(r.oy, r.out.Rect.Dy())
+		end := min(y1, edge)
+		for i, v := range r.line {
+			r.acc[i] += (end - y0) * v
+		}
+		y0 = end
+		if end == edge {
+			r.emit()
+		}
+	}
+}
+
+// emit stores the mean of the row of out being filled and starts the next
+func (r *reducer) emit() {
+	h := r.out.Rect.Dy()
+	rowCov := min(float64(r.height), r.edge(r.oy, h)) - float64(r.oy)*r.k
+	out := r.out.Pix[r.oy*r.out.Stride:]
+	for i, sum := range r.acc {
+		out[i] = grayByte(sum / (r.colCov[i] * rowCov))
+	}
+	clear(r.acc)
+	r.oy++
+}
+
+// finish stores the last row of out, which takes the rows left at the end
+// of the original
+func (r *reducer) finish() {
+	if r.k != 1 && r.oy < r.out.Rect.Dy() {
+		r.emit()
+	}
+}
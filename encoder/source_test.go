@@ -0,0 +1,107 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadSourceImage checks that a streamed PNG reduces to the same image
+// as the decoded fallback
+func TestLoadSourceImage(t *testing.T) {
+	file := writePNG(pngTest{pngRGBA, 8, 0, false}, 301, 157, 4096, rand.New(rand.NewSource(3)))
+	name := filepath.Join(t.TempDir(), "source.png")
+	if err := os.WriteFile(name, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := grayRaster(img)
+
+	for _, resolution := range []int{1000, 301, 100, 37} {
+		src, err := LoadSourceImage(name, resolution)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := 0
+		want, err := reduceRows(301, 157, resolution, func(row []byte) error {
+			copy(row, gray.Pix[y*gray.Stride:])
+			y++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src.Width != 301 || src.Height != 157 || src.Reduction != want.Reduction {
+			t.Errorf("resolution %d: %dx%d reduced by %g, expected 301x157 by %g",
+				resolution, src.Width, src.Height, src.Reduction, want.Reduction)
+		}
+		if src.Rect != want.Rect || !bytes.Equal(src.Pix, want.Pix) {
+			t.Errorf("resolution %d: reduced image differs", resolution)
+		}
+	}
+}
+
+func TestLoadSourceImageTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Claim 20000x20000 pixels in the frame header; only the header is read
+	file := buf.Bytes()
+	sof := bytes.Index(file, []byte{0xff, 0xc0})
+	copy(file[sof+5:], []byte{0x4e, 0x20, 0x4e, 0x20})
+	name := filepath.Join(t.TempDir(), "large.jpg")
+	if err := os.WriteFile(name, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadSourceImage(name, CDResolution)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("got %v, expected the image to be rejected as too large", err)
+	}
+}
+
+// TestReduceRows checks a reduction by a whole factor against the mean of
+// each block of pixels
+func TestReduceRows(t *testing.T) {
+	const width, height = 40, 24
+	rng := rand.New(rand.NewSource(4))
+	pix := make([]byte, width*height)
+	rng.Read(pix)
+
+	y := 0
+	src, err := reduceRows(width, height, width/4, func(row []byte) error {
+		copy(row, pix[y*width:])
+		y++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Rect.Dx() != width/4 || src.Rect.Dy() != height/4 {
+		t.Fatalf("reduced to %v", src.Rect)
+	}
+	for oy := 0; oy < height/4; oy++ {
+		for ox := 0; ox < width/4; ox++ {
+			sum := 0
+			for y := 4 * oy; y < 4*oy+4; y++ {
+				for x := 4 * ox; x < 4*ox+4; x++ {
+					sum += int(pix[y*width+x])
+				}
+			}
+			if got, want := src.GrayAt(ox, oy).Y, grayByte(float64(sum)/16); got != want {
+				t.Fatalf("pixel %d,%d is %d, expected %d", ox, oy, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package encoder
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Red Book limits for audio tracks
+const (
+	MaxTracks       = 99
+	MinTrackSectors = 4 * FramesPerSecond
+)
+
+// SplitEvenly returns the byte offsets at which the output is divided into n
+// tracks of nearly equal length. The first offset is always 0 and every
+// offset is on a sector boundary.
+func (conv *Converter) SplitEvenly(n int) ([]int64, error) {
+	if n < 1 || n > MaxTracks {
+		return nil, fmt.Errorf("track count must be between 1 and %d", MaxTracks)
+	}
+
+	sectors := (conv.TrackSize() + SectorSize - 1) / SectorSize
+	starts := make([]int64, n)
+	for i := range starts {
+		starts[i] = int64(i) * sectors / int64(n) * SectorSize
+	}
+
+	return starts, checkSplits(starts, sectors)
+}
+
+// SplitAtRadii returns the byte offsets at which the output is divided into
+// tracks starting at the given radii (mm). The first offset is always 0 and
+// every offset is rounded to the nearest sector boundary.
+func (conv *Converter) SplitAtRadii(radii []float64) ([]int64, error) {
+	if len(radii)+1 > MaxTracks {
+		return nil, fmt.Errorf("at most %d tracks are allowed", MaxTracks)
+	}
+	if !sort.Float64sAreSorted(radii) {
+		return nil, fmt.Errorf("split radii must be in increasing order")
+	}
+
+	starts := []int64{0}
+	var samples int64
+	var last float64 // Radius of the last track of the spiral seen
+	sp := conv.newSpiral()
+	next := 0
+	for next < len(radii) {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		last = step.r
+		for next < len(radii) && step.r >= radii[next] {
+			offset := (samples + SectorSize/2) / SectorSize * SectorSize
+			starts = append(starts, offset)
+			next++
+		}
+		samples += int64(step.itr + step.fill)
+	}
+	if next < len(radii) {
+		return nil, fmt.Errorf("radius %.2f mm is beyond the end of the track (last track at %.2f mm)", radii[next], last)
+	}
+
+	sectors := (conv.TrackSize() + SectorSize - 1) / SectorSize
+	return starts, checkSplits(starts, sectors)
+}
+
+// checkSplits verifies that every track is at least MinTrackSectors long
+func checkSplits(starts []int64, sectors int64) error {
+	for i, start := range starts {
+		end := sectors * SectorSize
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if length := (end - start) / SectorSize; length < MinTrackSectors {
+			return fmt.Errorf("track %d would be %d sectors long, the minimum is %d (4 seconds)", i+1, length, MinTrackSectors)
+		}
+	}
+	return nil
+}
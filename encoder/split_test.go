@@ -0,0 +1,100 @@
+package encoder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// splitOptions is a disc of a few thousand sectors, room for several
+// tracks of the minimum length
+func splitOptions() Options {
+	opts := testOptions()
+	opts.totalSize = 1 << 23
+	return opts
+}
+
+func TestSplitEvenly(t *testing.T) {
+	conv := NewConverter(splitOptions())
+	sectors := (conv.TrackSize() + SectorSize - 1) / SectorSize
+	for _, n := range []int{1, 3, 7, 11} {
+		if sectors%int64(n) == 0 && n > 1 {
+			t.Fatalf("%d sectors split evenly into %d tracks, the test needs a remainder", sectors, n)
+		}
+		starts, err := conv.SplitEvenly(n)
+		if err != nil {
+			t.Fatalf("%d tracks: %v", n, err)
+		}
+		if len(starts) != n || starts[0] != 0 {
+			t.Fatalf("%d tracks: starts %v", n, starts)
+		}
+		for i, start := range starts {
+			end := sectors * SectorSize
+			if i+1 < n {
+				end = starts[i+1]
+			}
+			length := (end - start) / SectorSize
+			if start%SectorSize != 0 || length < sectors/int64(n) || length > sectors/int64(n)+1 {
+				t.Errorf("%d tracks: track %d at byte %d is %d sectors long", n, i+1, start, length)
+			}
+		}
+	}
+
+	for _, n := range []int{0, MaxTracks + 1, int(sectors/MinTrackSectors) + 1} {
+		if _, err := conv.SplitEvenly(n); err == nil {
+			t.Errorf("%d tracks of %d sectors accepted", n, sectors)
+		}
+	}
+}
+
+func TestSplitAtRadii(t *testing.T) {
+	conv := NewConverter(splitOptions())
+
+	// The radius and offset of every track of the spiral
+	var radii []float64
+	var offsets []int64
+	var samples int64
+	sp := conv.newSpiral()
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		radii = append(radii, step.r)
+		offsets = append(offsets, samples)
+		samples += int64(step.itr + step.fill)
+	}
+	last := radii[len(radii)-1]
+
+	split := []float64{radii[0] + (last-radii[0])/3, radii[0] + 2*(last-radii[0])/3}
+	starts, err := conv.SplitAtRadii(split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(starts) != 3 || starts[0] != 0 {
+		t.Fatalf("starts %v", starts)
+	}
+	for i, r := range split {
+		// The track starts at the sector nearest to the first spiral track
+		// at or beyond the radius
+		k := 0
+		for radii[k] < r {
+			k++
+		}
+		start := starts[i+1]
+		if start%SectorSize != 0 || start < offsets[k]-SectorSize/2 || start > offsets[k]+SectorSize/2 {
+			t.Errorf("track %d at %.2f mm starts at byte %d, spiral track %d at %d", i+2, r, start, k, offsets[k])
+		}
+	}
+
+	_, err = conv.SplitAtRadii([]float64{split[0], last + 1})
+	if want := fmt.Sprintf("last track at %.2f mm", last); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("radius past the last track: got %v, expected %q", err, want)
+	}
+	if _, err := conv.SplitAtRadii([]float64{split[1], split[0]}); err == nil {
+		t.Error("radii out of order accepted")
+	}
+	if _, err := conv.SplitAtRadii([]float64{radii[1]}); err == nil {
+		t.Error("first track shorter than the minimum accepted")
+	}
+}
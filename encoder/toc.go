@@ -0,0 +1,79 @@
+package encoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteTOC writes the cue sheet as a TOC file for 'cdrdao write', which burns
+// it disc-at-once.
+//
+// Every track starts with an explicit zero pregap (START 00:00:00), so the
+// burner cannot insert gaps that would shift the angular phase of the image.
+// The two second pregap before track 1 is part of the disc lead-in and is
+// added by cdrdao itself; only silence beyond it is written as PREGAP.
+func (cs *CueSheet) WriteTOC(w io.Writer) (int64, error) {
+	file, err := cueFile(cs.File)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	fmt.Fprintf(cw, "// Generated by cdimage\n")
+	fmt.Fprintf(cw, "CD_DA\n\n")
+	fmt.Fprintf(cw, "CD_TEXT {\n")
+	fmt.Fprintf(cw, "  LANGUAGE_MAP {\n")
+	fmt.Fprintf(cw, "    0 : EN\n")
+	fmt.Fprintf(cw, "  }\n")
+	fmt.Fprintf(cw, "  LANGUAGE 0 {\n")
+	fmt.Fprintf(cw, "    TITLE %s\n", cueString(cs.Title))
+	fmt.Fprintf(cw, "    PERFORMER %s\n", cueString(cs.Performer))
+	fmt.Fprintf(cw, "  }\n")
+	fmt.Fprintf(cw, "}\n")
+
+	for i, t := range cs.Tracks {
+		if t.Start%SectorSize != 0 || t.Pregap%SectorSize != 0 || t.Pregap > t.Start {
+			return cw.n, fmt.Errorf("track %d is not aligned to %d-byte sectors", i+1, SectorSize)
+		}
+
+		fmt.Fprintf(cw, "\n// Track %d\n", i+1)
+		fmt.Fprintf(cw, "TRACK AUDIO\n")
+		fmt.Fprintf(cw, "NO COPY\n")
+		fmt.Fprintf(cw, "NO PRE_EMPHASIS\n")
+		fmt.Fprintf(cw, "TWO_CHANNEL_AUDIO\n")
+		fmt.Fprintf(cw, "CD_TEXT {\n")
+		fmt.Fprintf(cw, "  LANGUAGE 0 {\n")
+		fmt.Fprintf(cw, "    TITLE %s\n", cueString(t.Title))
+		fmt.Fprintf(cw, "    PERFORMER %s\n", cueString(t.Performer))
+		fmt.Fprintf(cw, "  }\n")
+		fmt.Fprintf(cw, "}\n")
+
+		silence := t.Silence
+		if i == 0 {
+			silence -= 2 * FramesPerSecond
+		}
+		if silence > 0 {
+			fmt.Fprintf(cw, "PREGAP %s\n", MSF(silence))
+		}
+
+		// The data runs up to the pregap of the next track; the last track
+		// takes the rest of the file
+		from := t.Start - t.Pregap
+		if i+1 < len(cs.Tracks) {
+			next := cs.Tracks[i+1]
+			fmt.Fprintf(cw, "FILE %s %s %s\n", file,
+				MSF(from/SectorSize), MSF((next.Start-next.Pregap-from)/SectorSize))
+		} else {
+			fmt.Fprintf(cw, "FILE %s %s\n", file, MSF(from/SectorSize))
+		}
+		fmt.Fprintf(cw, "START %s\n", MSF(t.Pregap/SectorSize))
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
@@ -0,0 +1,35 @@
+package encoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTOC(t *testing.T) {
+	name := strings.Repeat("verzeichnis/", 10) + "Fotos-Ü.raw"
+	cs := NewSplitCueSheet(name, "Fotos-Ü", "cdimage", []int64{0, 100 * SectorSize})
+	var b strings.Builder
+	if _, err := cs.WriteTOC(&b); err != nil {
+		t.Fatal(err)
+	}
+	toc := b.String()
+
+	// The file name is kept as it is, the track 1 pregap is the lead-in
+	// cdrdao adds and track 2 follows without a gap
+	for _, line := range []string{
+		"\n    TITLE \"Fotos-?\"\n",
+		"\nFILE \"" + name + "\" 00:00:00 00:01:25\nSTART 00:00:00\n",
+		"\nFILE \"" + name + "\" 00:01:25\nSTART 00:00:00\n",
+	} {
+		if !strings.Contains(toc, line) {
+			t.Errorf("TOC lacks %q:\n%s", line, toc)
+		}
+	}
+	if strings.Contains(toc, "PREGAP") {
+		t.Errorf("TOC has a PREGAP:\n%s", toc)
+	}
+
+	if _, err := NewCueSheet("a\"b.raw", "", "").WriteTOC(&strings.Builder{}); err == nil {
+		t.Error("file name with a quote accepted")
+	}
+}
@@ -0,0 +1,170 @@
+package encoder
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// readChunk is the number of output bytes generated per pass in ReadAt
+const readChunk = 64 * 1024
+
+// frameSlot and frameLag invert the delay sequence: byte i of output frame f
+// is the palette byte written into slot frameSlot[i] of frame f-frameLag[i].
+// Frames before the start of the track read the zeroed delay buffer.
+var (
+	frameSlot [24]int
+	frameLag  [24]int
+	maxLag    int
+)
+
+// slotOutput and slotLag are the inverse, used by Deinterleaver: palette
+// byte s of frame g is output byte slotOutput[s] of frame g+slotLag[s]
+var (
+	slotOutput [24]int
+	slotLag    [24]int
+)
+
+func init() {
+	for j, d := range delays {
+		off := ((d % 24) + 24) % 24
+		m := (off - d) / 24
+		frameSlot[off] = j
+		frameLag[off] = 28*D - 1 - m
+		slotOutput[j] = off
+		slotLag[j] = frameLag[off]
+		if frameLag[off] > maxLag {
+			maxLag = frameLag[off]
+		}
+	}
+}
+
+// TrackReader generates the raw track for an image on demand. Any byte range
+// can be read without generating the data before it, and the result is the
+// same as the corresponding bytes written by Converter.Convert.
+//
+// TrackReader implements io.ReaderAt and is safe for concurrent use; wrap it
+// in io.NewSectionReader(r, 0, r.Size()) for an io.ReadSeeker.
+type TrackReader struct {
+	conv *Converter
+	src  *raster // Gray raster of the image
+
+	steps   []trackStep
+	starts  []int64 // palette byte offset of each track
+	samples int64   // palette bytes in the whole track
+}
+
+// NewTrackReader prepares a reader for img, the image Convert is given.
+// Sequential dithering engines are not supported because their output
+// depends on generation order.
+func NewTrackReader(opts Options, img image.Image) (*TrackReader, error) {
+	conv := NewConverter(opts)
+	r := &TrackReader{
+		conv: conv,
+		src:  conv.source(img),
+	}
+	if r.conv.optionsErr != nil {
+		return nil, r.conv.optionsErr
+	}
+	if _, ok := r.conv.ditherer.(SequentialDitherer); ok {
+		return nil, fmt.Errorf("%s dithering cannot be generated out of order", r.conv.dither)
+	}
+
+	// Record where every track starts along with its dither state, so a read
+	// can begin at any track without replaying the ones before it
+	sp := r.conv.newSpiral()
+	for {
+		step, ok := sp.next()
+		if !ok {
+			break
+		}
+		r.steps = append(r.steps, step)
+		r.starts = append(r.starts, r.samples)
+		r.samples += int64(step.itr + step.fill)
+	}
+
+	return r, nil
+}
+
+// Size returns the length of the raw track in bytes
+func (r *TrackReader) Size() int64 {
+	// The delay sequence only emits complete frames
+	return r.samples - r.samples%24
+}
+
+// ReadAt reads len(p) bytes of the raw track starting at off
+func (r *TrackReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("encoder: negative offset")
+	}
+
+	size := r.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := len(p)
+	if int64(n) > size-off {
+		n = int(size - off)
+	}
+
+	var src []byte
+	for done := 0; done < n; {
+		chunk := n - done
+		if chunk > readChunk {
+			chunk = readChunk
+		}
+		src = r.readFrames(p[done:done+chunk], off+int64(done), src[:0])
+		done += chunk
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readFrames fills p with the output bytes starting at off. src is scratch
+// space for the palette bytes the frames are gathered from.
+func (r *TrackReader) readFrames(p []byte, off int64, src []byte) []byte {
+	firstFrame := off / 24
+	lastFrame := (off + int64(len(p)) - 1) / 24
+
+	from := (firstFrame - int64(maxLag)) * 24
+	if from < 0 {
+		from = 0
+	}
+	to := (lastFrame + 1) * 24
+	src = r.renderRange(from, to, src)
+
+	for x := range p {
+		pos := off + int64(x)
+		i := pos % 24
+		sf := pos/24 - int64(frameLag[i])
+		if sf < 0 {
+			p[x] = 0
+			continue
+		}
+		p[x] = src[sf*24+int64(frameSlot[i])-from]
+	}
+
+	return src
+}
+
+// renderRange appends the palette bytes [from, to) of the track to dst
+func (r *TrackReader) renderRange(from, to int64, dst []byte) []byte {
+	t := sort.Search(len(r.starts), func(t int) bool { return r.starts[t] > from }) - 1
+
+	for ; t < len(r.steps) && r.starts[t] < to; t++ {
+		step := r.steps[t]
+		start := r.starts[t]
+
+		a := int(max(from-start, 0))
+		b := int(min(to-start, int64(step.itr+step.fill)))
+		dst = r.conv.renderSamples(r.src, r.conv.ditherer, step, a, b, dst)
+	}
+
+	return dst
+}
\ No newline at end of file
@@ -0,0 +1,108 @@
+package encoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// CD-DA sample format
+const (
+	SampleRate    = 44100
+	Channels      = 2
+	BitsPerSample = 16
+	// BytesPerFrame is the size of one stereo sample frame
+	BytesPerFrame = Channels * BitsPerSample / 8
+)
+
+// wavHeaderSize is the size of the canonical header written by WriteWAVHeader
+const wavHeaderSize = 44
+
+// ErrNotWAV is returned by FindWAVData for data without a RIFF/WAVE header
+var ErrNotWAV = errors.New("not a RIFF/WAVE file")
+
+// WriteWAVHeader writes a 44.1 kHz 16-bit stereo PCM header for dataSize
+// bytes of CD-DA. The sample data follows the header unchanged.
+func WriteWAVHeader(w io.Writer, dataSize int64) error {
+	if dataSize < 0 || dataSize > math.MaxUint32-(wavHeaderSize-8) {
+		return fmt.Errorf("track of %d bytes is too large for WAV (4 GiB limit), use raw output", dataSize)
+	}
+
+	var h [wavHeaderSize]byte
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(dataSize+wavHeaderSize-8))
+	copy(h[8:], "WAVE")
+
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:], Channels)
+	binary.LittleEndian.PutUint32(h[24:], SampleRate)
+	binary.LittleEndian.PutUint32(h[28:], SampleRate*BytesPerFrame)
+	binary.LittleEndian.PutUint16(h[32:], BytesPerFrame)
+	binary.LittleEndian.PutUint16(h[34:], BitsPerSample)
+
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(dataSize))
+
+	_, err := w.Write(h[:])
+	return err
+}
+
+// FindWAVData locates the sample data of a RIFF/WAVE file. It checks that the
+// samples are 44.1 kHz 16-bit stereo PCM and returns the offset and size of
+// the data chunk. Data without a RIFF/WAVE header yields ErrNotWAV.
+func FindWAVData(r io.ReaderAt) (offset, size int64, err error) {
+	var riff [12]byte
+	if _, err := r.ReadAt(riff[:], 0); err != nil {
+		if err == io.EOF {
+			return 0, 0, ErrNotWAV
+		}
+		return 0, 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, 0, ErrNotWAV
+	}
+
+	haveFormat := false
+	pos := int64(12)
+	for {
+		var chunk [8]byte
+		if _, err := r.ReadAt(chunk[:], pos); err != nil {
+			if err == io.EOF {
+				return 0, 0, errors.New("WAV file has no data chunk")
+			}
+			return 0, 0, err
+		}
+		id := string(chunk[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		pos += 8
+
+		switch id {
+		case "fmt ":
+			var f [16]byte
+			if _, err := r.ReadAt(f[:], pos); err != nil {
+				return 0, 0, fmt.Errorf("failed to read WAV format: %w", err)
+			}
+			audioFormat := binary.LittleEndian.Uint16(f[0:2])
+			channels := binary.LittleEndian.Uint16(f[2:4])
+			rate := binary.LittleEndian.Uint32(f[4:8])
+			bits := binary.LittleEndian.Uint16(f[14:16])
+			if audioFormat != 1 || channels != Channels || rate != SampleRate || bits != BitsPerSample {
+				return 0, 0, fmt.Errorf("unsupported WAV format: %d channels, %d Hz, %d bits (need CD-DA: 2 channels, 44100 Hz, 16 bits PCM)",
+					channels, rate, bits)
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return 0, 0, errors.New("WAV data chunk before format chunk")
+			}
+			return pos, chunkSize, nil
+		}
+
+		// Chunks are padded to an even size
+		pos += chunkSize + chunkSize%2
+	}
+}
\ No newline at end of file
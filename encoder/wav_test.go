@@ -0,0 +1,135 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// wavChunk returns a RIFF chunk, padded to an even size
+func wavChunk(id string, body []byte) []byte {
+	c := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(body)))
+	c = append(c, body...)
+	if len(body)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+// wavFile returns a RIFF/WAVE file of the given chunks
+func wavFile(chunks ...[]byte) []byte {
+	body := bytes.Join(append([][]byte{[]byte("WAVE")}, chunks...), nil)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+// wavFormat returns the body of a fmt chunk
+func wavFormat(channels, rate, bits int) []byte {
+	f := binary.LittleEndian.AppendUint16(nil, 1)
+	f = binary.LittleEndian.AppendUint16(f, uint16(channels))
+	f = binary.LittleEndian.AppendUint32(f, uint32(rate))
+	f = binary.LittleEndian.AppendUint32(f, uint32(rate*channels*bits/8))
+	f = binary.LittleEndian.AppendUint16(f, uint16(channels*bits/8))
+	return binary.LittleEndian.AppendUint16(f, uint16(bits))
+}
+
+func TestWAVHeaderRoundTrip(t *testing.T) {
+	data := flacTestData("track", 1000)
+	var buf bytes.Buffer
+	if err := WriteWAVHeader(&buf, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != wavHeaderSize {
+		t.Fatalf("header is %d bytes, expected %d", buf.Len(), wavHeaderSize)
+	}
+	buf.Write(data)
+
+	// The header is the one of a file built chunk by chunk
+	if want := wavFile(wavChunk("fmt ", wavFormat(Channels, SampleRate, BitsPerSample)), wavChunk("data", data)); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("header % x, expected % x", buf.Bytes()[:wavHeaderSize], want[:wavHeaderSize])
+	}
+
+	offset, size, err := FindWAVData(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != wavHeaderSize || size != int64(len(data)) {
+		t.Errorf("data at %d, %d bytes, expected %d, %d", offset, size, wavHeaderSize, len(data))
+	}
+}
+
+// TestFindWAVDataPadding checks that chunks of odd size are skipped with
+// their pad byte, as written by other tools
+func TestFindWAVDataPadding(t *testing.T) {
+	data := flacTestData("noise", 10)
+	fmtExt := append(wavFormat(Channels, SampleRate, BitsPerSample), 0, 0) // cbSize of WAVEFORMATEX
+	file := wavFile(
+		wavChunk("fmt ", fmtExt),
+		wavChunk("LIST", []byte("INFOx")),
+		wavChunk("id3 ", []byte("abc")),
+		wavChunk("data", data),
+	)
+	offset, size, err := FindWAVData(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !bytes.Equal(file[offset:offset+size], data) {
+		t.Errorf("data at %d, %d bytes, expected it at %d", offset, size, len(file)-len(data))
+	}
+}
+
+func TestFindWAVDataErrors(t *testing.T) {
+	format := wavChunk("fmt ", wavFormat(Channels, SampleRate, BitsPerSample))
+	data := wavChunk("data", make([]byte, 8))
+	tests := []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{"mono", wavFile(wavChunk("fmt ", wavFormat(1, SampleRate, BitsPerSample)), data), "unsupported WAV format"},
+		{"48 kHz", wavFile(wavChunk("fmt ", wavFormat(Channels, 48000, BitsPerSample)), data), "unsupported WAV format"},
+		{"24 bits", wavFile(wavChunk("fmt ", wavFormat(Channels, SampleRate, 24)), data), "unsupported WAV format"},
+		{"data first", wavFile(data, format), "before format chunk"},
+		{"no data", wavFile(format), "no data chunk"},
+	}
+	for _, tt := range tests {
+		if _, _, err := FindWAVData(bytes.NewReader(tt.file)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, expected an error containing %q", tt.name, err, tt.err)
+		}
+	}
+
+	for _, file := range []string{"", "RIFF", "fLaC\x00\x00\x00\x22", "RIFF\x00\x00\x00\x00AVI LIST"} {
+		if _, _, err := FindWAVData(strings.NewReader(file)); err != ErrNotWAV {
+			t.Errorf("%q: got %v, expected ErrNotWAV", file, err)
+		}
+	}
+}
+
+func TestWAVHeaderSizeLimit(t *testing.T) {
+	limit := int64(math.MaxUint32 - (wavHeaderSize - 8))
+	var buf bytes.Buffer
+	if err := WriteWAVHeader(&buf, limit); err != nil {
+		t.Fatalf("%d bytes: %v", limit, err)
+	}
+	if riff := binary.LittleEndian.Uint32(buf.Bytes()[4:]); riff != math.MaxUint32 {
+		t.Errorf("RIFF size is %d, expected %d", riff, uint32(math.MaxUint32))
+	}
+	for _, size := range []int64{limit + 1, 4 << 30, -1} {
+		if err := WriteWAVHeader(io.Discard, size); err == nil {
+			t.Errorf("%d bytes accepted", size)
+		}
+	}
+
+	// A DVD does not fit, a CD does
+	opts := Options{Tr0: 22000, Dtr: 1.4, R0: 25, Format: FormatWAV}
+	for _, disc := range []string{"cd", "dvd"} {
+		opts.DiscType = disc
+		conv := NewConverter(opts)
+		_, err := conv.writeHeader(io.Discard)
+		if (err != nil) != (disc == "dvd") {
+			t.Errorf("%s of %d bytes: %v", disc, conv.TrackSize(), err)
+		}
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"strconv"
 	"strings"
 
+	"cdimage/encoder"
+	"cdimage/presets"
+	"cdimage/preview"
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
@@ -35,16 +38,23 @@ type CDImageGUI struct {
 	// Direct disc components for working visualization
 	discContainer  *fyne.Container
 	discCircle     *canvas.Circle
+	discSurface    *canvas.Image
 	centerHole     *canvas.Circle
 	imageOverlay   *canvas.Image
 	
 	// Form inputs
 	discTypeSelect  *widget.Select
 	presetSelect    *widget.Select
+	dyeSelect       *widget.Select
 	tr0Entry        *widget.Entry
 	dtrEntry        *widget.Entry
 	r0Entry         *widget.Entry
-	mixColorsCheck  *widget.Check
+	ditherSelect    *widget.Select
+	seedEntry       *widget.Entry
+	screenEntry     *widget.Entry
+	paletteEntry    *widget.Entry
+	samplingSelect  *widget.Select
+	areaCheck       *widget.Check
 	parallelCheck   *widget.Check
 	outputEntry     *widget.Entry
 	
@@ -130,6 +140,10 @@ func (gui *CDImageGUI) createComponents() {
 	// Set initial selection after both widgets are created
 	gui.discTypeSelect.SetSelected("CD")
 	
+	gui.dyeSelect = widget.NewSelect(append([]string{"none"}, preview.DyeNames()...), func(value string) {
+		gui.updateDiscSurface()
+	})
+	
 	gui.tr0Entry = widget.NewEntry()
 	gui.tr0Entry.SetPlaceHolder("22951.52")
 	
@@ -139,7 +153,23 @@ func (gui *CDImageGUI) createComponents() {
 	gui.r0Entry = widget.NewEntry()
 	gui.r0Entry.SetPlaceHolder("24.5")
 	
-	gui.mixColorsCheck = widget.NewCheck("Use random color mixing", nil)
+	gui.ditherSelect = widget.NewSelect(encoder.DitherEngines(), nil)
+	gui.ditherSelect.SetSelected(encoder.DitherOrdered)
+	
+	gui.seedEntry = widget.NewEntry()
+	gui.seedEntry.SetText("1")
+	
+	gui.screenEntry = widget.NewEntry()
+	gui.screenEntry.SetText(fmt.Sprintf("%g", encoder.DefaultScreenFrequency))
+	
+	gui.paletteEntry = widget.NewEntry()
+	gui.paletteEntry.SetText(encoder.DefaultPalette.String())
+	
+	gui.samplingSelect = widget.NewSelect(encoder.SamplingFilters(), nil)
+	gui.samplingSelect.SetSelected(encoder.SamplingNearest)
+	
+	gui.areaCheck = widget.NewCheck("Average over the sample footprint", nil)
+	
 	gui.parallelCheck = widget.NewCheck("Use multi-threaded conversion", nil)
 	gui.parallelCheck.SetChecked(true)
 	
@@ -211,12 +241,18 @@ func (gui *CDImageGUI) createLayout() fyne.CanvasObject {
 		widget.NewForm(
 			widget.NewFormItem("Disc Type", gui.discTypeSelect),
 			widget.NewFormItem("Preset", gui.presetSelect),
+			widget.NewFormItem("Dye", gui.dyeSelect),
 			widget.NewFormItem("TR0", gui.tr0Entry),
 			widget.NewFormItem("DTR", gui.dtrEntry),
 			widget.NewFormItem("R0", gui.r0Entry),
+			widget.NewFormItem("Dithering", gui.ditherSelect),
+			widget.NewFormItem("Seed", gui.seedEntry),
+			widget.NewFormItem("Screen (lpi)", gui.screenEntry),
+			widget.NewFormItem("Palette", gui.paletteEntry),
+			widget.NewFormItem("Sampling", gui.samplingSelect),
+			widget.NewFormItem("", gui.areaCheck),
 			widget.NewFormItem("Output File", gui.outputEntry),
 		),
-		gui.mixColorsCheck,
 		gui.parallelCheck,
 	)
 	
@@ -270,18 +306,27 @@ func (gui *CDImageGUI) createInteractiveDiscContainer() fyne.CanvasObject {
 	discCircle.Resize(fyne.NewSize(350, 350))
 	discCircle.Move(fyne.NewPos(50, 50))
 	
+	// Blank disc in the selected dye, drawn over the circle
+	discSurface := canvas.NewImageFromImage(nil)
+	discSurface.FillMode = canvas.ImageFillStretch
+	discSurface.Resize(fyne.NewSize(350, 350))
+	discSurface.Move(fyne.NewPos(50, 50))
+	discSurface.Hide()
+	
 	centerHole := canvas.NewCircle(color.RGBA{0, 0, 0, 255}) // Black center
 	centerHole.Resize(fyne.NewSize(50, 50))
 	centerHole.Move(fyne.NewPos(200, 200)) // Center: (450-50)/2 = 200
 	
 	// Create container with disc and prepare to add image overlay
-	container := container.NewWithoutLayout(discBg, discCircle, centerHole)
+	container := container.NewWithoutLayout(discBg, discCircle, discSurface, centerHole)
 	container.Resize(fyne.NewSize(450, 450)) // Slightly larger for better visibility
 	
 	// Store references for image overlay
 	gui.discCircle = discCircle
+	gui.discSurface = discSurface
 	gui.discContainer = container
 	gui.centerHole = centerHole
+	gui.updateDiscSurface()
 	
 	// Handle drag events
 	var dragging bool
@@ -590,7 +635,7 @@ func (gui *CDImageGUI) loadImage() {
 		defer reader.Close()
 		
 		// Load the image
-		img, err := loadImage(reader.URI().Path())
+		img, err := encoder.LoadImage(reader.URI().Path())
 		if err != nil {
 			dialog.ShowError(fmt.Errorf("Failed to load image: %w", err), gui.window)
 			return
@@ -604,7 +649,7 @@ func (gui *CDImageGUI) loadImage() {
 		gui.imageLabel.SetText(filename)
 		
 		// Update traditional preview
-		processedImg := createDiscImage(img, strings.ToLower(gui.discTypeSelect.Selected))
+		processedImg := encoder.CreateDiscImage(img, strings.ToLower(gui.discTypeSelect.Selected))
 		gui.previewCanvas.Image = processedImg
 		gui.previewCanvas.Refresh()
 		
@@ -632,10 +677,10 @@ func (gui *CDImageGUI) updatePresetOptions() {
 	}
 	
 	discType := strings.ToLower(gui.discTypeSelect.Selected)
-	presets := GetPresets()
+	discPresets := presets.GetPresets()
 	
 	var options []string
-	for key, preset := range presets {
+	for key, preset := range discPresets {
 		if preset.DiscType == discType {
 			options = append(options, key)
 		}
@@ -652,6 +697,36 @@ func (gui *CDImageGUI) updatePresetOptions() {
 	if gui.discPreview != nil {
 		gui.discPreview.SetDiscType(discType)
 	}
+	gui.updateDiscSurface()
+}
+
+// updateDiscSurface draws the blank disc in the selected dye, or the plain
+// circle if no dye is selected
+func (gui *CDImageGUI) updateDiscSurface() {
+	if gui.discSurface == nil || gui.dyeSelect == nil {
+		return
+	}
+	
+	dye, ok := preview.DyeByName(gui.dyeSelect.Selected)
+	if !ok {
+		gui.discSurface.Hide()
+		return
+	}
+	
+	discType := strings.ToLower(gui.discTypeSelect.Selected)
+	visualizer := preview.NewTrackVisualizer(0, 0, 0, discType)
+	if err := visualizer.SetSize(int(gui.discSurface.Size().Width)); err != nil {
+		return
+	}
+	gui.discSurface.Image = visualizer.ShadeBlank(preview.Appearance{
+		Dye: dye,
+		Light: preview.Lighting{
+			Azimuth:   preview.DefaultLightAzimuth,
+			Elevation: preview.DefaultLightElevation,
+		},
+	})
+	gui.discSurface.Show()
+	gui.discSurface.Refresh()
 }
 
 // loadPresetValues loads preset values into form fields
@@ -661,11 +736,11 @@ func (gui *CDImageGUI) loadPresetValues(presetKey string) {
 	}
 	
 	// Safety check to ensure entry widgets are initialized
-	if gui.tr0Entry == nil || gui.dtrEntry == nil || gui.r0Entry == nil {
+	if gui.tr0Entry == nil || gui.dtrEntry == nil || gui.r0Entry == nil || gui.paletteEntry == nil {
 		return
 	}
 	
-	preset, exists := GetPresetByName(presetKey)
+	preset, exists := presets.GetPresetByName(presetKey)
 	if !exists {
 		return
 	}
@@ -673,13 +748,21 @@ func (gui *CDImageGUI) loadPresetValues(presetKey string) {
 	gui.tr0Entry.SetText(fmt.Sprintf("%.2f", preset.Tr0))
 	gui.dtrEntry.SetText(fmt.Sprintf("%.6f", preset.Dtr))
 	gui.r0Entry.SetText(fmt.Sprintf("%.1f", preset.R0))
+	if palette, err := preset.GetPalette(); err == nil {
+		gui.paletteEntry.SetText(palette.String())
+	}
 }
 
 // resetForm resets all form fields to defaults
 func (gui *CDImageGUI) resetForm() {
 	gui.discTypeSelect.SetSelected("CD")
 	gui.updatePresetOptions()
-	gui.mixColorsCheck.SetChecked(false)
+	gui.dyeSelect.ClearSelected()
+	gui.ditherSelect.SetSelected(encoder.DitherOrdered)
+	gui.seedEntry.SetText("1")
+	gui.screenEntry.SetText(fmt.Sprintf("%g", encoder.DefaultScreenFrequency))
+	gui.samplingSelect.SetSelected(encoder.SamplingNearest)
+	gui.areaCheck.SetChecked(false)
 	gui.parallelCheck.SetChecked(true)
 	gui.outputEntry.SetText("track.raw")
 }
@@ -710,6 +793,24 @@ func (gui *CDImageGUI) startConversion() {
 		return
 	}
 	
+	seed, err := strconv.ParseInt(gui.seedEntry.Text, 10, 64)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Invalid seed: %w", err), gui.window)
+		return
+	}
+	
+	screen, err := strconv.ParseFloat(gui.screenEntry.Text, 64)
+	if err != nil || screen <= 0 {
+		dialog.ShowError(fmt.Errorf("Invalid screen frequency: %s", gui.screenEntry.Text), gui.window)
+		return
+	}
+	
+	palette, err := encoder.ParsePalette(gui.paletteEntry.Text)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("Invalid palette: %w", err), gui.window)
+		return
+	}
+	
 	if gui.outputEntry.Text == "" {
 		dialog.ShowError(fmt.Errorf("Output file cannot be empty"), gui.window)
 		return
@@ -719,38 +820,49 @@ func (gui *CDImageGUI) startConversion() {
 	gui.setConvertingState(true)
 	
 	// Start conversion in goroutine
-	go gui.runConversion(tr0, dtr, r0)
+	go gui.runConversion(tr0, dtr, r0, seed, screen, palette)
 }
 
 // runConversion runs the actual conversion process
-func (gui *CDImageGUI) runConversion(tr0, dtr, r0 float64) {
+func (gui *CDImageGUI) runConversion(tr0, dtr, r0 float64, seed int64, screen float64, palette *encoder.Palette) {
 	defer gui.setConvertingState(false)
 	
 	discType := strings.ToLower(gui.discTypeSelect.Selected)
-	mixColors := gui.mixColorsCheck.Checked
+	dither := gui.ditherSelect.Selected
+	sampling := gui.samplingSelect.Selected
+	areaFilter := gui.areaCheck.Checked
 	useParallel := gui.parallelCheck.Checked
 	outputFile := gui.outputEntry.Text
 	
+	// Write a WAV container when the output file asks for one
+	format := encoder.FormatRaw
+	if strings.EqualFold(filepath.Ext(outputFile), ".wav") {
+		format = encoder.FormatWAV
+	}
+	
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	gui.cancelFunc = cancel
 	defer cancel()
 	
 	// Process image
-	processedImg := createDiscImage(gui.currentImage, discType)
+	processedImg := encoder.CreateDiscImage(gui.currentImage, discType)
 	
 	// Create converter
-	var converter interface {
-		Convert(context.Context, image.Image, string) error
-		SetProgressCallback(func(int))
-		SetCancelCallback(func() bool)
-	}
-	
-	if useParallel {
-		converter = NewMultiThreadedConverter(tr0, dtr, r0, mixColors, discType)
-	} else {
-		converter = NewConverter(tr0, dtr, r0, mixColors, discType)
-	}
+	converter := encoder.New(encoder.Options{
+		Tr0:        tr0,
+		Dtr:        dtr,
+		R0:         r0,
+		Dither:     dither,
+		Seed:       seed,
+		Screen:     screen,
+		Palette:    palette,
+		Sampling:   sampling,
+		AreaFilter: areaFilter,
+		DiscType:   discType,
+		Parallel:   useParallel,
+		Format:     format,
+	})
 	
 	// Set up progress callback
 	converter.SetProgressCallback(func(progress int) {
@@ -765,16 +877,7 @@ func (gui *CDImageGUI) runConversion(tr0, dtr, r0 float64) {
 	})
 	
 	// Start conversion
-	var err error
-	if useParallel {
-		if mtconv, ok := converter.(*MultiThreadedConverter); ok {
-			err = mtconv.ConvertParallel(ctx, processedImg, outputFile)
-		} else {
-			err = converter.Convert(ctx, processedImg, outputFile)
-		}
-	} else {
-		err = converter.Convert(ctx, processedImg, outputFile)
-	}
+	err := converter.ConvertFile(ctx, processedImg, outputFile)
 	
 	// Show result
 	if err != nil {
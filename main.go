@@ -17,7 +17,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"cdimage/encoder"
+	"cdimage/preview"
 	"github.com/spf13/cobra"
 )
 
@@ -39,6 +43,9 @@ to audio tracks that create patterns when burned. Supports both CD and DVD forma
 	rootCmd.AddCommand(createListPresetsCmd())
 	rootCmd.AddCommand(createGUICmd())
 	rootCmd.AddCommand(createVisualizeCmd())
+	rootCmd.AddCommand(createDecodeCmd())
+	rootCmd.AddCommand(createPreviewCmd())
+	rootCmd.AddCommand(createCompareCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -54,30 +61,81 @@ func createBurnCmd() *cobra.Command {
 		tr0            float64
 		dtr            float64
 		r0             float64
-		mixColors      bool
+		dither         ditherOptions
+		sampling       samplingOptions
+		placement      placementOptions
+		palette        string
 		preset         string
 		useMultithread bool
+		format         string
+		splitCount     int
+		splitRadii     []float64
 	)
 
 	cmd := &cobra.Command{
 		Use:   "burn",
 		Short: "Convert image to burnable audio track",
 		Long: `Convert an image file to an audio track that can be burned onto a CD or DVD
-to create a visible pattern on the disc surface.`,
+to create a visible pattern on the disc surface.
+
+The image is scaled onto a 3000x3000 disc raster before it is sampled. With
+--direct the spiral samples the source image itself, placed on the disc by
+--scale, --offset-x, --offset-y and --rotate, so a large image keeps the
+detail the spiral can show. The source is averaged down to --resolution
+pixels while it is decoded; PNG files are streamed row by row.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return burnImage(inputFile, outputFile, discType, tr0, dtr, r0, mixColors, preset, useMultithread)
+			format = strings.ToLower(format)
+			if !cmd.Flags().Changed("format") {
+				switch strings.ToLower(filepath.Ext(outputFile)) {
+				case ".wav":
+					format = encoder.FormatWAV
+				case ".bin":
+					format = encoder.FormatBIN
+				case ".flac":
+					format = encoder.FormatFLAC
+				}
+				if splitCount > 1 || len(splitRadii) > 0 {
+					format = encoder.FormatBIN
+				}
+			}
+			if !cmd.Flags().Changed("output") && format != encoder.FormatRaw {
+				outputFile = "track." + format
+			}
+			return burnImage(burnOptions{
+				InputFile:  inputFile,
+				OutputFile: outputFile,
+				DiscType:   discType,
+				Tr0:        tr0,
+				Dtr:        dtr,
+				R0:         r0,
+				Dither:     dither,
+				Sampling:   sampling,
+				Placement:  placement,
+				Palette:    palette,
+				Preset:     preset,
+				Parallel:   useMultithread,
+				Format:     format,
+				SplitCount: splitCount,
+				SplitRadii: splitRadii,
+			})
 		},
 	}
 
 	cmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input image file (required)")
-	cmd.Flags().StringVarP(&outputFile, "output", "o", "track.raw", "Output audio track file")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "track.raw", "Output audio track file ('-' for stdout)")
 	cmd.Flags().StringVarP(&discType, "type", "t", "cd", "Disc type: cd or dvd")
 	cmd.Flags().Float64Var(&tr0, "tr0", 0, "Initial track parameter (use preset if 0)")
 	cmd.Flags().Float64Var(&dtr, "dtr", 0, "Track delta parameter (use preset if 0)")
 	cmd.Flags().Float64Var(&r0, "r0", 24.5, "Initial radius parameter")
-	cmd.Flags().BoolVar(&mixColors, "mix-colors", false, "Use random color mixing")
+	addDitherFlags(cmd, &dither)
+	addSamplingFlags(cmd, &sampling)
+	addPlacementFlags(cmd, &placement)
+	addPaletteFlag(cmd, &palette)
 	cmd.Flags().StringVarP(&preset, "preset", "p", "", "Use disc preset (see list-presets)")
 	cmd.Flags().BoolVarP(&useMultithread, "parallel", "j", true, "Use multi-threaded conversion (default: true)")
+	cmd.Flags().StringVarP(&format, "format", "f", encoder.FormatRaw, "Output format: raw, wav, bin (also writes a .cue sheet) or flac")
+	cmd.Flags().IntVar(&splitCount, "split", 1, "Split the output into this many CD-DA tracks (bin/cue only)")
+	cmd.Flags().Float64SliceVar(&splitRadii, "split-radii", nil, "Start new tracks at these radii in mm, e.g. 35,45 (bin/cue only)")
 
 	cmd.MarkFlagRequired("input")
 
@@ -115,28 +173,218 @@ func createVisualizeCmd() *cobra.Command {
 		dtr         float64
 		r0          float64
 		preset      string
+		palette     string
+		size        int
+		format      string
+		speed       float64
+		interval    float64
+		look        appearanceOptions
+		sweepTr0    string
+		sweepDtr    string
+		cueTracks   []int
 	)
 
 	cmd := &cobra.Command{
 		Use:   "visualize",
 		Short: "Visualize how a raw track will look on disc",
 		Long: `Create a PNG image showing how the raw audio track will appear when
-burned onto a CD or DVD surface. This lets you preview the result without
-wasting blank discs.`,
+burned onto a CD or DVD surface. The track is de-interleaved and every
+sample is drawn at its radius and angle on the disc with the brightness of
+its palette level, so tr0/dtr/r0 and the palette must match the ones used
+for burning.
+This lets you preview the result without wasting blank discs.
+
+The track is streamed and rendered in parallel, so images of up to
+16384x16384 pixels can be made from CD and DVD tracks alike.
+
+With --format deepzoom the image is written as a tiled pyramid next to an
+offline HTML viewer that pans, zooms and shows the radius, track and sample
+under the cursor.
+
+With --format gif or apng the output is an animation of the disc filling in
+from r0 outward while it is burned at --speed, one frame per
+--frame-interval seconds of burning.
+
+With --dye the PNG shows the colors of the recording layer instead of gray
+levels, lit from --light-angle with the rainbow the grooves diffract.
+
+With --sweep-tr0 and/or --sweep-dtr from:to:steps the track is rendered
+once for every combination of values into a labelled contact sheet, to find
+the geometry of a disc that came out sheared or twisted. --size then sets
+the size of a cell.
+
+A .cue track file renders the BIN/CUE image the way it lies on the disc,
+with the pregaps of the cue sheet between the tracks. --cue-tracks selects
+the tracks to render, as if only they were burned.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return visualizeTrack(trackFile, outputImage, discType, tr0, dtr, r0, preset)
+			format = strings.ToLower(format)
+			if !cmd.Flags().Changed("format") {
+				switch strings.ToLower(filepath.Ext(outputImage)) {
+				case ".html", ".htm":
+					format = preview.FormatDeepZoom
+				case ".gif":
+					format = preview.FormatGIF
+				case ".apng":
+					format = preview.FormatAPNG
+				}
+			}
+			switch format {
+			case preview.FormatDeepZoom:
+				if !cmd.Flags().Changed("output") {
+					outputImage = "disc_preview.html"
+				}
+				if !cmd.Flags().Changed("size") {
+					size = preview.DefaultDeepZoomSize
+				}
+			case preview.FormatGIF, preview.FormatAPNG:
+				if !cmd.Flags().Changed("output") {
+					outputImage = "disc_burn.gif"
+					if format == preview.FormatAPNG {
+						outputImage = "disc_burn.png"
+					}
+				}
+				if !cmd.Flags().Changed("size") {
+					size = preview.DefaultAnimationSize
+				}
+			}
+			anim := preview.BurnAnimation{
+				Format:        format,
+				Speed:         speed,
+				FrameInterval: interval,
+			}
+
+			var sweep geometrySweep
+			if sweepTr0 != "" || sweepDtr != "" {
+				if !cmd.Flags().Changed("size") {
+					size = preview.DefaultSweepCellSize
+				}
+				if err := sweep.parse(sweepTr0, sweepDtr); err != nil {
+					return err
+				}
+			}
+			return visualizeTrack(trackFile, outputImage, discType, tr0, dtr, r0, preset, palette, size, format, anim, look, sweep, cueTracks)
 		},
 	}
 
-	cmd.Flags().StringVarP(&trackFile, "track", "t", "", "Raw track file to visualize (required)")
+	cmd.Flags().StringVarP(&trackFile, "track", "t", "", "Raw, WAV, FLAC or BIN/CUE (.cue) track file to visualize (required)")
 	cmd.Flags().StringVarP(&outputImage, "output", "o", "disc_preview.png", "Output PNG image file")
 	cmd.Flags().StringVarP(&discType, "type", "d", "cd", "Disc type: cd or dvd")
 	cmd.Flags().Float64Var(&tr0, "tr0", 0, "Initial track parameter (use preset if 0)")
 	cmd.Flags().Float64Var(&dtr, "dtr", 0, "Track delta parameter (use preset if 0)")
 	cmd.Flags().Float64Var(&r0, "r0", 24.5, "Initial radius parameter")
 	cmd.Flags().StringVarP(&preset, "preset", "p", "", "Use disc preset (see list-presets)")
+	addPaletteFlag(cmd, &palette)
+	cmd.Flags().IntVarP(&size, "size", "s", preview.DefaultSize, "Width and height of the image in pixels (64-16384, deepzoom default 8192)")
+	cmd.Flags().StringVarP(&format, "format", "f", preview.FormatPNG, "Output format: png, deepzoom for a tiled image with an HTML viewer, or gif/apng for a burn animation")
+	cmd.Flags().Float64Var(&speed, "speed", 4, "Write speed of the burn animation (e.g. 4 for 4x)")
+	cmd.Flags().Float64Var(&interval, "frame-interval", 0, "Seconds of burning per animation frame (0 for 60 frames)")
+	addAppearanceFlags(cmd, &look)
+	cmd.Flags().StringVar(&sweepTr0, "sweep-tr0", "", "Render a contact sheet for tr0 values from:to:steps")
+	cmd.Flags().StringVar(&sweepDtr, "sweep-dtr", "", "Render a contact sheet for dtr values from:to:steps")
+	cmd.Flags().IntSliceVar(&cueTracks, "cue-tracks", nil, "Tracks of a cue sheet to render, e.g. 2,3 (all audio tracks if empty)")
 
 	cmd.MarkFlagRequired("track")
 
 	return cmd
-}
\ No newline at end of file
+}
+
+func createDecodeCmd() *cobra.Command {
+	var (
+		inputFile  string
+		outputFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "decode",
+		Short: "Decode a FLAC track back to raw or WAV",
+		Long: `Decode a FLAC track written by 'burn --format flac' back to the exact
+raw sample stream, ready for burning. A .wav output file gets a WAV header.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return decodeTrack(inputFile, outputFile)
+		},
+	}
+
+	cmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input FLAC track file (required)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "track.raw", "Output raw or WAV track file ('-' for stdout)")
+
+	cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+func createPreviewCmd() *cobra.Command {
+	var opts previewOptions
+
+	cmd := &cobra.Command{
+		Use:   "preview",
+		Short: "Predict how an image will look on disc without converting it",
+		Long: `Simulate the burn pipeline in memory: the image is placed on the disc
+raster, sampled along the spiral, dithered and quantised to the palette like
+'burn' does, and the predicted disc is drawn like 'visualize' draws a track.
+Only a fraction of the tracks is simulated, so the preview takes seconds
+instead of a full conversion.
+
+With --dye the preview shows the colors of the recording layer instead of
+gray levels, lit from --light-angle with the rainbow the grooves diffract.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return previewImage(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "", "Input image file (required)")
+	cmd.Flags().StringVarP(&opts.OutputFile, "output", "o", "preview.png", "Output PNG image file")
+	cmd.Flags().StringVarP(&opts.DiscType, "type", "t", "cd", "Disc type: cd or dvd")
+	cmd.Flags().Float64Var(&opts.Tr0, "tr0", 0, "Initial track parameter (use preset if 0)")
+	cmd.Flags().Float64Var(&opts.Dtr, "dtr", 0, "Track delta parameter (use preset if 0)")
+	cmd.Flags().Float64Var(&opts.R0, "r0", 24.5, "Initial radius parameter")
+	addDitherFlags(cmd, &opts.Dither)
+	addSamplingFlags(cmd, &opts.Sampling)
+	addPlacementFlags(cmd, &opts.Placement)
+	addPaletteFlag(cmd, &opts.Palette)
+	cmd.Flags().StringVarP(&opts.Preset, "preset", "p", "", "Use disc preset (see list-presets)")
+	cmd.Flags().IntVarP(&opts.Size, "size", "s", preview.DefaultSize, "Width and height of the image in pixels (64-16384)")
+	cmd.Flags().Float64Var(&opts.Density, "density", 0, "Fraction of the tracks to simulate, 1 for all (0 for automatic)")
+	addAppearanceFlags(cmd, &opts.Look)
+
+	cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+func createCompareCmd() *cobra.Command {
+	var opts compareOptions
+
+	cmd := &cobra.Command{
+		Use:   "compare",
+		Short: "Measure how faithfully a track or preview reproduces its source image",
+		Long: `Compare a raw, WAV, FLAC or BIN/CUE track, or a gray level PNG written
+by 'visualize' or 'preview', with the source image it was made from. Both are resampled
+into the same polar frame over the written part of the spiral, using the
+preset geometry: the source where the converter samples it and the result
+where its samples lie on the disc. The command reports PSNR, SSIM and the
+error by radius, and can draw the error on the disc as a heatmap.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return compareResult(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.InputFile, "input", "i", "", "Source image file (required)")
+	cmd.Flags().StringVarP(&opts.ResultFile, "result", "r", "", "Track file or preview PNG to compare (required)")
+	cmd.Flags().StringVarP(&opts.DiscType, "type", "t", "cd", "Disc type: cd or dvd")
+	cmd.Flags().Float64Var(&opts.Tr0, "tr0", 0, "Initial track parameter (use preset if 0)")
+	cmd.Flags().Float64Var(&opts.Dtr, "dtr", 0, "Track delta parameter (use preset if 0)")
+	cmd.Flags().Float64Var(&opts.R0, "r0", 24.5, "Initial radius parameter")
+	cmd.Flags().StringVarP(&opts.Preset, "preset", "p", "", "Use disc preset (see list-presets)")
+	addPaletteFlag(cmd, &opts.Palette)
+	cmd.Flags().IntVar(&opts.Rings, "rings", preview.DefaultCompareRings, "Radial resolution of the polar frame")
+	cmd.Flags().IntVar(&opts.Sectors, "sectors", preview.DefaultCompareSectors, "Angular resolution of the polar frame")
+	cmd.Flags().StringVar(&opts.Heatmap, "heatmap", "", "Write the error drawn on the disc to this PNG file")
+	cmd.Flags().IntVarP(&opts.Size, "size", "s", preview.DefaultSize, "Width and height of the heatmap in pixels (64-16384)")
+	cmd.Flags().StringVar(&opts.CSVFile, "csv", "", "Write the error of every ring to this CSV file")
+	cmd.Flags().IntSliceVar(&opts.CueTracks, "cue-tracks", nil, "Tracks of a cue sheet to compare, e.g. 2,3 (all audio tracks if empty)")
+
+	cmd.MarkFlagRequired("input")
+	cmd.MarkFlagRequired("result")
+
+	return cmd
+}
@@ -1,8 +1,10 @@
-package main
+// Package presets holds the spiral geometry of known disc models.
+package presets
 
 import (
-	"fmt"
 	"strings"
+
+	"cdimage/encoder"
 )
 
 // DiscPreset represents the parameters for a specific disc type
@@ -12,6 +14,15 @@ type DiscPreset struct {
 	Tr0      float64
 	Dtr      float64
 	R0       float64
+	Palette  []encoder.PaletteEntry // Bytes measured on the media; nil, as in every built-in preset, for encoder.DefaultPalette
+}
+
+// GetPalette returns the palette of the preset
+func (p DiscPreset) GetPalette() (*encoder.Palette, error) {
+	if len(p.Palette) == 0 {
+		return encoder.DefaultPalette, nil
+	}
+	return encoder.NewPalette(p.Palette)
 }
 
 // GetPresets returns all available disc presets
@@ -97,45 +108,3 @@ func GetDefaultPreset(discType string) DiscPreset {
 		return GetPresets()["verbatim-cd-rw-1"]
 	}
 }
-
-// listPresets prints all available presets
-func listPresets() {
-	presets := GetPresets()
-	
-	fmt.Println("Available disc presets:")
-	fmt.Println()
-	
-	// Group by disc type
-	cdPresets := make([]string, 0)
-	dvdPresets := make([]string, 0)
-	
-	for key, preset := range presets {
-		if preset.DiscType == "cd" {
-			cdPresets = append(cdPresets, key)
-		} else if preset.DiscType == "dvd" {
-			dvdPresets = append(dvdPresets, key)
-		}
-	}
-	
-	if len(cdPresets) > 0 {
-		fmt.Println("CD Presets:")
-		for _, key := range cdPresets {
-			preset := presets[key]
-			fmt.Printf("  %-20s - %s (tr0=%.2f, dtr=%.6f, r0=%.1f)\n",
-				key, preset.Name, preset.Tr0, preset.Dtr, preset.R0)
-		}
-		fmt.Println()
-	}
-	
-	if len(dvdPresets) > 0 {
-		fmt.Println("DVD Presets:")
-		for _, key := range dvdPresets {
-			preset := presets[key]
-			fmt.Printf("  %-20s - %s (tr0=%.2f, dtr=%.6f, r0=%.1f)\n",
-				key, preset.Name, preset.Tr0, preset.Dtr, preset.R0)
-		}
-		fmt.Println()
-	}
-	
-	fmt.Println("Usage: cdimage burn -i image.jpg -p preset-name")
-}
\ No newline at end of file
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"cdimage/presets"
+)
+
+// listPresets prints all available presets
+func listPresets() {
+	discPresets := presets.GetPresets()
+	
+	fmt.Println("Available disc presets:")
+	fmt.Println()
+	
+	// Group by disc type
+	cdPresets := make([]string, 0)
+	dvdPresets := make([]string, 0)
+	
+	for key, preset := range discPresets {
+		if preset.DiscType == "cd" {
+			cdPresets = append(cdPresets, key)
+		} else if preset.DiscType == "dvd" {
+			dvdPresets = append(dvdPresets, key)
+		}
+	}
+	
+	if len(cdPresets) > 0 {
+		fmt.Println("CD Presets:")
+		for _, key := range cdPresets {
+			preset := discPresets[key]
+			fmt.Printf("  %-20s - %s (tr0=%.2f, dtr=%.6f, r0=%.1f)\n",
+				key, preset.Name, preset.Tr0, preset.Dtr, preset.R0)
+			printPresetPalette(preset)
+		}
+		fmt.Println()
+	}
+	
+	if len(dvdPresets) > 0 {
+		fmt.Println("DVD Presets:")
+		for _, key := range dvdPresets {
+			preset := discPresets[key]
+			fmt.Printf("  %-20s - %s (tr0=%.2f, dtr=%.6f, r0=%.1f)\n",
+				key, preset.Name, preset.Tr0, preset.Dtr, preset.R0)
+			printPresetPalette(preset)
+		}
+		fmt.Println()
+	}
+	
+	fmt.Println("Usage: cdimage burn -i image.jpg -p preset-name")
+}
+
+// printPresetPalette prints the palette of a preset that has its own
+func printPresetPalette(preset presets.DiscPreset) {
+	if len(preset.Palette) == 0 {
+		return
+	}
+	if palette, err := preset.GetPalette(); err != nil {
+		fmt.Printf("  %-20s   invalid palette: %v\n", "", err)
+	} else {
+		fmt.Printf("  %-20s   palette: %d levels (%s)\n", "", palette.Len(), palette)
+	}
+}
@@ -0,0 +1,257 @@
+package preview
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"cdimage/encoder"
+)
+
+// Playback of burn animations
+const (
+	DefaultAnimationSize   = 600
+	DefaultAnimationFrames = 60
+	MaxAnimationFrames     = 1000
+
+	frameDelay     = 100  // ms per frame
+	lastFrameDelay = 3000 // ms the finished disc is shown before looping
+)
+
+// neverBurned marks pixels no sample is written to
+const neverBurned = math.MaxUint32
+
+// dvdBytesPerSecond is the DVD 1x write speed
+const dvdBytesPerSecond = 1385000
+
+// Colors of the frame labels
+var (
+	labelText = color.Gray{Y: 255}
+	labelBox  = color.Gray{Y: grayOutside}
+)
+
+// transparentIndex is the palette entry of pixels a frame leaves unchanged
+const transparentIndex = 255
+
+// animationPalette holds 255 gray levels and a transparent entry
+var animationPalette = func() color.Palette {
+	p := make(color.Palette, 256)
+	for i := 0; i < transparentIndex; i++ {
+		g := uint8((i*255 + 127) / 254)
+		p[i] = color.Gray{Y: g}
+	}
+	p[transparentIndex] = color.Transparent
+	return p
+}()
+
+// paletteIndex returns the animation palette entry closest to gray level g
+func paletteIndex(g uint8) uint8 {
+	return uint8((int(g)*254 + 127) / 255)
+}
+
+// BurnAnimation configures VisualizeBurn
+type BurnAnimation struct {
+	Format        string  // FormatGIF or FormatAPNG
+	Speed         float64 // Write speed, 1 for 1x
+	FrameInterval float64 // Seconds of burning per frame, 0 for DefaultAnimationFrames frames
+}
+
+// VisualizeBurn renders a raw, WAV, FLAC or BIN/CUE track as an animation
+// of the disc filling in from r0 outward while it is burned. Each frame adds
+// the pixels first written during the next FrameInterval seconds at the
+// given write speed, where 1x writes SampleRate*BytesPerFrame/SectorSize
+// (75) sectors per second on CD and 1385 kB per second on DVD.
+func (v *TrackVisualizer) VisualizeBurn(trackFile, outputImage string, anim BurnAnimation) error {
+	if anim.Format != FormatGIF && anim.Format != FormatAPNG {
+		return fmt.Errorf("animation format must be '%s' or '%s'", FormatGIF, FormatAPNG)
+	}
+	if anim.Speed <= 0 {
+		return fmt.Errorf("write speed must be > 0")
+	}
+	if anim.FrameInterval < 0 {
+		return fmt.Errorf("frame interval must not be negative")
+	}
+
+	file, err := os.Open(trackFile)
+	if err != nil {
+		return fmt.Errorf("failed to open track file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file stats: %w", err)
+	}
+
+	fmt.Printf("Track file size: %.1f MB\n", float64(stat.Size())/(1024*1024))
+
+	track, trackSize, cleanup, err := v.openTrack(file, stat.Size())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// Burn timing of the track
+	bytesPerSecond := float64(encoder.SampleRate * encoder.BytesPerFrame)
+	if v.discType == "dvd" {
+		bytesPerSecond = dvdBytesPerSecond
+	}
+	sectorsPerSecond := bytesPerSecond / encoder.SectorSize * anim.Speed
+	totalSectors := (trackSize + encoder.SectorSize - 1) / encoder.SectorSize
+	duration := float64(totalSectors) / sectorsPerSecond
+
+	frames, interval := DefaultAnimationFrames, duration/DefaultAnimationFrames
+	if anim.FrameInterval > 0 {
+		interval = anim.FrameInterval
+		frames = int(math.Ceil(duration / interval))
+	}
+	if frames > MaxAnimationFrames {
+		return fmt.Errorf("%d frames exceed the limit of %d, increase the frame interval", frames, MaxAnimationFrames)
+	}
+
+	fmt.Printf("Burn time at %gx: %s, %d frames of %.1f s\n", anim.Speed, formatDuration(duration), frames, interval)
+	fmt.Printf("Rendering %dx%d image with %d workers...\n", v.size, v.size, v.numWorkers)
+
+	burned := make([]uint32, v.size*v.size)
+	for i := range burned {
+		burned[i] = neverBurned
+	}
+	img, err := v.render(track, trackSize, burned)
+	if err != nil {
+		return err
+	}
+
+	outFile, err := os.Create(outputImage)
+	if err != nil {
+		return fmt.Errorf("failed to create output image: %w", err)
+	}
+	defer outFile.Close()
+
+	bw := bufio.NewWriter(outFile)
+	var enc frameEncoder
+	if anim.Format == FormatGIF {
+		enc = &gifEncoder{w: bw}
+	} else {
+		enc = newAPNGWriter(bw, frames+1)
+	}
+
+	// Frame 0 shows the blank disc, each following frame adds a time slice
+	tracks := v.newDecoder().Tracks()
+	scale := max(v.size/DefaultAnimationSize, 1)
+	frame := image.NewPaletted(img.Rect, animationPalette)
+	for i, g := range img.Pix {
+		if burned[i] != neverBurned {
+			g = graySurface
+		}
+		frame.Pix[i] = paletteIndex(g)
+	}
+	drawLabel(frame, burnLabel(0, duration, anim.Speed, tracks, 0), scale, labelText, labelBox)
+	if err := enc.WriteFrame(frame, frameDelay); err != nil {
+		return fmt.Errorf("failed to encode animation: %w", err)
+	}
+
+	start := uint32(0)
+	for f := 1; f <= frames; f++ {
+		t := min(float64(f)*interval, duration)
+		end := uint32(min(int64(math.Round(t*sectorsPerSecond)), totalSectors))
+		if f == frames {
+			end = neverBurned
+		}
+
+		frame = image.NewPaletted(img.Rect, animationPalette)
+		for i, s := range burned {
+			if s >= start && s < end {
+				frame.Pix[i] = paletteIndex(img.Pix[i])
+			} else {
+				frame.Pix[i] = transparentIndex
+			}
+		}
+		offset := min(int64(end), totalSectors) * encoder.SectorSize
+		drawLabel(frame, burnLabel(t, duration, anim.Speed, tracks, offset), scale, labelText, labelBox)
+
+		delay := frameDelay
+		if f == frames {
+			delay = lastFrameDelay
+		}
+		if err := enc.WriteFrame(frame, delay); err != nil {
+			return fmt.Errorf("failed to encode animation: %w", err)
+		}
+		fmt.Printf("\rEncoded frame %d of %d", f, frames)
+		start = end
+	}
+	fmt.Println()
+
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode animation: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write animation: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to write animation: %w", err)
+	}
+
+	fmt.Printf("Burn animation saved to: %s\n", outputImage)
+	return nil
+}
+
+// burnLabel describes the state of the burn after t seconds, when offset
+// bytes of the track are written. The lines are padded to the same width
+// in every frame.
+func burnLabel(t, duration, speed float64, tracks []encoder.TrackInfo, offset int64) []string {
+	total := formatDuration(duration)
+	time := fmt.Sprintf("%*s / %s at %gx", len(total), formatDuration(t), total, speed)
+
+	// The track being written when the frame ends
+	radius := "blank disc"
+	i := sort.Search(len(tracks), func(i int) bool { return tracks[i].Offset >= offset }) - 1
+	if offset > 0 && i >= 0 {
+		radius = fmt.Sprintf("r = %.2f mm", tracks[i].Radius)
+	}
+
+	width := max(len(time), len("r = 00.00 mm"))
+	return []string{
+		fmt.Sprintf("%-*s", width, time),
+		fmt.Sprintf("%-*s", width, radius),
+	}
+}
+
+// formatDuration formats seconds as m:ss, or h:mm:ss from an hour on
+func formatDuration(seconds float64) string {
+	s := int(math.Round(seconds))
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// frameEncoder writes the frames of an animation. Pixels set to
+// transparentIndex keep the content of the previous frame.
+type frameEncoder interface {
+	WriteFrame(img *image.Paletted, delay int) error
+	Close() error
+}
+
+// gifEncoder collects the frames and writes the GIF when closed, as the
+// image/gif encoder needs all of them at once
+type gifEncoder struct {
+	w    io.Writer
+	anim gif.GIF
+}
+
+func (e *gifEncoder) WriteFrame(img *image.Paletted, delay int) error {
+	e.anim.Image = append(e.anim.Image, img)
+	e.anim.Delay = append(e.anim.Delay, (delay+5)/10)
+	e.anim.Disposal = append(e.anim.Disposal, gif.DisposalNone)
+	return nil
+}
+
+func (e *gifEncoder) Close() error {
+	return gif.EncodeAll(e.w, &e.anim)
+}
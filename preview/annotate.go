@@ -0,0 +1,318 @@
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+
+	"cdimage/encoder"
+)
+
+// Overlay selects the annotations Annotate draws over a rendering
+type Overlay struct {
+	Rings    bool   // Radius rings every 5 mm, labelled every 10 mm
+	Tracks   bool   // Spiral track numbers along a radius left of the center
+	Angles   bool   // Sample angle ticks around the edge, clockwise from the right
+	Capacity bool   // Radius where the track data fills the media of the disc type
+	Info     bool   // Text box with the title and the geometry
+	Title    string // First line of the text box, e.g. the preset name
+}
+
+// OverlayItems are the names of the annotations accepted by ParseOverlay
+var OverlayItems = []string{"rings", "tracks", "angles", "capacity", "info"}
+
+// ParseOverlay returns the overlay with the named annotations, or all of
+// them for "all"
+func ParseOverlay(items []string) (Overlay, error) {
+	var o Overlay
+	for _, item := range items {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "all":
+			o.Rings, o.Tracks, o.Angles, o.Capacity, o.Info = true, true, true, true, true
+		case "rings":
+			o.Rings = true
+		case "tracks":
+			o.Tracks = true
+		case "angles":
+			o.Angles = true
+		case "capacity":
+			o.Capacity = true
+		case "info":
+			o.Info = true
+		default:
+			return Overlay{}, fmt.Errorf("unknown annotation '%s' (must be all or one of: %s)", item, strings.Join(OverlayItems, ", "))
+		}
+	}
+	return o, nil
+}
+
+// mediaLimit is the amount of track data recordable media hold
+type mediaLimit struct {
+	name  string
+	bytes int64
+}
+
+// mediaLimits are the capacities of the media of each disc type: 74 and
+// 80 minute CD-R at 75 sectors per second and single layer DVD±R
+var mediaLimits = map[string][]mediaLimit{
+	"cd": {
+		{"74 min", 74 * 60 * 75 * encoder.SectorSize},
+		{"80 min", 80 * 60 * 75 * encoder.SectorSize},
+	},
+	"dvd": {
+		{"4.7 GB", 2295104 * 2048},
+	},
+}
+
+// trackAxis is the angle in degrees of the track number scale, between
+// two labelled angle ticks
+const trackAxis = 165
+
+// Colors of the annotations
+var (
+	ringColor     = color.RGBA{0, 190, 255, 255}
+	trackColor    = color.RGBA{255, 200, 0, 255}
+	angleColor    = color.RGBA{255, 255, 255, 255}
+	capacityColor = color.RGBA{255, 64, 64, 255}
+)
+
+// SetOverlay makes VisualizeTrack and PreviewImage annotate their output
+// with o; nil draws no annotations
+func (v *TrackVisualizer) SetOverlay(o *Overlay) {
+	v.overlay = o
+}
+
+// Annotate draws the annotations of o over img, a rendering of the whole
+// disc for the spiral laid out as tracks, such as returned by Render or
+// Shade. Radii, track numbers and angles follow the geometry of the
+// rendering, so they line up with the samples.
+func (v *TrackVisualizer) Annotate(img image.Image, tracks []encoder.TrackInfo, o Overlay) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(out, out.Rect, img, img.Bounds().Min, draw.Src)
+
+	n := out.Rect.Dx()
+	a := annotator{
+		img: out,
+		g: ringGeometry{
+			center: float64(n) / 2,
+			scale:  float64(n) / 2 / discOuterRadius,
+		},
+		width: max(n/DefaultSize, 1),
+	}
+	a.g.pixel = 1 / a.g.scale
+
+	if o.Rings {
+		a.drawRings()
+	}
+	if o.Angles {
+		a.drawAngles()
+	}
+	if o.Tracks && len(tracks) > 1 {
+		a.drawTracks(tracks)
+	}
+	if o.Capacity {
+		a.drawCapacity(tracks, v.discType)
+	}
+	if o.Info {
+		title := o.Title
+		if title == "" {
+			title = "Custom geometry"
+		}
+		lines := []string{
+			title,
+			fmt.Sprintf("%s, tr0 = %.2f, dtr = %.6f, r0 = %.1f mm", strings.ToUpper(v.discType), v.tr0, v.dtr, v.r0),
+		}
+		if len(tracks) > 0 {
+			lines = append(lines, fmt.Sprintf("%d tracks from %.2f to %.2f mm", len(tracks), tracks[0].Radius, tracks[len(tracks)-1].Radius))
+		}
+		drawLabel(out, lines, a.width, labelText, labelBox)
+	}
+	return out
+}
+
+// annotator draws lines and labels in disc coordinates
+type annotator struct {
+	img   *image.RGBA
+	g     ringGeometry
+	width int // Line width and text scale in pixels
+}
+
+// point returns the pixel position of radius mm at angle degrees,
+// measured like the sample angles, clockwise from the right
+func (a annotator) point(radius, angle float64) (float64, float64) {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	return a.g.center + radius*a.g.scale*cos, a.g.center + radius*a.g.scale*sin
+}
+
+// line draws a line between two pixel positions
+func (a annotator) line(x0, y0, x1, y1 float64, c color.RGBA) {
+	steps := int(math.Ceil(2*math.Hypot(x1-x0, y1-y0))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		a.dot(x0+(x1-x0)*t, y0+(y1-y0)*t, c)
+	}
+}
+
+// circle draws a circle of radius mm, leaving gaps of every other dash
+// degrees if dash is not 0
+func (a annotator) circle(radius, dash float64, c color.RGBA) {
+	steps := int(math.Ceil(4*math.Pi*radius*a.g.scale)) + 8
+	for i := 0; i < steps; i++ {
+		angle := 360 * float64(i) / float64(steps)
+		if dash > 0 && int(angle/dash)%2 == 1 {
+			continue
+		}
+		x, y := a.point(radius, angle)
+		a.dot(x, y, c)
+	}
+}
+
+// dot fills the square of the line width around a pixel position
+func (a annotator) dot(x, y float64, c color.RGBA) {
+	x0 := int(math.Floor(x - float64(a.width)/2 + 0.5))
+	y0 := int(math.Floor(y - float64(a.width)/2 + 0.5))
+	for dy := 0; dy < a.width; dy++ {
+		for dx := 0; dx < a.width; dx++ {
+			if image.Pt(x0+dx, y0+dy).In(a.img.Rect) {
+				a.img.SetRGBA(x0+dx, y0+dy, c)
+			}
+		}
+	}
+}
+
+// label writes text centered on a pixel position
+func (a annotator) label(x, y float64, text string, c color.RGBA) {
+	lines := []string{text}
+	size := labelSize(lines, a.width)
+	p := image.Pt(int(x)-size.X/2, int(y)-size.Y/2)
+	drawText(a.img, p, lines, a.width, c, labelBox)
+}
+
+// drawRings draws the radius rings, labelled on the upper right diagonal
+func (a annotator) drawRings() {
+	for r := 10.0; r <= discOuterRadius; r += 5 {
+		a.circle(r, 0, ringColor)
+	}
+	for r := 10.0; r <= discOuterRadius; r += 10 {
+		x, y := a.point(r, -45)
+		a.label(x, y, fmt.Sprintf("%.0f mm", r), ringColor)
+	}
+}
+
+// drawAngles draws ticks around the edge every 5 degrees, longer and
+// labelled every 30 degrees
+func (a annotator) drawAngles() {
+	for angle := 0; angle < 360; angle += 5 {
+		length := 1.0
+		if angle%30 == 0 {
+			length = 2.5
+		}
+		x0, y0 := a.point(discOuterRadius-0.3, float64(angle))
+		x1, y1 := a.point(discOuterRadius-0.3-length, float64(angle))
+		a.line(x0, y0, x1, y1, angleColor)
+		if angle%30 == 0 {
+			x, y := a.point(discOuterRadius-4.5, float64(angle))
+			a.label(x, y, fmt.Sprint(angle), angleColor)
+		}
+	}
+}
+
+// drawTracks draws a scale of spiral track numbers along the radius at
+// trackAxis, labelled on its lower side
+func (a annotator) drawTracks(tracks []encoder.TrackInfo) {
+	x0, y0 := a.point(tracks[0].Radius, trackAxis)
+	x1, y1 := a.point(tracks[len(tracks)-1].Radius, trackAxis)
+	a.line(x0, y0, x1, y1, trackColor)
+
+	// Ticks run across the radius
+	sin, cos := math.Sincos(trackAxis * math.Pi / 180)
+	px, py := -sin, cos
+	if py < 0 {
+		px, py = -px, -py
+	}
+
+	step := niceStep(float64(len(tracks)) / 4)
+	tick := a.g.scale * 0.75
+	for i := 0; i < len(tracks); i += step {
+		x, y := a.point(tracks[i].Radius, trackAxis)
+		a.line(x-px*tick, y-py*tick, x+px*tick, y+py*tick, trackColor)
+		text := fmt.Sprint(i)
+		if i == 0 {
+			text = "track 0"
+		}
+		size := labelSize([]string{text}, a.width)
+		offset := tick + float64(size.Y)/2 + 2
+		a.label(x+px*offset, y+py*offset, text, trackColor)
+	}
+}
+
+// drawCapacity draws a dashed circle where the track data reaches the
+// capacity of each medium of the disc type, extrapolating the spiral past
+// its last track
+func (a annotator) drawCapacity(tracks []encoder.TrackInfo, discType string) {
+	for i, limit := range mediaLimits[discType] {
+		r, ok := capacityRadius(tracks, limit.bytes)
+		if !ok {
+			continue
+		}
+		a.circle(r, 2, capacityColor)
+		x, y := a.point(r, 140-float64(i)*10)
+		a.label(x, y, limit.name+" limit", capacityColor)
+	}
+}
+
+// capacityRadius returns the radius in mm at which the spiral laid out as
+// tracks reaches offset bytes, or false if that is past the edge of the
+// disc
+func capacityRadius(tracks []encoder.TrackInfo, bytes int64) (float64, bool) {
+	if len(tracks) < 3 {
+		return 0, false
+	}
+
+	i := sort.Search(len(tracks), func(i int) bool { return tracks[i].Offset >= bytes })
+	switch {
+	case i == 0:
+		return tracks[0].Radius, true
+	case i < len(tracks):
+		prev, next := tracks[i-1], tracks[i]
+		f := float64(bytes-prev.Offset) / float64(next.Offset-prev.Offset)
+		return prev.Radius + f*(next.Radius-prev.Radius), true
+	}
+
+	// Past the last track the pitch and the growth of the track length stay
+	// the same
+	n := len(tracks)
+	r := tracks[n-1].Radius
+	pitch := r - tracks[n-2].Radius
+	length := float64(tracks[n-1].Offset - tracks[n-2].Offset)
+	growth := length - float64(tracks[n-2].Offset-tracks[n-3].Offset)
+	offset := float64(tracks[n-1].Offset)
+	for r <= discOuterRadius && pitch > 0 {
+		length += growth
+		if offset+length >= float64(bytes) {
+			r += pitch * (float64(bytes) - offset) / length
+			return r, r <= discOuterRadius
+		}
+		offset += length
+		r += pitch
+	}
+	return 0, false
+}
+
+// niceStep rounds x up to 1, 2 or 5 times a power of ten
+func niceStep(x float64) int {
+	if x <= 1 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*p >= x {
+			return int(m * p)
+		}
+	}
+	return int(10 * p)
+}
@@ -0,0 +1,159 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// APNG frame operations
+const (
+	apngDisposeNone = 0
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// apngWriter writes an animated PNG frame by frame. Every frame is encoded
+// by image/png; the header chunks of the first frame describe the whole
+// animation and the image data of the others moves into fdAT chunks. All
+// frames must have the same size and palette.
+type apngWriter struct {
+	w      io.Writer
+	frames int // Frames announced in acTL
+	n      int // Frames written
+	seq    uint32
+	enc    png.Encoder
+	buf    bytes.Buffer
+}
+
+// newAPNGWriter returns a writer for an endlessly looping animation of
+// frames frames
+func newAPNGWriter(w io.Writer, frames int) *apngWriter {
+	return &apngWriter{w: w, frames: frames}
+}
+
+// WriteFrame appends a frame shown for delay ms. The first frame replaces
+// the canvas, later ones are drawn over it so transparent pixels keep the
+// previous content.
+func (a *apngWriter) WriteFrame(img *image.Paletted, delay int) error {
+	if a.n == a.frames {
+		return errors.New("more frames than announced")
+	}
+
+	a.buf.Reset()
+	if err := a.enc.Encode(&a.buf, img); err != nil {
+		return err
+	}
+	data := a.buf.Bytes()
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errors.New("unexpected PNG encoding")
+	}
+	data = data[len(pngSignature):]
+
+	if a.n == 0 {
+		if _, err := a.w.Write(pngSignature); err != nil {
+			return err
+		}
+	}
+
+	blend := byte(apngBlendOver)
+	if a.n == 0 {
+		blend = apngBlendSource
+	}
+	var fctl [26]byte
+	binary.BigEndian.PutUint32(fctl[4:], uint32(img.Rect.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(img.Rect.Dy()))
+	binary.BigEndian.PutUint16(fctl[20:], uint16(delay))
+	binary.BigEndian.PutUint16(fctl[22:], 1000)
+	fctl[24] = apngDisposeNone
+	fctl[25] = blend
+
+	wroteFCTL := false
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data)
+		if int64(length) > int64(len(data)-12) {
+			return errors.New("unexpected PNG encoding")
+		}
+		typ := string(data[4:8])
+		body := data[8 : 8+length]
+		data = data[12+length:]
+
+		switch {
+		case typ == "IDAT":
+			if !wroteFCTL {
+				binary.BigEndian.PutUint32(fctl[0:], a.nextSeq())
+				if err := a.writeChunk("fcTL", fctl[:]); err != nil {
+					return err
+				}
+				wroteFCTL = true
+			}
+			if a.n == 0 {
+				if err := a.writeChunk("IDAT", body); err != nil {
+					return err
+				}
+				continue
+			}
+			fdat := make([]byte, 4+len(body))
+			binary.BigEndian.PutUint32(fdat, a.nextSeq())
+			copy(fdat[4:], body)
+			if err := a.writeChunk("fdAT", fdat); err != nil {
+				return err
+			}
+		case typ == "IEND":
+		case a.n == 0:
+			// IHDR, PLTE and tRNS of the first frame hold for all frames
+			if err := a.writeChunk(typ, body); err != nil {
+				return err
+			}
+			if typ == "IHDR" {
+				var actl [8]byte
+				binary.BigEndian.PutUint32(actl[0:], uint32(a.frames))
+				if err := a.writeChunk("acTL", actl[:]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	a.n++
+	return nil
+}
+
+// Close ends the animation
+func (a *apngWriter) Close() error {
+	if a.n != a.frames {
+		return errors.New("fewer frames than announced")
+	}
+	return a.writeChunk("IEND", nil)
+}
+
+func (a *apngWriter) nextSeq() uint32 {
+	seq := a.seq
+	a.seq++
+	return seq
+}
+
+func (a *apngWriter) writeChunk(typ string, body []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(body)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], crc.Sum32())
+
+	for _, b := range [][]byte{header[:], body, trailer[:]} {
+		if _, err := a.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
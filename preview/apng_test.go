@@ -0,0 +1,177 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// pngChunk is a chunk read back from a PNG stream
+type pngChunk struct {
+	typ  string
+	body []byte
+}
+
+// readChunks splits a PNG stream into its chunks, checking their CRCs
+func readChunks(t *testing.T, data []byte) []pngChunk {
+	t.Helper()
+	if !bytes.HasPrefix(data, pngSignature) {
+		t.Fatal("no PNG signature")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []pngChunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatalf("%d bytes after the last chunk", len(data))
+		}
+		length := binary.BigEndian.Uint32(data)
+		c := pngChunk{typ: string(data[4:8]), body: data[8 : 8+length]}
+		if crc := binary.BigEndian.Uint32(data[8+length:]); crc != crc32.ChecksumIEEE(data[4:8+length]) {
+			t.Fatalf("%s chunk %d has a bad CRC", c.typ, len(chunks))
+		}
+		chunks = append(chunks, c)
+		data = data[12+length:]
+	}
+	return chunks
+}
+
+// testFrames returns frames of random pixels over a palette with a
+// transparent entry, large enough for several IDAT chunks
+func testFrames(count int) []*image.Paletted {
+	rng := rand.New(rand.NewSource(1))
+	palette := color.Palette{color.Transparent}
+	for len(palette) < 256 {
+		palette = append(palette, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff})
+	}
+	frames := make([]*image.Paletted, count)
+	for i := range frames {
+		frames[i] = image.NewPaletted(image.Rect(0, 0, 300, 200), palette)
+		rng.Read(frames[i].Pix)
+	}
+	return frames
+}
+
+func TestAPNGWriter(t *testing.T) {
+	frames := testFrames(3)
+	var buf bytes.Buffer
+	a := newAPNGWriter(&buf, len(frames))
+	for i, frame := range frames {
+		if err := a.WriteFrame(frame, 100*(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.WriteFrame(frames[0], 100); err == nil {
+		t.Error("frame past the announced count accepted")
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := readChunks(t, buf.Bytes())
+	if chunks[0].typ != "IHDR" || chunks[1].typ != "acTL" || chunks[len(chunks)-1].typ != "IEND" {
+		t.Fatalf("chunks start with %s, %s and end with %s", chunks[0].typ, chunks[1].typ, chunks[len(chunks)-1].typ)
+	}
+	if n, plays := binary.BigEndian.Uint32(chunks[1].body), binary.BigEndian.Uint32(chunks[1].body[4:]); n != 3 || plays != 0 {
+		t.Errorf("acTL announces %d frames, %d plays", n, plays)
+	}
+
+	// fcTL and fdAT share one sequence; each frame is an fcTL followed by
+	// its image data, the first frame's in IDAT chunks
+	var (
+		seq      uint32
+		fctls    []pngChunk
+		frameDat [][]byte
+		idats    int
+	)
+	header := map[string][]byte{"IHDR": chunks[0].body}
+	for _, c := range chunks[1 : len(chunks)-1] {
+		switch c.typ {
+		case "fcTL", "fdAT":
+			if got := binary.BigEndian.Uint32(c.body); got != seq {
+				t.Fatalf("%s has sequence number %d, expected %d", c.typ, got, seq)
+			}
+			seq++
+			if c.typ == "fcTL" {
+				fctls = append(fctls, c)
+				frameDat = append(frameDat, nil)
+			} else {
+				if len(fctls) < 2 {
+					t.Fatal("fdAT in the first frame")
+				}
+				frameDat[len(frameDat)-1] = append(frameDat[len(frameDat)-1], c.body[4:]...)
+			}
+		case "IDAT":
+			if len(fctls) != 1 {
+				t.Fatalf("IDAT in frame %d", len(fctls))
+			}
+			idats++
+			frameDat[0] = append(frameDat[0], c.body...)
+		default:
+			if len(fctls) > 0 {
+				t.Fatalf("%s chunk between frames", c.typ)
+			}
+			header[c.typ] = c.body
+		}
+	}
+	if len(fctls) != len(frames) {
+		t.Fatalf("%d fcTL chunks for %d frames", len(fctls), len(frames))
+	}
+	if idats < 2 {
+		t.Errorf("first frame in %d IDAT chunk, the test needs more", idats)
+	}
+	for i, c := range fctls {
+		w, h := binary.BigEndian.Uint32(c.body[4:]), binary.BigEndian.Uint32(c.body[8:])
+		num, den := binary.BigEndian.Uint16(c.body[20:]), binary.BigEndian.Uint16(c.body[22:])
+		blend := byte(apngBlendOver)
+		if i == 0 {
+			blend = apngBlendSource
+		}
+		if w != 300 || h != 200 || int(num) != 100*(i+1) || den != 1000 || c.body[25] != blend {
+			t.Errorf("frame %d: fcTL %dx%d, delay %d/%d, blend %d", i, w, h, num, den, c.body[25])
+		}
+	}
+
+	// Viewers without APNG support show the first frame
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := img.(*image.Paletted); !ok || !bytes.Equal(p.Pix, frames[0].Pix) {
+		t.Error("first frame decodes to a different image")
+	}
+
+	// Every frame is the image data of a PNG of its own
+	for i, data := range frameDat {
+		var png1 bytes.Buffer
+		png1.Write(pngSignature)
+		w := &apngWriter{w: &png1}
+		w.writeChunk("IHDR", header["IHDR"])
+		w.writeChunk("PLTE", header["PLTE"])
+		w.writeChunk("tRNS", header["tRNS"])
+		w.writeChunk("IDAT", data)
+		w.writeChunk("IEND", nil)
+		img, err := png.Decode(&png1)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(img.(*image.Paletted).Pix, frames[i].Pix) {
+			t.Errorf("frame %d decodes to a different image", i)
+		}
+	}
+}
+
+func TestAPNGWriterTooFewFrames(t *testing.T) {
+	a := newAPNGWriter(&bytes.Buffer{}, 2)
+	if err := a.WriteFrame(testFrames(1)[0], 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err == nil {
+		t.Error("animation closed with fewer frames than announced")
+	}
+}
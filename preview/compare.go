@@ -0,0 +1,495 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"cdimage/encoder"
+)
+
+// Default resolution of the polar frame of Compare
+const (
+	DefaultCompareRings   = 256
+	DefaultCompareSectors = 1024
+)
+
+// Source tracks sampled per ring of the polar frame. Unlike the palette
+// levels, the gray values need no averaging over the dither pattern.
+const referenceTracksPerRing = 16
+
+// heatmapRange is the error in gray levels drawn in full color
+const heatmapRange = 64
+
+// CompareOptions configures Compare
+type CompareOptions struct {
+	Rings   int    // Radial bins of the polar frame
+	Sectors int    // Angular bins of the polar frame
+	Heatmap string // PNG file for the error drawn on the disc, empty for none
+}
+
+// Comparison holds the fidelity of a result to its source image
+type Comparison struct {
+	PSNR  float64 // dB, +Inf if identical
+	SSIM  float64 // Mean structural similarity, 1 if identical
+	Rings []RingError
+}
+
+// RingError is the error of one ring of the polar frame. The values are
+// NaN if the ring holds no samples.
+type RingError struct {
+	Radius float64 // Center of the ring in mm
+	Bins   int     // Bins holding samples of both images
+	RMSE   float64 // Root mean square error in gray levels
+	Bias   float64 // Mean of result minus source in gray levels
+	SSIM   float64 // Mean structural similarity
+}
+
+// Bands merges the rings into n bands of equal width, for reports too short
+// for every ring
+func (c *Comparison) Bands(n int) []RingError {
+	n = min(max(n, 1), len(c.Rings))
+	bands := make([]RingError, n)
+	for b := range bands {
+		from, to := b*len(c.Rings)/n, (b+1)*len(c.Rings)/n
+
+		var radius, mse, bias, ssim float64
+		var bins, ssimRings int
+		for _, r := range c.Rings[from:to] {
+			radius += r.Radius
+			if r.Bins == 0 {
+				continue
+			}
+			bins += r.Bins
+			mse += r.RMSE * r.RMSE * float64(r.Bins)
+			bias += r.Bias * float64(r.Bins)
+			if !math.IsNaN(r.SSIM) {
+				ssim += r.SSIM
+				ssimRings++
+			}
+		}
+
+		bands[b] = ringError(radius/float64(to-from), bins, mse, bias, ssim, ssimRings)
+	}
+	return bands
+}
+
+// ringError returns the error of a ring or band from the sums of its bins:
+// the squared and plain differences over bins, and the SSIM over ssimBins.
+// Without bins the errors are NaN rather than a division by zero.
+func ringError(radius float64, bins int, mse, bias, ssim float64, ssimBins int) RingError {
+	r := RingError{Radius: radius, Bins: bins, RMSE: math.NaN(), Bias: math.NaN(), SSIM: math.NaN()}
+	if bins > 0 {
+		r.RMSE = math.Sqrt(mse / float64(bins))
+		r.Bias = bias / float64(bins)
+	}
+	if ssimBins > 0 {
+		r.SSIM = ssim / float64(ssimBins)
+	}
+	return r
+}
+
+// Compare measures how faithfully result reproduces discImg, the disc
+// raster of the source image as returned by CreateDiscImage. result is a
+// raw, WAV, FLAC or BIN/CUE track, or a gray level PNG written by visualize
+// or preview. Both are resampled into the same polar frame over the written
+// part of the spiral: the source at the positions the converter samples
+// it, the track at the positions its samples are burned to and the PNG by
+// the radius and angle of its pixels. PSNR and SSIM are computed on the
+// polar frame, with sectors wrapping around.
+func (v *TrackVisualizer) Compare(ctx context.Context, discImg image.Image, result string, opts CompareOptions) (*Comparison, error) {
+	if opts.Rings < 16 || opts.Sectors < 16 {
+		return nil, fmt.Errorf("polar frame must have at least 16 rings and 16 sectors")
+	}
+
+	sim := encoder.NewSimulator(encoder.Options{
+		Tr0:      v.tr0,
+		Dtr:      v.dtr,
+		R0:       v.r0,
+		DiscType: v.discType,
+		Palette:  v.palette,
+	}, discImg)
+	tracks := sim.Tracks()
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("the spiral has no tracks")
+	}
+
+	fmt.Printf("Sampling source image into %d rings x %d sectors...\n", opts.Rings, opts.Sectors)
+	stride := max(len(tracks)/opts.Rings/referenceTracksPerRing, 1)
+	ref, err := v.polarTracks(tracks, opts, func(first, last int, p *polarImage) error {
+		return sim.SampleTracks(ctx, first, last, stride, func(t encoder.SampledTrack) error {
+			p.addTrack(t.Radius, len(t.Gray), func(i int) (float64, bool) {
+				return float64(t.Gray[i]), true
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample source image: %w", err)
+	}
+
+	res, err := v.polarResult(ctx, result, tracks, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := compare(ref, res)
+	if math.IsNaN(cmp.PSNR) {
+		return nil, fmt.Errorf("result and source image share no samples")
+	}
+	if opts.Heatmap != "" {
+		fmt.Println("Saving error heatmap...")
+		if err := v.savePNG(v.heatmap(ref, res), opts.Heatmap); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Error heatmap saved to: %s\n", opts.Heatmap)
+	}
+	return cmp, nil
+}
+
+// polarResult resamples a track or preview PNG into the polar frame
+func (v *TrackVisualizer) polarResult(ctx context.Context, result string, tracks []encoder.TrackInfo, opts CompareOptions) (*polarImage, error) {
+	file, err := os.Open(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file stats: %w", err)
+	}
+
+	header := make([]byte, len(pngSignature))
+	if _, err := file.ReadAt(header, 0); err == nil && bytes.Equal(header, pngSignature) {
+		fmt.Println("Detected PNG preview")
+		img, err := png.Decode(io.NewSectionReader(file, 0, stat.Size()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PNG: %w", err)
+		}
+		return v.polarPNG(img, tracks, opts)
+	}
+
+	track, trackSize, cleanup, err := v.openTrack(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	fmt.Printf("Decoding track into %d rings x %d sectors...\n", opts.Rings, opts.Sectors)
+	decoder := v.newDecoder()
+	p, err := v.polarTracks(tracks, opts, func(first, last int, p *polarImage) error {
+		return decoder.DecodeTracks(track, trackSize, first, last, func(t encoder.DecodedTrack) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p.addTrack(t.Radius, len(t.Levels), func(i int) (float64, bool) {
+				if t.Levels[i] == encoder.LevelUnknown {
+					return 0, false
+				}
+				return float64(v.palette.Gray(t.Levels[i])), true
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode track: %w", err)
+	}
+	return p, nil
+}
+
+// polarTracks fills a polar frame from the tracks in parallel. Each worker
+// passes ranges of tracks to add, which fills the polar image it is given.
+func (v *TrackVisualizer) polarTracks(tracks []encoder.TrackInfo, opts CompareOptions, add func(first, last int, p *polarImage) error) (*polarImage, error) {
+	chunks := 8 * v.numWorkers
+	jobs := make(chan int)
+	images := make([]*polarImage, v.numWorkers)
+	errs := make([]error, v.numWorkers)
+
+	var wg sync.WaitGroup
+	for w := range images {
+		images[w] = newPolarImage(tracks, opts.Rings, opts.Sectors)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for c := range jobs {
+				if errs[w] != nil {
+					continue
+				}
+				errs[w] = add(c*len(tracks)/chunks, (c+1)*len(tracks)/chunks, images[w])
+			}
+		}(w)
+	}
+	for c := 0; c < chunks; c++ {
+		jobs <- c
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range images[1:] {
+		images[0].merge(p)
+	}
+	return images[0], nil
+}
+
+// polarPNG resamples a square rendering of the whole disc into the polar
+// frame, every pixel going to the bin its center lies in
+func (v *TrackVisualizer) polarPNG(img image.Image, tracks []encoder.TrackInfo, opts CompareOptions) (*polarImage, error) {
+	b := img.Bounds()
+	if b.Dx() != b.Dy() {
+		return nil, fmt.Errorf("preview must be square, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	n := b.Dx()
+	g := ringGeometry{
+		center: float64(n) / 2,
+		scale:  float64(n) / 2 / discOuterRadius,
+	}
+	p := newPolarImage(tracks, opts.Rings, opts.Sectors)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			dx := float64(x) + 0.5 - g.center
+			dy := float64(y) + 0.5 - g.center
+			if k := p.bin(g.radius(x, y), math.Atan2(dy, dx)); k >= 0 {
+				gray := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+				p.sum[k] += float64(gray.Y)
+				p.count[k]++
+			}
+		}
+	}
+	return p, nil
+}
+
+// polarImage holds the average gray value of the samples falling in each
+// bin of a polar grid over the written part of the spiral. Ring i covers
+// the radii from inner+i*width, sector j the angles from 2*pi*j/sectors,
+// measured like the sample angles of DecodedTrack.
+type polarImage struct {
+	inner   float64
+	width   float64
+	rings   int
+	sectors int
+	sum     []float64
+	count   []uint32
+}
+
+func newPolarImage(tracks []encoder.TrackInfo, rings, sectors int) *polarImage {
+	inner, outer := tracks[0].Radius, tracks[len(tracks)-1].Radius
+	return &polarImage{
+		inner:   inner,
+		width:   max(outer-inner, 1e-3) / float64(rings),
+		rings:   rings,
+		sectors: sectors,
+		sum:     make([]float64, rings*sectors),
+		count:   make([]uint32, rings*sectors),
+	}
+}
+
+// bin returns the index of the bin at radius mm and angle radians, or -1
+// outside of the frame
+func (p *polarImage) bin(radius, angle float64) int {
+	ring := int(math.Floor((radius - p.inner) / p.width))
+	if ring == p.rings && radius-p.inner <= p.width*float64(p.rings) {
+		ring-- // The outermost track
+	}
+	if ring < 0 || ring >= p.rings {
+		return -1
+	}
+	sector := int(math.Floor(angle / (2 * math.Pi) * float64(p.sectors)))
+	sector = (sector%p.sectors + p.sectors) % p.sectors
+	return ring*p.sectors + sector
+}
+
+// addTrack adds the n samples of a track at radius, value returning the
+// gray value of sample i and whether it is known
+func (p *polarImage) addTrack(radius float64, n int, value func(i int) (float64, bool)) {
+	k := p.bin(radius, 0)
+	if k < 0 {
+		return
+	}
+	for i := 0; i < n; i++ {
+		if g, ok := value(i); ok {
+			j := k + i*p.sectors/n
+			p.sum[j] += g
+			p.count[j]++
+		}
+	}
+}
+
+func (p *polarImage) merge(o *polarImage) {
+	for i := range p.sum {
+		p.sum[i] += o.sum[i]
+		p.count[i] += o.count[i]
+	}
+}
+
+// radius returns the center of ring i in mm
+func (p *polarImage) radius(i int) float64 {
+	return p.inner + (float64(i)+0.5)*p.width
+}
+
+// compare computes the error of res against ref over the bins both hold
+// samples in. Rings without such bins are reported with NaN errors, and so
+// are PSNR and SSIM if there are none at all.
+func compare(ref, res *polarImage) *Comparison {
+	n := len(ref.sum)
+	valid := make([]bool, n)
+	a := make([]float64, n)
+	b := make([]float64, n)
+	for i := range valid {
+		if ref.count[i] > 0 && res.count[i] > 0 {
+			valid[i] = true
+			a[i] = ref.sum[i] / float64(ref.count[i])
+			b[i] = res.sum[i] / float64(res.count[i])
+		}
+	}
+	ssim := ssimMap(a, b, valid, ref.rings, ref.sectors)
+
+	cmp := &Comparison{Rings: make([]RingError, ref.rings)}
+	var mse, ssimSum float64
+	var bins, ssimBins int
+	for ring := range cmp.Rings {
+		var ringMSE, ringBias, ringSSIM float64
+		var ringBins, ringSSIMBins int
+		for k := ring * ref.sectors; k < (ring+1)*ref.sectors; k++ {
+			if !valid[k] {
+				continue
+			}
+			d := b[k] - a[k]
+			ringMSE += d * d
+			ringBias += d
+			ringBins++
+			if !math.IsNaN(ssim[k]) {
+				ringSSIM += ssim[k]
+				ringSSIMBins++
+			}
+		}
+
+		mse += ringMSE
+		bins += ringBins
+		ssimSum += ringSSIM
+		ssimBins += ringSSIMBins
+		cmp.Rings[ring] = ringError(ref.radius(ring), ringBins, ringMSE, ringBias, ringSSIM, ringSSIMBins)
+	}
+
+	total := ringError(0, bins, mse, 0, ssimSum, ssimBins)
+	cmp.PSNR = 20 * math.Log10(255/total.RMSE)
+	cmp.SSIM = total.SSIM
+	return cmp
+}
+
+// ssimMap returns the structural similarity of a and b around every bin,
+// using the 11x11 Gaussian window of Wang et al. over the bins valid in
+// both. Sectors wrap around; bins without valid neighbours are NaN.
+func ssimMap(a, b []float64, valid []bool, rings, sectors int) []float64 {
+	const (
+		radius = 5
+		sigma  = 1.5
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+	var weights [2*radius + 1]float64
+	for i := range weights {
+		d := float64(i - radius)
+		weights[i] = math.Exp(-d * d / (2 * sigma * sigma))
+	}
+
+	out := make([]float64, len(a))
+	for ring := 0; ring < rings; ring++ {
+		for sector := 0; sector < sectors; sector++ {
+			k := ring*sectors + sector
+			if !valid[k] {
+				out[k] = math.NaN()
+				continue
+			}
+
+			var w, ma, mb, aa, bb, ab float64
+			for dr := -radius; dr <= radius; dr++ {
+				r := ring + dr
+				if r < 0 || r >= rings {
+					continue
+				}
+				for ds := -radius; ds <= radius; ds++ {
+					j := r*sectors + ((sector+ds)%sectors+sectors)%sectors
+					if !valid[j] {
+						continue
+					}
+					wj := weights[dr+radius] * weights[ds+radius]
+					w += wj
+					ma += wj * a[j]
+					mb += wj * b[j]
+					aa += wj * a[j] * a[j]
+					bb += wj * b[j] * b[j]
+					ab += wj * a[j] * b[j]
+				}
+			}
+
+			ma, mb = ma/w, mb/w
+			va := aa/w - ma*ma
+			vb := bb/w - mb*mb
+			cov := ab/w - ma*mb
+			out[k] = (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+		}
+	}
+	return out
+}
+
+// heatmap draws the error of res against ref on the disc: red where the
+// result is brighter than the source, blue where it is darker, in full
+// color from heatmapRange gray levels on. Bins without samples show the
+// background of the rendering.
+func (v *TrackVisualizer) heatmap(ref, res *polarImage) *image.RGBA {
+	n := v.size
+	img := image.NewRGBA(image.Rect(0, 0, n, n))
+	g := ringGeometry{
+		center: float64(n) / 2,
+		scale:  float64(n) / 2 / discOuterRadius,
+	}
+
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			r := g.radius(x, y)
+			c := color.RGBA{grayOutside, grayOutside, grayOutside, 255}
+			switch {
+			case r < discHoleRadius:
+				c = color.RGBA{grayHole, grayHole, grayHole, 255}
+			case r < discOuterRadius:
+				c = color.RGBA{graySurface, graySurface, graySurface, 255}
+			}
+
+			dx := float64(x) + 0.5 - g.center
+			dy := float64(y) + 0.5 - g.center
+			if k := ref.bin(r, math.Atan2(dy, dx)); k >= 0 && ref.count[k] > 0 && res.count[k] > 0 {
+				d := res.sum[k]/float64(res.count[k]) - ref.sum[k]/float64(ref.count[k])
+				t := min(math.Abs(d)/heatmapRange, 1)
+				fade := uint8(math.Round(255 * (1 - t)))
+				if d > 0 {
+					c = color.RGBA{255, fade, fade, 255}
+				} else {
+					c = color.RGBA{fade, fade, 255, 255}
+				}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	drawLabel(img, []string{
+		"result - source",
+		"red: brighter",
+		"blue: darker",
+		fmt.Sprintf("full at %d levels", heatmapRange),
+	}, max(n/DefaultAnimationSize, 1), labelText, labelBox)
+	return img
+}
@@ -0,0 +1,124 @@
+package preview
+
+import (
+	"math"
+	"testing"
+)
+
+// testPolar returns a polar frame holding gray(ring, sector) in every bin,
+// as the mean of two samples. Bins where gray is NaN hold none.
+func testPolar(rings, sectors int, gray func(ring, sector int) float64) *polarImage {
+	p := &polarImage{
+		inner:   20,
+		width:   0.5,
+		rings:   rings,
+		sectors: sectors,
+		sum:     make([]float64, rings*sectors),
+		count:   make([]uint32, rings*sectors),
+	}
+	for ring := 0; ring < rings; ring++ {
+		for sector := 0; sector < sectors; sector++ {
+			if g := gray(ring, sector); !math.IsNaN(g) {
+				k := ring*sectors + sector
+				p.sum[k] = 2 * g
+				p.count[k] = 2
+			}
+		}
+	}
+	return p
+}
+
+// testGray varies along both axes, sectors wrapping around smoothly
+func testGray(ring, sector int) float64 {
+	return 128 + 60*math.Sin(float64(sector)*2*math.Pi/64) + float64(ring%7)*5
+}
+
+func TestCompareIdentical(t *testing.T) {
+	ref := testPolar(32, 64, testGray)
+	cmp := compare(ref, testPolar(32, 64, testGray))
+	if !math.IsInf(cmp.PSNR, 1) {
+		t.Errorf("PSNR is %g, expected +Inf", cmp.PSNR)
+	}
+	if math.Abs(cmp.SSIM-1) > 1e-9 {
+		t.Errorf("SSIM is %g, expected 1", cmp.SSIM)
+	}
+	for i, r := range cmp.Rings {
+		if r.Bins != 64 || r.RMSE != 0 || r.Bias != 0 || math.Abs(r.SSIM-1) > 1e-9 {
+			t.Errorf("ring %d: %+v", i, r)
+		}
+		if want := 20 + (float64(i)+0.5)*0.5; r.Radius != want {
+			t.Errorf("ring %d at %g mm, expected %g", i, r.Radius, want)
+		}
+	}
+}
+
+func TestCompareOffset(t *testing.T) {
+	const offset = 8
+	ref := testPolar(32, 64, testGray)
+	res := testPolar(32, 64, func(ring, sector int) float64 { return testGray(ring, sector) - offset })
+	cmp := compare(ref, res)
+	if want := 20 * math.Log10(255.0/offset); math.Abs(cmp.PSNR-want) > 1e-9 {
+		t.Errorf("PSNR is %g, expected %g", cmp.PSNR, want)
+	}
+	for i, r := range cmp.Rings {
+		if math.Abs(r.RMSE-offset) > 1e-9 || math.Abs(r.Bias+offset) > 1e-9 {
+			t.Errorf("ring %d: RMSE %g, bias %g", i, r.RMSE, r.Bias)
+		}
+	}
+
+	// On a flat image only the luminance term of SSIM is left
+	const gray = 100
+	flat := func(ring, sector int) float64 { return gray }
+	cmp = compare(testPolar(16, 32, flat), testPolar(16, 32, func(ring, sector int) float64 { return gray + offset }))
+	c1 := (0.01 * 255) * (0.01 * 255)
+	want := (2*gray*(gray+offset) + c1) / (gray*gray + (gray+offset)*(gray+offset) + c1)
+	if math.Abs(cmp.SSIM-want) > 1e-9 {
+		t.Errorf("SSIM is %g, expected %g", cmp.SSIM, want)
+	}
+}
+
+// TestCompareEmptyRings checks that rings and bands without samples in both
+// images are reported as such instead of spoiling the totals
+func TestCompareEmptyRings(t *testing.T) {
+	ref := testPolar(32, 64, func(ring, sector int) float64 {
+		if ring < 8 {
+			return math.NaN()
+		}
+		return testGray(ring, sector)
+	})
+	res := testPolar(32, 64, func(ring, sector int) float64 {
+		if ring == 20 {
+			return math.NaN()
+		}
+		return testGray(ring, sector) + 4
+	})
+	cmp := compare(ref, res)
+	if math.Abs(cmp.PSNR-20*math.Log10(255.0/4)) > 1e-9 || math.IsNaN(cmp.SSIM) {
+		t.Errorf("PSNR %g, SSIM %g", cmp.PSNR, cmp.SSIM)
+	}
+	for i, r := range cmp.Rings {
+		empty := i < 8 || i == 20
+		if empty != (r.Bins == 0) || empty != math.IsNaN(r.RMSE) || empty != math.IsNaN(r.Bias) || empty != math.IsNaN(r.SSIM) {
+			t.Errorf("ring %d: %+v", i, r)
+		}
+	}
+
+	bands := cmp.Bands(8)
+	for i, b := range bands {
+		empty := i < 2
+		if empty != (b.Bins == 0) || empty != math.IsNaN(b.RMSE) || empty != math.IsNaN(b.SSIM) {
+			t.Errorf("band %d: %+v", i, b)
+		}
+		if !empty && math.Abs(b.RMSE-4) > 1e-9 {
+			t.Errorf("band %d: RMSE %g, expected 4", i, b.RMSE)
+		}
+	}
+	if bands[5].Bins != 3*64 {
+		t.Errorf("band 5 has %d bins, expected %d", bands[5].Bins, 3*64)
+	}
+
+	none := compare(ref, testPolar(32, 64, func(ring, sector int) float64 { return math.NaN() }))
+	if !math.IsNaN(none.PSNR) || !math.IsNaN(none.SSIM) {
+		t.Errorf("no common bins: PSNR %g, SSIM %g", none.PSNR, none.SSIM)
+	}
+}
@@ -0,0 +1,204 @@
+package preview
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cdimage/encoder"
+)
+
+// leadInPregap is the pregap of the first track every disc starts with. The
+// spiral starts at its end, where the image data of the converter begins.
+const leadInPregap = 2 * encoder.FramesPerSecond * encoder.SectorSize
+
+// SetCueTracks selects the tracks of a cue sheet that are rendered, by
+// track number starting at 1. Empty selects all audio tracks.
+func (v *TrackVisualizer) SetCueTracks(numbers []int) {
+	v.cueTracks = numbers
+}
+
+// openTrack gives random access to the samples of a raw, WAV or FLAC track,
+// or to the selected tracks of a BIN/CUE image laid out like on the disc.
+// cleanup releases the resources it needed.
+func (v *TrackVisualizer) openTrack(file *os.File, fileSize int64) (io.ReaderAt, int64, func(), error) {
+	if strings.EqualFold(filepath.Ext(file.Name()), ".cue") {
+		return v.openCue(file)
+	}
+	return openAudio(file, fileSize)
+}
+
+// openCue lays out the selected tracks of a cue sheet the way a disc holding
+// only them would, the first one starting the spiral and each following one
+// preceded by its pregap: the silence the burner adds for PREGAP and the
+// data from INDEX 00 on. The pregap of the first track is the lead-in
+// pregap before the spiral, only the part longer than two seconds is on it.
+func (v *TrackVisualizer) openCue(file *os.File) (io.ReaderAt, int64, func(), error) {
+	dir := filepath.Dir(file.Name())
+
+	var files []*os.File
+	var parts []extent
+	var cleanups []func()
+	cleanup := func() {
+		for _, c := range cleanups {
+			c()
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	sheet, err := encoder.ParseCueSheet(file, func(name string) (int64, error) {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return 0, fmt.Errorf("failed to open file of cue sheet: %w", err)
+		}
+		files = append(files, f)
+
+		stat, err := f.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get file stats: %w", err)
+		}
+		r, size, c, err := openAudio(f, stat.Size())
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		cleanups = append(cleanups, c)
+		parts = append(parts, extent{r: r, n: size})
+		return size, nil
+	})
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("failed to read cue sheet: %w", err)
+	}
+
+	selected, err := v.selectCueTracks(sheet)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+
+	data := newConcatReader(parts)
+	fmt.Printf("Detected cue sheet with %d tracks\n", len(sheet.Tracks))
+
+	var layout []extent
+	for k, i := range selected {
+		t := sheet.Tracks[i]
+		end := data.size
+		if i+1 < len(sheet.Tracks) {
+			next := sheet.Tracks[i+1]
+			end = next.Start - next.Pregap
+		}
+
+		silence := t.Silence * encoder.SectorSize
+		from := t.Start - t.Pregap
+		if k == 0 {
+			// Drop the lead-in pregap, filled up with silence if shorter
+			skip := min(silence+t.Pregap, leadInPregap)
+			skipped := min(skip, silence)
+			silence -= skipped
+			from += skip - skipped
+		}
+
+		fmt.Printf("  Track %02d: %s of pregap, %s of data\n", i+1,
+			encoder.MSF((silence+t.Start-from)/encoder.SectorSize), encoder.MSF((end-t.Start)/encoder.SectorSize))
+		if silence > 0 {
+			layout = append(layout, extent{n: silence})
+		}
+		layout = append(layout, extent{r: data, off: from, n: end - from})
+	}
+
+	track := newConcatReader(layout)
+	fmt.Printf("Track data size: %.1f MB\n", float64(track.size)/(1024*1024))
+	return track, track.size, cleanup, nil
+}
+
+// selectCueTracks returns the indexes of the tracks set by SetCueTracks,
+// or of all audio tracks
+func (v *TrackVisualizer) selectCueTracks(sheet *encoder.CueSheet) ([]int, error) {
+	var selected []int
+	if len(v.cueTracks) == 0 {
+		for i, t := range sheet.Tracks {
+			if !t.Data {
+				selected = append(selected, i)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("cue sheet has no audio tracks")
+		}
+		return selected, nil
+	}
+
+	for _, number := range v.cueTracks {
+		if number < 1 || number > len(sheet.Tracks) {
+			return nil, fmt.Errorf("track %d is not in the cue sheet (tracks 1 to %d)", number, len(sheet.Tracks))
+		}
+		if sheet.Tracks[number-1].Data {
+			return nil, fmt.Errorf("track %d is a data track", number)
+		}
+		selected = append(selected, number-1)
+	}
+	sort.Ints(selected)
+	for i := 1; i < len(selected); i++ {
+		if selected[i] == selected[i-1] {
+			return nil, fmt.Errorf("track %d is selected twice", selected[i]+1)
+		}
+	}
+	return selected, nil
+}
+
+// extent is n bytes of r from off on, or n bytes of silence if r is nil
+type extent struct {
+	r   io.ReaderAt
+	off int64
+	n   int64
+}
+
+// concatReader reads extents one after the other
+type concatReader struct {
+	parts  []extent
+	starts []int64 // Offset of each part
+	size   int64
+}
+
+func newConcatReader(parts []extent) *concatReader {
+	c := &concatReader{parts: parts}
+	for _, p := range parts {
+		c.starts = append(c.starts, c.size)
+		c.size += p.n
+	}
+	return c
+}
+
+func (c *concatReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	i := sort.Search(len(c.starts), func(i int) bool { return c.starts[i] > off }) - 1
+
+	done := 0
+	for ; done < len(p) && i >= 0 && i < len(c.parts); i++ {
+		part := c.parts[i]
+		at := off + int64(done) - c.starts[i]
+		n := int(min(int64(len(p)-done), part.n-at))
+		if n <= 0 {
+			continue
+		}
+		if part.r == nil {
+			clear(p[done : done+n])
+		} else if m, err := part.r.ReadAt(p[done:done+n], part.off+at); m < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return done + m, err
+		}
+		done += n
+	}
+	if done < len(p) {
+		return done, io.EOF
+	}
+	return done, nil
+}
@@ -0,0 +1,135 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cdimage/encoder"
+)
+
+// testCueSheet is laid out like the sheet of the ParseCueSheet test: tracks
+// 1 and 4 have a PREGAP, track 2 starts with the INDEX 00 at the end of the
+// first file and track 3 is a data track
+const testCueSheet = `FILE "a.bin" BINARY
+  TRACK 01 AUDIO
+    PREGAP 00:02:00
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:03:00
+FILE "b.bin" BINARY
+    INDEX 01 00:00:00
+  TRACK 03 MODE1/2352
+    INDEX 01 00:02:00
+  TRACK 04 AUDIO
+    PREGAP 00:01:00
+    INDEX 00 00:04:00
+    INDEX 01 00:04:50
+`
+
+// writeTestCue writes testCueSheet with its files to a directory, every
+// sector holding its number counted through both files, and returns the
+// cue file name
+func writeTestCue(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	sector := 0
+	for _, f := range []struct {
+		name    string
+		sectors int
+	}{{"a.bin", 300}, {"b.bin", 400}} {
+		data := make([]byte, f.sectors*encoder.SectorSize)
+		for i := 0; i < len(data); i += 2 {
+			binary.LittleEndian.PutUint16(data[i:], uint16(sector+i/encoder.SectorSize+1))
+		}
+		sector += f.sectors
+		if err := os.WriteFile(filepath.Join(dir, f.name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(dir, "image.cue")
+	if err := os.WriteFile(name, []byte(testCueSheet), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// testSectors returns the data of sectors from to to of writeTestCue
+func testSectors(from, to int) []byte {
+	data := make([]byte, (to-from)*encoder.SectorSize)
+	for i := 0; i < len(data); i += 2 {
+		binary.LittleEndian.PutUint16(data[i:], uint16(from+i/encoder.SectorSize+1))
+	}
+	return data
+}
+
+func TestOpenCue(t *testing.T) {
+	name := writeTestCue(t)
+	silence := make([]byte, encoder.FramesPerSecond*encoder.SectorSize)
+	tests := []struct {
+		tracks []int
+		want   [][]byte
+	}{
+		// The pregap of track 1 is the lead-in, track 4 brings its second of
+		// silence and its INDEX 00 data
+		{nil, [][]byte{testSectors(0, 225), testSectors(225, 450), silence, testSectors(600, 700)}},
+		// Only the part of a pregap longer than the lead-in is on the spiral
+		{[]int{4}, [][]byte{testSectors(650, 700)}},
+		{[]int{4, 2}, [][]byte{testSectors(300, 450), silence, testSectors(600, 700)}},
+	}
+	for _, tt := range tests {
+		v := NewTrackVisualizer(22000, 1.4, 25, "cd")
+		v.SetCueTracks(tt.tracks)
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, size, cleanup, err := v.openTrack(file, 0)
+		if err != nil {
+			t.Fatalf("tracks %v: %v", tt.tracks, err)
+		}
+		want := bytes.Join(tt.want, nil)
+		got := make([]byte, size)
+		if _, err := r.ReadAt(got, 0); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("tracks %v: %d bytes laid out differently from the expected %d", tt.tracks, size, len(want))
+		}
+		cleanup()
+		file.Close()
+	}
+}
+
+func TestOpenCueTrackSelection(t *testing.T) {
+	name := writeTestCue(t)
+	tests := []struct {
+		tracks []int
+		err    string
+	}{
+		{[]int{3}, "track 3 is a data track"},
+		{[]int{5}, "track 5 is not in the cue sheet"},
+		{[]int{0}, "track 0 is not in the cue sheet"},
+		{[]int{2, 1, 2}, "track 2 is selected twice"},
+	}
+	for _, tt := range tests {
+		v := NewTrackVisualizer(22000, 1.4, 25, "cd")
+		v.SetCueTracks(tt.tracks)
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, cleanup, err := v.openTrack(file, 0)
+		if err == nil {
+			cleanup()
+			t.Errorf("tracks %v accepted", tt.tracks)
+		} else if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("tracks %v: got %v, expected %q", tt.tracks, err, tt.err)
+		}
+		file.Close()
+	}
+}
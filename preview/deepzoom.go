@@ -0,0 +1,218 @@
+package preview
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Layout of the Deep Zoom image pyramid
+const (
+	deepZoomTileSize = 256
+	deepZoomOverlap  = 0
+	deepZoomFormat   = "png"
+)
+
+//go:embed deepzoom.html
+var deepZoomViewer string
+
+var deepZoomTemplate = template.Must(template.New("viewer").Parse(deepZoomViewer))
+
+// deepZoomData is handed to the viewer as JSON. The track table comes from
+// the same spiral model as the rendering, so the readout under the cursor
+// names the track and sample the image shows there.
+type deepZoomData struct {
+	Size     int     `json:"size"`
+	TileSize int     `json:"tileSize"`
+	MaxLevel int     `json:"maxLevel"`
+	Tiles    string  `json:"tiles"`
+	Scale    float64 `json:"scale"` // Pixels per mm at the full resolution
+	DiscType string  `json:"discType"`
+	Tr0      float64 `json:"tr0"`
+	Dtr      float64 `json:"dtr"`
+	R0       float64 `json:"r0"`
+
+	// One entry per spiral track
+	Radius  []float64 `json:"radius"`
+	Offset  []int64   `json:"offset"`
+	Samples []int     `json:"samples"`
+}
+
+// VisualizeDeepZoom renders a raw, WAV, FLAC or BIN/CUE track like
+// VisualizeTrack and writes it as a tiled image pyramid with an offline HTML
+// viewer.
+// For viewer.html the tiles go to viewer_files/<level>/<column>_<row>.png
+// in the Deep Zoom layout, described by viewer.dzi for other viewers.
+func (v *TrackVisualizer) VisualizeDeepZoom(trackFile, outputHTML string) error {
+	img, err := v.renderFile(trackFile)
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(outputHTML, filepath.Ext(outputHTML))
+	tilesDir := base + "_files"
+
+	fmt.Println("Writing image pyramid...")
+	maxLevel, err := v.writePyramid(img, tilesDir)
+	if err != nil {
+		return err
+	}
+
+	if err := writeDZI(base+".dzi", v.size); err != nil {
+		return err
+	}
+
+	data := deepZoomData{
+		Size:     v.size,
+		TileSize: deepZoomTileSize,
+		MaxLevel: maxLevel,
+		Tiles:    filepath.Base(tilesDir),
+		Scale:    float64(v.size) / 2 / discOuterRadius,
+		DiscType: v.discType,
+		Tr0:      v.tr0,
+		Dtr:      v.dtr,
+		R0:       v.r0,
+	}
+	for _, t := range v.newDecoder().Tracks() {
+		data.Radius = append(data.Radius, math.Round(t.Radius*1e6)/1e6)
+		data.Offset = append(data.Offset, t.Offset)
+		data.Samples = append(data.Samples, t.Samples)
+	}
+	if err := writeViewer(outputHTML, filepath.Base(base), data); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deep zoom viewer saved to: %s\n", outputHTML)
+	return nil
+}
+
+// writePyramid writes every level of the pyramid, from the full image at
+// the highest level down to a single pixel at level 0, and returns the
+// highest level
+func (v *TrackVisualizer) writePyramid(img *image.Gray, dir string) (int, error) {
+	maxLevel := bits.Len(uint(v.size - 1))
+
+	level := img
+	for l := maxLevel; l >= 0; l-- {
+		if err := v.writeTiles(level, filepath.Join(dir, strconv.Itoa(l))); err != nil {
+			return 0, err
+		}
+		fmt.Printf("\rWrote level %d of %d", maxLevel-l+1, maxLevel+1)
+		if l > 0 {
+			level = downsample(level)
+		}
+	}
+	fmt.Println()
+	return maxLevel, nil
+}
+
+// writeTiles cuts one level of the pyramid into tiles, encoding them in
+// parallel
+func (v *TrackVisualizer) writeTiles(img *image.Gray, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create tile directory: %w", err)
+	}
+
+	bounds := img.Bounds()
+	cols := (bounds.Dx() + deepZoomTileSize - 1) / deepZoomTileSize
+	rows := (bounds.Dy() + deepZoomTileSize - 1) / deepZoomTileSize
+
+	jobs := make(chan image.Point)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < v.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range jobs {
+				rect := image.Rect(0, 0, deepZoomTileSize, deepZoomTileSize).
+					Add(tile.Mul(deepZoomTileSize)).Intersect(bounds)
+				name := filepath.Join(dir, fmt.Sprintf("%d_%d.%s", tile.X, tile.Y, deepZoomFormat))
+				if err := v.savePNG(img.SubImage(rect), name); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			jobs <- image.Pt(col, row)
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
+// downsample halves an image, averaging each 2x2 block. At odd edges the
+// block is cut short, as the Deep Zoom level sizes are rounded up.
+func downsample(img *image.Gray) *image.Gray {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	out := image.NewGray(image.Rect(0, 0, (w+1)/2, (h+1)/2))
+
+	for y := 0; y < out.Rect.Dy(); y++ {
+		y0, y1 := 2*y, min(2*y+1, h-1)
+		for x := 0; x < out.Rect.Dx(); x++ {
+			x0, x1 := 2*x, min(2*x+1, w-1)
+			sum := uint(img.Pix[y0*img.Stride+x0]) + uint(img.Pix[y0*img.Stride+x1]) +
+				uint(img.Pix[y1*img.Stride+x0]) + uint(img.Pix[y1*img.Stride+x1])
+			out.Pix[y*out.Stride+x] = uint8((sum + 2) / 4)
+		}
+	}
+	return out
+}
+
+// writeDZI writes the Deep Zoom descriptor of the pyramid
+func writeDZI(fileName string, size int) error {
+	dzi := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" TileSize="%d" Overlap="%d" Format="%s">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, deepZoomTileSize, deepZoomOverlap, deepZoomFormat, size, size)
+
+	if err := os.WriteFile(fileName, []byte(dzi), 0644); err != nil {
+		return fmt.Errorf("failed to write DZI descriptor: %w", err)
+	}
+	return nil
+}
+
+// writeViewer writes the HTML viewer with the pyramid layout and track
+// table embedded
+func writeViewer(fileName, title string, data deepZoomData) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode viewer data: %w", err)
+	}
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create viewer: %w", err)
+	}
+	defer file.Close()
+
+	err = deepZoomTemplate.Execute(file, struct {
+		Title string
+		Data  string
+	}{title, string(js)})
+	if err != nil {
+		return fmt.Errorf("failed to write viewer: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,294 @@
+package preview
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"sync"
+
+	"cdimage/encoder"
+)
+
+// Light defaults of the photorealistic rendering
+const (
+	DefaultLightAzimuth   = 45.0
+	DefaultLightElevation = 60.0
+)
+
+// Extent of the recording layer in mm. The disc is clear polycarbonate
+// inside and outside of it.
+const (
+	layerInnerRadius = 21.0
+	layerOuterRadius = 59.0
+)
+
+// Diffraction of the pregroove
+const (
+	cdTrackPitch    = 1.6   // µm
+	dvdTrackPitch   = 0.74  // µm
+	viewerDistance  = 300.0 // mm above the disc center
+	lightSpread     = 0.08  // Angular size of the light, widens the rainbow
+	rainbowStrength = 0.7
+)
+
+// clearPlastic is the color of the polycarbonate without recording layer
+var clearPlastic = [3]float64{52, 54, 58}
+
+// Dye describes the color of a recording layer. Written samples take the
+// color of the gray value of their palette level; a gray value between the
+// colors mixes its neighbours.
+type Dye struct {
+	Name   string
+	Levels [4]color.RGBA // Gray 0 (darkest palette level), 85, 170 and 255 (brightest)
+	Blank  color.RGBA    // Unwritten layer
+}
+
+// dyes are the recording layers Shade knows, by the name used on the
+// command line
+var dyes = map[string]Dye{
+	"cyanine": {
+		Name: "Cyanine (blue-green)",
+		Levels: [4]color.RGBA{
+			{24, 44, 88, 255},
+			{42, 86, 138, 255},
+			{64, 128, 176, 255},
+			{92, 168, 205, 255},
+		},
+		Blank: color.RGBA{96, 172, 208, 255},
+	},
+	"phthalocyanine": {
+		Name: "Phthalocyanine (gold)",
+		Levels: [4]color.RGBA{
+			{104, 86, 48, 255},
+			{150, 128, 76, 255},
+			{192, 170, 108, 255},
+			{228, 208, 142, 255},
+		},
+		Blank: color.RGBA{232, 212, 146, 255},
+	},
+	"azo": {
+		Name: "Metal azo (deep blue)",
+		Levels: [4]color.RGBA{
+			{14, 18, 64, 255},
+			{26, 38, 112, 255},
+			{40, 62, 152, 255},
+			{56, 86, 186, 255},
+		},
+		Blank: color.RGBA{58, 90, 190, 255},
+	},
+	"rw": {
+		Name: "RW phase change (silver)",
+		Levels: [4]color.RGBA{
+			{92, 94, 98, 255},
+			{122, 124, 128, 255},
+			{152, 154, 158, 255},
+			{182, 184, 188, 255},
+		},
+		Blank: color.RGBA{186, 188, 192, 255},
+	},
+}
+
+// DyeNames returns the names of the known dyes in alphabetical order
+func DyeNames() []string {
+	names := make([]string, 0, len(dyes))
+	for name := range dyes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DyeByName returns the dye with the given name
+func DyeByName(name string) (Dye, bool) {
+	dye, ok := dyes[name]
+	return dye, ok
+}
+
+// Lighting is a distant light above the disc, seen from straight above
+type Lighting struct {
+	Azimuth   float64 // Direction of the light in degrees, counterclockwise from the right edge of the image
+	Elevation float64 // Angle of the light above the disc surface in degrees, 90 for straight above
+}
+
+// Appearance selects how Shade colors a rendering
+type Appearance struct {
+	Dye   Dye
+	Light Lighting
+}
+
+// SetAppearance makes VisualizeTrack and PreviewImage color their output
+// with look instead of drawing gray levels; nil restores the gray levels
+func (v *TrackVisualizer) SetAppearance(look *Appearance) {
+	v.appearance = look
+}
+
+// Shade turns a gray level rendering of the spiral laid out as tracks, as
+// returned by Render or RenderSimulation, into a picture of the disc. The
+// recording layer takes the dye color of the palette levels, lit from the
+// side of the light and overlaid with the rainbow the pregroove diffracts
+// towards a viewer 30 cm above the disc. The hole and the area outside the
+// disc are transparent.
+func (v *TrackVisualizer) Shade(img *image.Gray, tracks []encoder.TrackInfo, look Appearance) *image.RGBA {
+	n := img.Rect.Dx()
+	out := image.NewRGBA(img.Rect)
+	g := ringGeometry{
+		center: float64(n) / 2,
+		scale:  float64(n) / 2 / discOuterRadius,
+	}
+	g.pixel = 1 / g.scale
+
+	// Pixels whose center lies on the spiral show the written levels
+	written := func(float64) bool { return false }
+	if len(tracks) > 0 {
+		inner := tracks[0].Radius - g.pixel/2
+		outer := tracks[len(tracks)-1].Radius + g.pixel/2
+		written = func(r float64) bool { return r >= inner && r < outer }
+	}
+
+	pitch := cdTrackPitch
+	if v.discType == "dvd" {
+		pitch = dvdTrackPitch
+	}
+	azimuth := look.Light.Azimuth * math.Pi / 180
+	elevation := look.Light.Elevation * math.Pi / 180
+	lx, ly := math.Cos(azimuth), math.Sin(azimuth)
+	horizontal := math.Cos(elevation)
+
+	reference := luminance(rgb(look.Dye.Levels[3]))
+	rows := make(chan int, n)
+	for y := 0; y < n; y++ {
+		rows <- y
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for w := 0; w < v.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				for x := 0; x < n; x++ {
+					r := g.radius(x, y)
+
+					// Edges of the disc are antialiased
+					alpha := min(max((discOuterRadius-r)/g.pixel+0.5, 0), 1)
+					alpha = min(alpha, min(max((r-discHoleRadius)/g.pixel+0.5, 0), 1))
+					if alpha == 0 {
+						continue
+					}
+
+					c := clearPlastic
+					if r >= layerInnerRadius && r < layerOuterRadius {
+						c = rgb(look.Dye.Blank)
+						if written(r) {
+							c = levelColor(look.Dye, float64(img.Pix[y*img.Stride+x])/85)
+						}
+
+						// Only the recording layer reflects the light
+						ux := (float64(x) + 0.5 - g.center) / (r * g.scale)
+						uy := (g.center - float64(y) - 0.5) / (r * g.scale)
+						along := horizontal * (ux*lx + uy*ly)
+						across := horizontal * (ux*ly - uy*lx)
+
+						shade := 0.8 + 0.25*along
+						band := diffraction(along-r/math.Hypot(r, viewerDistance), across, pitch)
+						reflectance := min(luminance(c)/reference, 1)
+						for i := range c {
+							c[i] = c[i]*shade + 255*rainbowStrength*reflectance*band[i]
+						}
+					}
+
+					i := y*out.Stride + x*4
+					for j := range c {
+						out.Pix[i+j] = uint8(min(max(c[j], 0), 255)*alpha + 0.5)
+					}
+					out.Pix[i+3] = uint8(255*alpha + 0.5)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// ShadeBlank pictures the disc before anything is written to it
+func (v *TrackVisualizer) ShadeBlank(look Appearance) *image.RGBA {
+	img := image.NewGray(image.Rect(0, 0, v.size, v.size))
+	return v.Shade(img, nil, look)
+}
+
+// diffraction returns the color the pregroove of the given pitch in µm
+// sends to the viewer. along is the sum of the radial components of the
+// directions towards the light and towards the viewer, across the
+// tangential mismatch of the light, which the concentric grooves cannot
+// diffract away.
+func diffraction(along, across, pitch float64) [3]float64 {
+	weight := math.Exp(-across * across / (2 * lightSpread * lightSpread))
+	if weight < 1e-3 {
+		return [3]float64{}
+	}
+
+	// Grating equation, wavelength = |along|*pitch/m for order m
+	var c [3]float64
+	for m := 1; m <= 3; m++ {
+		wavelength := math.Abs(along) * pitch * 1000 / float64(m)
+		s := spectralColor(wavelength)
+		for i := range c {
+			c[i] += s[i] * weight / float64(m)
+		}
+	}
+	return c
+}
+
+// spectralColor approximates the color of light of a wavelength in nm,
+// with components from 0 to 1, fading out at the ends of the visible range
+func spectralColor(wavelength float64) [3]float64 {
+	var r, g, b float64
+	switch {
+	case wavelength < 380 || wavelength > 750:
+		return [3]float64{}
+	case wavelength < 440:
+		r, b = (440-wavelength)/60, 1
+	case wavelength < 490:
+		g, b = (wavelength-440)/50, 1
+	case wavelength < 510:
+		g, b = 1, (510-wavelength)/20
+	case wavelength < 580:
+		r, g = (wavelength-510)/70, 1
+	case wavelength < 645:
+		r, g = 1, (645-wavelength)/65
+	default:
+		r = 1
+	}
+
+	fade := 1.0
+	switch {
+	case wavelength < 420:
+		fade = 0.3 + 0.7*(wavelength-380)/40
+	case wavelength > 700:
+		fade = 0.3 + 0.7*(750-wavelength)/50
+	}
+	return [3]float64{r * fade, g * fade, b * fade}
+}
+
+// levelColor mixes the dye colors around level, a gray value divided by 85
+func levelColor(dye Dye, level float64) [3]float64 {
+	level = min(max(level, 0), 3)
+	i := min(int(level), 2)
+	f := level - float64(i)
+	a, b := rgb(dye.Levels[i]), rgb(dye.Levels[i+1])
+	return [3]float64{
+		a[0] + (b[0]-a[0])*f,
+		a[1] + (b[1]-a[1])*f,
+		a[2] + (b[2]-a[2])*f,
+	}
+}
+
+func rgb(c color.RGBA) [3]float64 {
+	return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+}
+
+func luminance(c [3]float64) float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
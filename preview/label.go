@@ -0,0 +1,70 @@
+package preview
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// labelPad is the space between the text and the edge of its box
+const labelPad = 3
+
+// drawLabel writes lines of text on a dark box in the top left corner of
+// img, with the 7x13 pixel font enlarged by scale. The box fits the longest
+// line, so labels of equal width cover each other in animations.
+func drawLabel(img draw.Image, lines []string, scale int, fg, bg color.Color) {
+	margin := 8 * scale
+	drawText(img, img.Bounds().Min.Add(image.Pt(margin, margin)), lines, scale, fg, bg)
+}
+
+// labelSize returns the size of the box drawText draws for lines
+func labelSize(lines []string, scale int) image.Point {
+	face := basicfont.Face7x13
+	cols := 0
+	for _, line := range lines {
+		cols = max(cols, utf8.RuneCountInString(line))
+	}
+	return image.Pt(cols*face.Advance+2*labelPad, len(lines)*face.Metrics().Height.Ceil()+2*labelPad).Mul(scale)
+}
+
+// drawText writes lines of text on a box with its top left corner at p,
+// like drawLabel. A nil bg leaves the box transparent.
+func drawText(img draw.Image, p image.Point, lines []string, scale int, fg, bg color.Color) {
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+	ascent := face.Metrics().Ascent.Ceil()
+
+	size := labelSize(lines, 1)
+	mask := image.NewAlpha(image.Rectangle{Max: size})
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.P(labelPad, labelPad+i*lineHeight+ascent)
+		d.DrawString(line)
+	}
+
+	bounds := img.Bounds()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			c := bg
+			if mask.AlphaAt(x, y).A >= 0x80 {
+				c = fg
+			}
+			if c == nil {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					q := image.Pt(p.X+x*scale+dx, p.Y+y*scale+dy)
+					if q.In(bounds) {
+						img.Set(q.X, q.Y, c)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"cdimage/encoder"
+)
+
+// Tracks averaged per pixel when the sample density is chosen automatically.
+// The ordered dither pattern repeats every 17 tracks, so this is enough to
+// average it out along with the 5 dither columns along the track; the
+// threshold maps of the other engines average out over the samples.
+const simulatedTracksPerPixel = 8
+
+// PreviewImage predicts how an image will look on disc without converting
+// it. discImg is the image given to Convert; it is sampled, dithered and
+// quantised along the spiral like Convert does and the result is drawn like
+// VisualizeTrack draws a converted track. render selects the dithering
+// engine, seed, screen, image sampling and placement like for Convert; the
+// geometry and the palette are those of the visualizer.
+//
+// Only every stride-th track is simulated, stride being 1/density, or
+// chosen from the image size when density is 0. Sequential dithering
+// engines still dither every track.
+func (v *TrackVisualizer) PreviewImage(ctx context.Context, discImg image.Image, render encoder.Options, density float64, outputImage string) error {
+	if density < 0 || density > 1 {
+		return fmt.Errorf("sample density must be between 0 and 1")
+	}
+
+	sim := encoder.NewSimulator(encoder.Options{
+		Tr0:        v.tr0,
+		Dtr:        v.dtr,
+		R0:         v.r0,
+		Dither:     render.Dither,
+		Seed:       render.Seed,
+		Screen:     render.Screen,
+		Palette:    v.palette,
+		Sampling:   render.Sampling,
+		AreaFilter: render.AreaFilter,
+		Placement:  render.Placement,
+		DiscType:   v.discType,
+	}, discImg)
+
+	stride := v.simulationStride(sim.Tracks(), density)
+	tracks := len(sim.Tracks())
+	fmt.Printf("Simulating %d of %d tracks (1 in %d) at %dx%d with %d workers...\n",
+		(tracks+stride-1)/stride, tracks, stride, v.size, v.size, v.numWorkers)
+
+	img, err := v.RenderSimulation(ctx, sim, stride)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Saving preview...")
+	if err := v.savePNG(v.output(img, sim.Tracks()), outputImage); err != nil {
+		return err
+	}
+
+	fmt.Printf("Disc preview saved to: %s\n", outputImage)
+	return nil
+}
+
+// RenderSimulation draws the tracks sim predicts, simulating every
+// stride-th track
+func (v *TrackVisualizer) RenderSimulation(ctx context.Context, sim *encoder.Simulator, stride int) (*image.Gray, error) {
+	img, err := v.renderTracks(sim.Tracks(), func(first, last int, fn func(encoder.DecodedTrack) error) error {
+		return sim.SimulateTracks(ctx, first, last, stride, fn)
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("simulation failed: %w", err)
+	}
+	return img, nil
+}
+
+// simulationStride converts a sample density into a track stride. The
+// stride is odd and never a multiple of the 17 track period of the ordered
+// pattern, so the simulated tracks still cover every row of the dither
+// patterns, whose other periods are powers of two.
+func (v *TrackVisualizer) simulationStride(tracks []encoder.TrackInfo, density float64) int {
+	stride := 1
+	switch {
+	case density > 0:
+		stride = int(math.Round(1 / density))
+	case len(tracks) > 1:
+		pitch := (tracks[len(tracks)-1].Radius - tracks[0].Radius) / float64(len(tracks)-1)
+		pixel := discOuterRadius * 2 / float64(v.size)
+		stride = int(pixel / pitch / simulatedTracksPerPixel)
+	}
+
+	stride = max(stride, 1)
+	if stride%2 == 0 {
+		stride++
+	}
+	if stride%17 == 0 {
+		stride += 2
+	}
+	return stride
+}
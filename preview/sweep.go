@@ -0,0 +1,154 @@
+package preview
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"cdimage/encoder"
+)
+
+// Contact sheet layout
+const (
+	DefaultSweepCellSize = 400
+	MaxSweepSteps        = 16
+)
+
+// Sweep is a range of evenly spaced values of a geometry parameter
+type Sweep struct {
+	From  float64
+	To    float64
+	Steps int
+}
+
+// ParseSweep parses a sweep written as from:to:steps
+func ParseSweep(s string) (Sweep, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Sweep{}, fmt.Errorf("sweep '%s' must be from:to:steps", s)
+	}
+	from, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return Sweep{}, fmt.Errorf("invalid sweep start '%s'", parts[0])
+	}
+	to, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Sweep{}, fmt.Errorf("invalid sweep end '%s'", parts[1])
+	}
+	steps, err := strconv.Atoi(parts[2])
+	if err != nil || steps < 1 || steps > MaxSweepSteps {
+		return Sweep{}, fmt.Errorf("sweep steps must be between 1 and %d", MaxSweepSteps)
+	}
+	if from <= 0 || to <= 0 {
+		return Sweep{}, fmt.Errorf("sweep values must be > 0")
+	}
+	return Sweep{From: from, To: to, Steps: steps}, nil
+}
+
+// Values returns the values of the sweep, from From to To inclusive
+func (s Sweep) Values() []float64 {
+	if s.Steps <= 1 {
+		return []float64{s.From}
+	}
+	values := make([]float64, s.Steps)
+	for i := range values {
+		values[i] = s.From + (s.To-s.From)*float64(i)/float64(s.Steps-1)
+	}
+	return values
+}
+
+// VisualizeSweep renders a raw, WAV, FLAC or BIN/CUE track once for every
+// combination of the tr0 and dtr values of the sweeps and lays the
+// renderings out as a contact sheet, tr0 increasing to the right and dtr
+// downward, each cell labelled with its parameters. Cells are as large as
+// set by SetSize. The cell whose geometry matches the one the track was
+// converted with shows the picture; the others show it sheared or twisted,
+// like a disc burned with the wrong geometry.
+//
+// A cell only decodes as many tracks as it has pixels to fill, like
+// PreviewImage simulates them, so large sweeps take seconds per cell.
+func (v *TrackVisualizer) VisualizeSweep(trackFile, outputImage string, tr0, dtr Sweep) error {
+	tr0s, dtrs := tr0.Values(), dtr.Values()
+	cell := v.size
+	gap := max(cell/50, 2)
+	width := len(tr0s)*cell + (len(tr0s)+1)*gap
+	height := len(dtrs)*cell + (len(dtrs)+1)*gap
+	if max(width, height) > MaxSize {
+		return fmt.Errorf("contact sheet of %dx%d pixels exceeds %d, reduce the cell size or the steps", width, height, MaxSize)
+	}
+
+	file, err := os.Open(trackFile)
+	if err != nil {
+		return fmt.Errorf("failed to open track file: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file stats: %w", err)
+	}
+
+	fmt.Printf("Track file size: %.1f MB\n", float64(stat.Size())/(1024*1024))
+
+	track, trackSize, cleanup, err := v.openTrack(file, stat.Size())
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	sheet := image.NewGray(image.Rect(0, 0, width, height))
+	scale := max(cell/DefaultAnimationSize, 1)
+	for row, d := range dtrs {
+		for col, t := range tr0s {
+			fmt.Printf("Cell %d of %d: tr0 %.2f, dtr %.6f\n", row*len(tr0s)+col+1, len(tr0s)*len(dtrs), t, d)
+
+			cv := *v
+			cv.tr0, cv.dtr = t, d
+			img, err := cv.renderSampled(track, trackSize)
+			if err != nil {
+				return err
+			}
+
+			x := gap + col*(cell+gap)
+			y := gap + row*(cell+gap)
+			r := image.Rect(x, y, x+cell, y+cell)
+			draw.Draw(sheet, r, img, image.Point{}, draw.Src)
+			drawLabel(sheet.SubImage(r).(*image.Gray), []string{
+				fmt.Sprintf("tr0 = %.2f", t),
+				fmt.Sprintf("dtr = %.6f", d),
+			}, scale, labelText, labelBox)
+		}
+	}
+
+	fmt.Println("Saving contact sheet...")
+	if err := v.savePNG(sheet, outputImage); err != nil {
+		return err
+	}
+
+	fmt.Printf("Contact sheet saved to: %s\n", outputImage)
+	return nil
+}
+
+// renderSampled is Render decoding only enough tracks to average the
+// dither pattern in every pixel. Each track is de-interleaved on its own.
+func (v *TrackVisualizer) renderSampled(r io.ReaderAt, size int64) (*image.Gray, error) {
+	decoder := v.newDecoder()
+	tracks := decoder.Tracks()
+	stride := v.simulationStride(tracks, 0)
+	img, err := v.renderTracks(tracks, func(first, last int, fn func(encoder.DecodedTrack) error) error {
+		for i := (first + stride - 1) / stride * stride; i < last; i += stride {
+			if err := decoder.DecodeTracks(r, size, i, i+1, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode track: %w", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,528 @@
+// Package preview renders raw tracks as images of the disc surface.
+package preview
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"cdimage/encoder"
+)
+
+// Disc geometry of the rendering in mm
+const (
+	discOuterRadius = 60.0 // Edge of a 120 mm disc
+	discHoleRadius  = 7.5  // Center hole
+)
+
+// Image sizes accepted by SetSize
+const (
+	DefaultSize         = 1500
+	DefaultDeepZoomSize = 8192
+	MinSize             = 64
+	MaxSize             = 16384
+)
+
+// Output formats of the visualize command
+const (
+	FormatPNG      = "png"
+	FormatDeepZoom = "deepzoom"
+	FormatGIF      = "gif"  // Animated burn progress
+	FormatAPNG     = "apng" // Animated burn progress
+)
+
+// Gray levels of the parts of the disc without samples
+const (
+	grayHole    = 0
+	grayOutside = 20
+	graySurface = 40
+)
+
+// TrackVisualizer creates a visual representation of how the track will appear on disc
+type TrackVisualizer struct {
+	tr0        float64
+	dtr        float64
+	r0         float64
+	discType   string
+	size       int
+	numWorkers int
+	palette    *encoder.Palette // Bytes the track was written with
+	appearance *Appearance      // Colors of the PNG output, nil for gray levels
+	overlay    *Overlay         // Annotations of the PNG output, nil for none
+	cueTracks  []int            // Tracks of a cue sheet to render, empty for all
+}
+
+// NewTrackVisualizer creates a new track visualizer
+func NewTrackVisualizer(tr0, dtr, r0 float64, discType string) *TrackVisualizer {
+	return &TrackVisualizer{
+		tr0:        tr0,
+		dtr:        dtr,
+		r0:         r0,
+		discType:   discType,
+		size:       DefaultSize,
+		numWorkers: runtime.NumCPU(),
+		palette:    encoder.DefaultPalette,
+	}
+}
+
+// SetSize sets the width and height of the rendered image in pixels
+func (v *TrackVisualizer) SetSize(size int) error {
+	if size < MinSize || size > MaxSize {
+		return fmt.Errorf("image size must be between %d and %d pixels", MinSize, MaxSize)
+	}
+	v.size = size
+	return nil
+}
+
+// SetPalette sets the palette the track was converted with; nil restores
+// DefaultPalette. Samples are drawn with the gray value of their level.
+func (v *TrackVisualizer) SetPalette(p *encoder.Palette) {
+	if p == nil {
+		p = encoder.DefaultPalette
+	}
+	v.palette = p
+}
+
+// SetNumWorkers sets the number of rendering goroutines
+func (v *TrackVisualizer) SetNumWorkers(numWorkers int) {
+	if numWorkers > 0 {
+		v.numWorkers = numWorkers
+	}
+}
+
+// VisualizeTrack reads a raw, WAV, FLAC or BIN/CUE track, decodes the palette level
+// of every sample and draws it at its position on the disc
+func (v *TrackVisualizer) VisualizeTrack(trackFile, outputImage string) error {
+	img, err := v.renderFile(trackFile)
+	if err != nil {
+		return err
+	}
+
+	// Save the visualization
+	fmt.Println("Saving visualization...")
+	if err := v.savePNG(v.output(img, v.newDecoder().Tracks()), outputImage); err != nil {
+		return err
+	}
+
+	fmt.Printf("Disc visualization saved to: %s\n", outputImage)
+	return nil
+}
+
+// renderFile opens a raw, WAV, FLAC or BIN/CUE track and renders it
+func (v *TrackVisualizer) renderFile(trackFile string) (*image.Gray, error) {
+	// Open the track file
+	file, err := os.Open(trackFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open track file: %w", err)
+	}
+	defer file.Close()
+
+	// Get file size
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file stats: %w", err)
+	}
+
+	fmt.Printf("Track file size: %.1f MB\n", float64(stat.Size())/(1024*1024))
+
+	track, trackSize, cleanup, err := v.openTrack(file, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	fmt.Printf("Rendering %dx%d image with %d workers...\n", v.size, v.size, v.numWorkers)
+	return v.Render(track, trackSize)
+}
+
+// output colors and annotates img for saving as set by SetAppearance and
+// SetOverlay
+func (v *TrackVisualizer) output(img *image.Gray, tracks []encoder.TrackInfo) image.Image {
+	var out image.Image = img
+	if v.appearance != nil {
+		fmt.Printf("Shading with %s dye...\n", v.appearance.Dye.Name)
+		out = v.Shade(img, tracks, *v.appearance)
+	}
+	if v.overlay != nil {
+		out = v.Annotate(out, tracks, *v.overlay)
+	}
+	return out
+}
+
+// savePNG writes img to a PNG file
+func (v *TrackVisualizer) savePNG(img image.Image, fileName string) error {
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to create output image: %w", err)
+	}
+	defer outFile.Close()
+
+	// Large images take longer to compress than to render
+	enc := png.Encoder{}
+	if max(img.Bounds().Dx(), img.Bounds().Dy()) > 4096 {
+		enc.CompressionLevel = png.BestSpeed
+	}
+	if err := enc.Encode(outFile, img); err != nil {
+		return fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("failed to write PNG: %w", err)
+	}
+	return nil
+}
+
+// openAudio gives random access to the samples of a raw, WAV or FLAC track.
+// FLAC has no random access, so it is decoded to a temporary file first;
+// cleanup removes it.
+func openAudio(file *os.File, fileSize int64) (io.ReaderAt, int64, func(), error) {
+	noop := func() {}
+
+	fr, err := encoder.NewFLACReader(io.NewSectionReader(file, 0, fileSize))
+	switch {
+	case err == nil:
+		fmt.Println("Detected FLAC file, decoding to a temporary file...")
+		tmp, err := os.CreateTemp("", "cdimage-*.raw")
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		cleanup := func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+		n, err := io.Copy(tmp, fr)
+		if err != nil {
+			cleanup()
+			return nil, 0, nil, fmt.Errorf("failed to decode FLAC file: %w", err)
+		}
+		return tmp, n, cleanup, nil
+	case !errors.Is(err, encoder.ErrNotFLAC):
+		return nil, 0, nil, fmt.Errorf("failed to read FLAC file: %w", err)
+	}
+
+	// Skip the container header of WAV files
+	offset, size, err := encoder.FindWAVData(io.NewSectionReader(file, 0, fileSize))
+	switch {
+	case err == nil:
+		fmt.Println("Detected WAV file")
+		if offset+size > fileSize {
+			size = fileSize - offset
+		}
+		return io.NewSectionReader(file, offset, size), size, noop, nil
+	case !errors.Is(err, encoder.ErrNotWAV):
+		return nil, 0, nil, fmt.Errorf("failed to read WAV file: %w", err)
+	}
+
+	return file, fileSize, noop, nil
+}
+
+// Render decodes the raw track read from r, size bytes long, and draws
+// every sample at its radius and angle with the brightness of its palette
+// level, in the same orientation as the source image. A pixel shows the
+// average level of the samples that fall on it.
+//
+// The disc is split into rings that are rendered in parallel. Each ring
+// only reads the part of the track its samples were interleaved into, so
+// memory use depends on the image size but not on the length of the track.
+func (v *TrackVisualizer) Render(r io.ReaderAt, size int64) (*image.Gray, error) {
+	return v.render(r, size, nil)
+}
+
+// render is Render that also records in burned, if not nil, the first
+// sector of the track written to each pixel, or neverBurned
+func (v *TrackVisualizer) render(r io.ReaderAt, size int64, burned []uint32) (*image.Gray, error) {
+	decoder := v.newDecoder()
+	img, err := v.renderTracks(decoder.Tracks(), func(first, last int, fn func(encoder.DecodedTrack) error) error {
+		return decoder.DecodeTracks(r, size, first, last, fn)
+	}, burned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode track: %w", err)
+	}
+	return img, nil
+}
+
+// trackSource calls fn with the palette levels of the spiral tracks
+// first..last-1. It may leave out tracks.
+type trackSource func(first, last int, fn func(encoder.DecodedTrack) error) error
+
+// renderTracks draws the tracks of the spiral laid out as tracks, with the
+// levels read from source
+func (v *TrackVisualizer) renderTracks(tracks []encoder.TrackInfo, source trackSource, burned []uint32) (*image.Gray, error) {
+	n := v.size
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	g := ringGeometry{
+		center: float64(n) / 2,
+		scale:  float64(n) / 2 / discOuterRadius,
+	}
+	g.pixel = 1 / g.scale
+
+	v.drawBackground(img, g)
+
+	if len(tracks) == 0 {
+		return img, nil
+	}
+
+	// A pixel belongs to the ring its center lies in. Its samples come from
+	// tracks less than a pixel away, so neighbouring rings overlap in the
+	// tracks they decode but never write the same pixel.
+	inner := tracks[0].Radius - g.pixel
+	outer := tracks[len(tracks)-1].Radius + g.pixel
+	width := (outer - inner) / float64(8*v.numWorkers)
+	width = min(max(width, 16*g.pixel), 32*g.pixel)
+	rings := int(math.Ceil((outer - inner) / width))
+
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		done     int
+	)
+	for w := 0; w < v.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ring := range jobs {
+				from := inner + float64(ring)*width
+				err := v.renderRing(img, burned, g, tracks, source, from, from+width)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				done++
+				fmt.Printf("\rRendered %d of %d rings", done, rings)
+				mu.Unlock()
+			}
+		}()
+	}
+	for ring := 0; ring < rings; ring++ {
+		jobs <- ring
+	}
+	close(jobs)
+	wg.Wait()
+	fmt.Println()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return img, nil
+}
+
+// newDecoder returns a decoder for the spiral the track was converted with
+func (v *TrackVisualizer) newDecoder() *encoder.TrackDecoder {
+	return encoder.NewTrackDecoder(encoder.Options{
+		Tr0:      v.tr0,
+		Dtr:      v.dtr,
+		R0:       v.r0,
+		DiscType: v.discType,
+		Palette:  v.palette,
+	})
+}
+
+// ringGeometry maps disc coordinates in mm to image pixels
+type ringGeometry struct {
+	center float64 // Image center in pixels
+	scale  float64 // Pixels per mm
+	pixel  float64 // Size of a pixel in mm
+}
+
+// radius returns the distance of the center of pixel (x, y) from the disc
+// center in mm
+func (g ringGeometry) radius(x, y int) float64 {
+	dx := float64(x) + 0.5 - g.center
+	dy := float64(y) + 0.5 - g.center
+	return math.Sqrt(dx*dx+dy*dy) / g.scale
+}
+
+// drawBackground paints the hole, the unwritten surface and the area
+// outside the disc
+func (v *TrackVisualizer) drawBackground(img *image.Gray, g ringGeometry) {
+	n := img.Bounds().Dx()
+	rows := make(chan int, n)
+	for y := 0; y < n; y++ {
+		rows <- y
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for w := 0; w < v.numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				row := img.Pix[y*img.Stride : y*img.Stride+n]
+				for x := range row {
+					switch d := g.radius(x, y); {
+					case d < discHoleRadius:
+						row[x] = grayHole
+					case d > discOuterRadius:
+						row[x] = grayOutside
+					default:
+						row[x] = graySurface
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// renderRing draws the pixels whose centers lie between the radii from and
+// to (mm)
+func (v *TrackVisualizer) renderRing(img *image.Gray, burned []uint32, g ringGeometry, tracks []encoder.TrackInfo, source trackSource, from, to float64) error {
+	first := sort.Search(len(tracks), func(i int) bool { return tracks[i].Radius >= from-g.pixel })
+	last := sort.Search(len(tracks), func(i int) bool { return tracks[i].Radius >= to+g.pixel })
+	if first >= last {
+		return nil
+	}
+
+	acc := newRingAccumulator(img.Bounds().Dx(), g.center, from*g.scale-1, to*g.scale+1, burned != nil)
+	err := source(first, last, func(t encoder.DecodedTrack) error {
+		offset := tracks[t.Index].Offset
+		rp := t.Radius * g.scale
+		step := 2 * math.Pi / float64(len(t.Levels))
+		for i, level := range t.Levels {
+			if level == encoder.LevelUnknown {
+				continue
+			}
+			sin, cos := math.Sincos(step * float64(i))
+			x := int(math.Floor(g.center + rp*cos))
+			y := int(math.Floor(g.center + rp*sin))
+			if k := acc.index(x, y); k >= 0 {
+				acc.sum[k] += uint32(v.palette.Gray(level))
+				acc.count[k]++
+				if acc.burned != nil {
+					sector := uint32((offset + int64(i)) / encoder.SectorSize)
+					acc.burned[k] = min(acc.burned[k], sector)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	acc.each(func(x, y, k int) {
+		if acc.count[k] == 0 {
+			return
+		}
+		if d := g.radius(x, y); d < from || d >= to {
+			return
+		}
+		img.Pix[y*img.Stride+x] = uint8((acc.sum[k] + acc.count[k]/2) / acc.count[k])
+		if burned != nil {
+			burned[y*img.Stride+x] = acc.burned[k]
+		}
+	})
+	return nil
+}
+
+// ringAccumulator sums the samples falling on the pixels of an annulus.
+// Each image row crossing the annulus holds at most two runs of pixels,
+// stored one after the other.
+type ringAccumulator struct {
+	y0     int
+	rows   []ringRow
+	sum    []uint32
+	count  []uint32
+	burned []uint32 // First sector written to the pixel, if tracked
+}
+
+// ringRow holds the pixel runs [x0, x1) and [x2, x3) of a row, starting at
+// off0 and off1 in the accumulator
+type ringRow struct {
+	x0, x1, x2, x3 int
+	off0, off1     int
+}
+
+// newRingAccumulator covers every pixel of an n x n image whose center lies
+// in the annulus between radii inner and outer (pixels) around
+// (center, center)
+func newRingAccumulator(n int, center, inner, outer float64, trackBurned bool) *ringAccumulator {
+	inner = max(inner, 0)
+	acc := &ringAccumulator{
+		y0: max(int(math.Floor(center-outer)), 0),
+	}
+	y1 := min(int(math.Ceil(center+outer)), n)
+	clamp := func(x int) int { return min(max(x, 0), n) }
+
+	off := 0
+	for y := acc.y0; y < y1; y++ {
+		// Nearest and farthest vertical distance of the row from the center
+		top, bottom := math.Abs(float64(y)-center), math.Abs(float64(y+1)-center)
+		near, far := min(top, bottom), max(top, bottom)
+		if float64(y) <= center && center <= float64(y+1) {
+			near = 0
+		}
+
+		var row ringRow
+		if near < outer {
+			wo := math.Sqrt(outer*outer - near*near)
+			wi := 0.0
+			if inner > far {
+				wi = math.Sqrt(inner*inner - far*far)
+			}
+
+			row.x0 = clamp(int(math.Floor(center-wo)) - 1)
+			row.x1 = clamp(int(math.Ceil(center-wi)) + 1)
+			row.x2 = clamp(int(math.Floor(center+wi)) - 1)
+			row.x3 = clamp(int(math.Ceil(center+wo)) + 1)
+			if row.x1 >= row.x2 {
+				row.x1, row.x2 = row.x3, row.x3
+			}
+			row.off0 = off
+			off += row.x1 - row.x0
+			row.off1 = off
+			off += row.x3 - row.x2
+		}
+		acc.rows = append(acc.rows, row)
+	}
+
+	acc.sum = make([]uint32, off)
+	acc.count = make([]uint32, off)
+	if trackBurned {
+		acc.burned = make([]uint32, off)
+		for i := range acc.burned {
+			acc.burned[i] = neverBurned
+		}
+	}
+	return acc
+}
+
+// index returns the accumulator index of pixel (x, y), or -1 if the pixel
+// is not part of the annulus
+func (acc *ringAccumulator) index(x, y int) int {
+	y -= acc.y0
+	if y < 0 || y >= len(acc.rows) {
+		return -1
+	}
+	row := &acc.rows[y]
+	switch {
+	case x >= row.x0 && x < row.x1:
+		return row.off0 + x - row.x0
+	case x >= row.x2 && x < row.x3:
+		return row.off1 + x - row.x2
+	}
+	return -1
+}
+
+// each calls fn for every pixel of the annulus with its accumulator index
+func (acc *ringAccumulator) each(fn func(x, y, k int)) {
+	for i, row := range acc.rows {
+		y := acc.y0 + i
+		for x := row.x0; x < row.x1; x++ {
+			fn(x, y, row.off0+x-row.x0)
+		}
+		for x := row.x2; x < row.x3; x++ {
+			fn(x, y, row.off1+x-row.x2)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package preview
+
+import (
+	"math"
+	"testing"
+
+	"cdimage/encoder"
+)
+
+// testTracks returns a source of spiral tracks at the given radii, track i
+// holding the levels of levels(i, sample, samples)
+func testTracks(radii []float64, samples int, levels func(track, i, n int) uint8) ([]encoder.TrackInfo, trackSource) {
+	tracks := make([]encoder.TrackInfo, len(radii))
+	for i, r := range radii {
+		tracks[i] = encoder.TrackInfo{Index: i, Radius: r, Offset: int64(i * samples), Samples: samples}
+	}
+	source := func(first, last int, fn func(encoder.DecodedTrack) error) error {
+		for i := first; i < last; i++ {
+			t := encoder.DecodedTrack{Index: i, Radius: radii[i], Levels: make([]uint8, samples)}
+			for j := range t.Levels {
+				t.Levels[j] = levels(i, j, samples)
+			}
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return tracks, source
+}
+
+func TestRenderTracks(t *testing.T) {
+	const size = 240
+	v := NewTrackVisualizer(22000, 1.4, 25, "cd")
+	if err := v.SetSize(size); err != nil {
+		t.Fatal(err)
+	}
+	v.SetNumWorkers(3)
+
+	// The inner track is bright all round, the outer one dark in its first
+	// half turn, which runs clockwise from the right through the bottom
+	tracks, source := testTracks([]float64{20, 40}, 4000, func(track, i, n int) uint8 {
+		if track == 1 && i < n/2 {
+			return 0
+		}
+		return 3
+	})
+	img, err := v.renderTracks(tracks, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scale := float64(size) / 2 / discOuterRadius
+	at := func(r, angle float64) uint8 {
+		sin, cos := math.Sincos(angle)
+		x := int(math.Floor(size/2 + r*scale*cos))
+		y := int(math.Floor(size/2 + r*scale*sin))
+		return img.GrayAt(x, y).Y
+	}
+
+	tests := []struct {
+		r, angle float64
+		want     uint8
+	}{
+		{20, 0, 255},
+		{20, math.Pi / 2, 255},
+		{20, 4, 255},
+		{40, 0.3, 0},
+		{40, math.Pi / 2, 0},
+		{40, math.Pi + 0.3, 255},
+		{40, 3 * math.Pi / 2, 255},
+		{30, 1, graySurface},
+		{50, 2, graySurface},
+		{3, 0, grayHole},
+		{59, math.Pi / 4, graySurface},
+		{61, math.Pi / 4, grayOutside},
+	}
+	for _, tt := range tests {
+		if got := at(tt.r, tt.angle); got != tt.want {
+			t.Errorf("%g mm at %.2f rad: gray %d, expected %d", tt.r, tt.angle, got, tt.want)
+		}
+	}
+}
+
+// TestRenderTracksRings checks that the rings render every pixel with
+// samples exactly once, for any number of workers: a spiral of tracks a
+// pixel apart must leave no pixel of the written area unset
+func TestRenderTracksRings(t *testing.T) {
+	const size = 300
+	scale := float64(size) / 2 / discOuterRadius
+	var radii []float64
+	for r := 20.0; r <= 50; r += 0.5 / scale {
+		radii = append(radii, r)
+	}
+	tracks, source := testTracks(radii, 3000, func(track, i, n int) uint8 { return 3 })
+
+	for _, workers := range []int{1, 2, 7} {
+		v := NewTrackVisualizer(22000, 1.4, 25, "cd")
+		v.SetSize(size)
+		v.SetNumWorkers(workers)
+		img, err := v.renderTracks(tracks, source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := ringGeometry{center: size / 2, scale: scale}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if d := g.radius(x, y); d > 20.5 && d < 49.5 && img.GrayAt(x, y).Y != 255 {
+					t.Fatalf("%d workers: pixel %d,%d at %.2f mm not rendered", workers, x, y, d)
+				}
+			}
+		}
+	}
+}
+
+// TestRingAccumulator checks that an accumulator holds every pixel whose
+// center lies in its annulus, each at its own index, and that the rings
+// renderTracks splits the disc into cover every pixel exactly once
+func TestRingAccumulator(t *testing.T) {
+	const n = 101
+	for _, center := range []float64{50.5, 50} {
+		g := ringGeometry{center: center, scale: 1}
+		for _, ring := range [][2]float64{{0, 10}, {10, 10.7}, {20, 36}, {40, 80}} {
+			inner, outer := ring[0], ring[1]
+			acc := newRingAccumulator(n, center, inner, outer, false)
+
+			seen := make([]bool, len(acc.sum))
+			acc.each(func(x, y, k int) {
+				if acc.index(x, y) != k {
+					t.Fatalf("pixel %d,%d listed at %d, index %d", x, y, k, acc.index(x, y))
+				}
+				if seen[k] {
+					t.Fatalf("index %d used twice", k)
+				}
+				seen[k] = true
+			})
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					if d := g.radius(x, y); d >= inner && d < outer && acc.index(x, y) < 0 {
+						t.Fatalf("ring %g-%g around %g: pixel %d,%d at %.2f missing", inner, outer, center, x, y, d)
+					}
+				}
+			}
+		}
+	}
+
+	// Adjacent rings neither overlap nor leave gaps
+	const width = 7.3
+	g := ringGeometry{center: n / 2, scale: 1}
+	owners := make([]int, n*n)
+	for ring := 0; ring < 8; ring++ {
+		from := 1 + float64(ring)*width
+		acc := newRingAccumulator(n, g.center, from-1, from+width+1, false)
+		acc.each(func(x, y, k int) {
+			if d := g.radius(x, y); d >= from && d < from+width {
+				owners[y*n+x]++
+			}
+		})
+	}
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			d := g.radius(x, y)
+			if want := d >= 1 && d < 1+8*width; want != (owners[y*n+x] == 1) || owners[y*n+x] > 1 {
+				t.Fatalf("pixel %d,%d at %.2f is in %d rings", x, y, d, owners[y*n+x])
+			}
+		}
+	}
+}
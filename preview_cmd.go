@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cdimage/encoder"
+	"cdimage/presets"
+	"cdimage/preview"
+)
+
+// previewOptions holds the settings of the preview command
+type previewOptions struct {
+	InputFile  string
+	OutputFile string
+	DiscType   string
+	Tr0        float64
+	Dtr        float64
+	R0         float64
+	Dither     ditherOptions
+	Sampling   samplingOptions
+	Placement  placementOptions
+	Palette    string
+	Preset     string
+	Size       int
+	Density    float64 // Fraction of the spiral tracks to simulate, 0 for automatic
+	Look       appearanceOptions
+}
+
+// previewImage predicts the disc appearance of an image without converting it
+func previewImage(opts previewOptions) error {
+	// Validate disc type
+	opts.DiscType = strings.ToLower(opts.DiscType)
+	if opts.DiscType != "cd" && opts.DiscType != "dvd" {
+		return fmt.Errorf("invalid disc type: %s (must be 'cd' or 'dvd')", opts.DiscType)
+	}
+
+	// Determine parameters the same way as burn
+	geometry, err := resolveGeometry(opts.DiscType, opts.Preset, opts.Tr0, opts.Dtr, opts.R0)
+	if err != nil {
+		return err
+	}
+	opts.Tr0, opts.Dtr, opts.R0 = geometry.Tr0, geometry.Dtr, geometry.R0
+
+	fmt.Printf("Parameters - tr0: %.2f, dtr: %.6f, r0: %.1f\n", opts.Tr0, opts.Dtr, opts.R0)
+	ditherEngine, err := opts.Dither.engine()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dithering: %s\n", opts.Dither)
+	samplingFilter, err := opts.Sampling.filter()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sampling: %s\n", opts.Sampling)
+	placement, err := opts.Placement.placement()
+	if err != nil {
+		return err
+	}
+	palette, err := resolvePalette(opts.Palette, geometry)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Palette: %d levels (%s)\n", palette.Len(), palette)
+
+	look, err := opts.Look.appearance()
+	if err != nil {
+		return err
+	}
+	overlay, err := parseOverlay(opts.Look.Annotate, geometry.Name)
+	if err != nil {
+		return err
+	}
+
+	visualizer := preview.NewTrackVisualizer(opts.Tr0, opts.Dtr, opts.R0, opts.DiscType)
+	if err := visualizer.SetSize(opts.Size); err != nil {
+		return err
+	}
+	visualizer.SetPalette(palette)
+	visualizer.SetAppearance(look)
+	visualizer.SetOverlay(overlay)
+
+	// Load image
+	discImg, err := opts.Placement.loadImage(opts.InputFile, opts.DiscType, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	// Handle Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	render := encoder.Options{
+		Dither:     ditherEngine,
+		Seed:       opts.Dither.Seed,
+		Screen:     opts.Dither.Screen,
+		Sampling:   samplingFilter,
+		AreaFilter: opts.Sampling.Area,
+		Placement:  placement,
+	}
+	if err := visualizer.PreviewImage(ctx, discImg, render, opts.Density, opts.OutputFile); err != nil {
+		return fmt.Errorf("preview failed: %w", err)
+	}
+
+	fmt.Printf("\n✓ Preview completed in %v\n", time.Since(start).Round(time.Millisecond))
+	fmt.Printf("✓ Open %s to see how the image will look on the disc\n", opts.OutputFile)
+	return nil
+}
+
+// resolveGeometry returns the geometry burn would use: the preset if given,
+// else the explicit tr0/dtr/r0 with an empty name, or the default preset of
+// the disc type if tr0 or dtr is 0
+func resolveGeometry(discType, preset string, tr0, dtr, r0 float64) (presets.DiscPreset, error) {
+	if preset != "" {
+		discPreset, exists := presets.GetPresetByName(preset)
+		if !exists {
+			return presets.DiscPreset{}, fmt.Errorf("preset '%s' not found (use 'cdimage list-presets' to see available presets)", preset)
+		}
+		if discPreset.DiscType != discType {
+			return presets.DiscPreset{}, fmt.Errorf("preset '%s' is for %s, but disc type is %s", preset, discPreset.DiscType, discType)
+		}
+		fmt.Printf("Using preset: %s\n", discPreset.Name)
+		return discPreset, nil
+	}
+	if tr0 == 0 || dtr == 0 {
+		discPreset := presets.GetDefaultPreset(discType)
+		fmt.Printf("Using default preset for %s: %s\n", strings.ToUpper(discType), discPreset.Name)
+		return discPreset, nil
+	}
+	return presets.DiscPreset{DiscType: discType, Tr0: tr0, Dtr: dtr, R0: r0}, nil
+}
@@ -4,10 +4,97 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"cdimage/presets"
+	"cdimage/preview"
+	"github.com/spf13/cobra"
 )
 
+// appearanceOptions holds the flags that color and annotate visualize and
+// preview output
+type appearanceOptions struct {
+	Dye            string
+	LightAngle     float64
+	LightElevation float64
+	Annotate       []string
+}
+
+// addAppearanceFlags registers the appearance flags of cmd
+func addAppearanceFlags(cmd *cobra.Command, opts *appearanceOptions) {
+	cmd.Flags().StringVar(&opts.Dye, "dye", "", "Color the PNG like a disc with this recording layer: "+strings.Join(preview.DyeNames(), ", ")+" (gray levels if empty)")
+	cmd.Flags().Float64Var(&opts.LightAngle, "light-angle", preview.DefaultLightAzimuth, "Direction of the light in degrees, counterclockwise from the right")
+	cmd.Flags().Float64Var(&opts.LightElevation, "light-elevation", preview.DefaultLightElevation, "Angle of the light above the disc in degrees (90 for straight above)")
+	cmd.Flags().StringSliceVar(&opts.Annotate, "annotate", nil, "Draw annotations on the PNG: all or any of "+strings.Join(preview.OverlayItems, ", "))
+}
+
+// parseOverlay returns the overlay with the annotations named by the
+// --annotate flag, titled with the preset name, or nil for none
+func parseOverlay(items []string, title string) (*preview.Overlay, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	overlay, err := preview.ParseOverlay(items)
+	if err != nil {
+		return nil, err
+	}
+	overlay.Title = title
+	return &overlay, nil
+}
+
+// appearance returns the selected appearance, or nil for gray levels
+func (opts appearanceOptions) appearance() (*preview.Appearance, error) {
+	if opts.Dye == "" {
+		return nil, nil
+	}
+	dye, ok := preview.DyeByName(strings.ToLower(opts.Dye))
+	if !ok {
+		return nil, fmt.Errorf("unknown dye '%s' (must be one of: %s)", opts.Dye, strings.Join(preview.DyeNames(), ", "))
+	}
+	if opts.LightElevation <= 0 || opts.LightElevation > 90 {
+		return nil, fmt.Errorf("light elevation must be between 0 and 90 degrees")
+	}
+	return &preview.Appearance{
+		Dye: dye,
+		Light: preview.Lighting{
+			Azimuth:   opts.LightAngle,
+			Elevation: opts.LightElevation,
+		},
+	}, nil
+}
+
+// geometrySweep holds the parameter ranges of a contact sheet. A parameter
+// without a range keeps its single value.
+type geometrySweep struct {
+	Tr0 *preview.Sweep
+	Dtr *preview.Sweep
+}
+
+// parse parses the sweep flags, either of which may be empty
+func (s *geometrySweep) parse(tr0, dtr string) error {
+	if tr0 != "" {
+		sweep, err := preview.ParseSweep(tr0)
+		if err != nil {
+			return fmt.Errorf("--sweep-tr0: %w", err)
+		}
+		s.Tr0 = &sweep
+	}
+	if dtr != "" {
+		sweep, err := preview.ParseSweep(dtr)
+		if err != nil {
+			return fmt.Errorf("--sweep-dtr: %w", err)
+		}
+		s.Dtr = &sweep
+	}
+	return nil
+}
+
+// active reports whether a contact sheet is requested
+func (s geometrySweep) active() bool {
+	return s.Tr0 != nil || s.Dtr != nil
+}
+
 // visualizeTrack creates a visual representation of a raw track file
-func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float64, preset string) error {
+func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float64, preset, paletteSpec string, size int, format string, anim preview.BurnAnimation, lookOpts appearanceOptions, sweep geometrySweep, cueTracks []int) error {
 	// Validate input file
 	if trackFile == "" {
 		return fmt.Errorf("track file is required")
@@ -19,9 +106,38 @@ func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float6
 		return fmt.Errorf("disc type must be 'cd' or 'dvd'")
 	}
 
+	// Validate output format
+	switch format {
+	case preview.FormatPNG, preview.FormatDeepZoom, preview.FormatGIF, preview.FormatAPNG:
+	default:
+		return fmt.Errorf("output format must be one of: %s, %s, %s, %s",
+			preview.FormatPNG, preview.FormatDeepZoom, preview.FormatGIF, preview.FormatAPNG)
+	}
+
+	look, err := lookOpts.appearance()
+	if err != nil {
+		return err
+	}
+	if look != nil && format != preview.FormatPNG {
+		return fmt.Errorf("--dye is only supported for png output")
+	}
+	if len(lookOpts.Annotate) > 0 && format != preview.FormatPNG {
+		return fmt.Errorf("--annotate is only supported for png output")
+	}
+	if sweep.active() {
+		if format != preview.FormatPNG {
+			return fmt.Errorf("parameter sweeps are only supported for png output")
+		}
+		if look != nil || len(lookOpts.Annotate) > 0 {
+			return fmt.Errorf("--dye and --annotate are not supported with parameter sweeps")
+		}
+	}
+
 	// Use preset if specified
+	var title string
+	var discPreset presets.DiscPreset // Preset of the palette, none for custom geometry
 	if preset != "" {
-		presetData, exists := GetPresetByName(preset)
+		presetData, exists := presets.GetPresetByName(preset)
 		if !exists {
 			return fmt.Errorf("preset '%s' not found. Use 'list-presets' to see available presets", preset)
 		}
@@ -35,10 +151,12 @@ func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float6
 		}
 		
 		fmt.Printf("Using preset: %s (%s)\n", preset, presetData.Name)
+		title = presetData.Name
+		discPreset = presetData
 	} else {
 		// Use default values for disc type if no preset specified
 		if tr0 == 0 || dtr == 0 {
-			defaultPresets := GetPresets()
+			defaultPresets := presets.GetPresets()
 			var defaultKey string
 			
 			if discType == "cd" {
@@ -55,6 +173,8 @@ func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float6
 					dtr = defaultPreset.Dtr
 				}
 				fmt.Printf("Using default %s preset: %s\n", strings.ToUpper(discType), defaultKey)
+				title = defaultPreset.Name
+				discPreset = defaultPreset
 			}
 		}
 	}
@@ -64,22 +184,77 @@ func visualizeTrack(trackFile, outputImage, discType string, tr0, dtr, r0 float6
 		return fmt.Errorf("invalid parameters: tr0=%.2f, dtr=%.6f, r0=%.1f (all must be > 0)", tr0, dtr, r0)
 	}
 
+	palette, err := resolvePalette(paletteSpec, discPreset)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("Visualization parameters:\n")
 	fmt.Printf("  Track file: %s\n", trackFile)
+	if len(cueTracks) > 0 {
+		fmt.Printf("  Cue tracks: %s\n", strings.Trim(fmt.Sprint(cueTracks), "[]"))
+	}
 	fmt.Printf("  Output image: %s\n", outputImage)
 	fmt.Printf("  Disc type: %s\n", strings.ToUpper(discType))
 	fmt.Printf("  TR0: %s\n", formatFloat(tr0))
 	fmt.Printf("  DTR: %s\n", formatFloat(dtr))
 	fmt.Printf("  R0: %s\n", formatFloat(r0))
+	fmt.Printf("  Palette: %d levels (%s)\n", palette.Len(), palette)
+	if sweep.Tr0 != nil {
+		fmt.Printf("  TR0 sweep: %s to %s in %d steps\n", formatFloat(sweep.Tr0.From), formatFloat(sweep.Tr0.To), sweep.Tr0.Steps)
+	}
+	if sweep.Dtr != nil {
+		fmt.Printf("  DTR sweep: %s to %s in %d steps\n", formatFloat(sweep.Dtr.From), formatFloat(sweep.Dtr.To), sweep.Dtr.Steps)
+	}
+	if sweep.active() {
+		fmt.Printf("  Cell size: %dx%d\n", size, size)
+	} else {
+		fmt.Printf("  Image size: %dx%d\n", size, size)
+	}
+	fmt.Printf("  Format: %s\n", format)
+	if look != nil {
+		fmt.Printf("  Dye: %s, light at %g° azimuth, %g° elevation\n", look.Dye.Name, look.Light.Azimuth, look.Light.Elevation)
+	}
+	if format == preview.FormatGIF || format == preview.FormatAPNG {
+		fmt.Printf("  Write speed: %gx\n", anim.Speed)
+	}
 	fmt.Printf("\n")
 
 	// Create visualizer and generate the image
-	visualizer := NewTrackVisualizer(tr0, dtr, r0, discType)
+	visualizer := preview.NewTrackVisualizer(tr0, dtr, r0, discType)
+	if err := visualizer.SetSize(size); err != nil {
+		return err
+	}
+	visualizer.SetPalette(palette)
+	visualizer.SetAppearance(look)
+	overlay, err := parseOverlay(lookOpts.Annotate, title)
+	if err != nil {
+		return err
+	}
+	visualizer.SetOverlay(overlay)
+	visualizer.SetCueTracks(cueTracks)
 	
 	fmt.Println("Reading track data and creating visualization...")
 	fmt.Println("This may take a few minutes for large tracks...")
 	
-	err := visualizer.VisualizeTrack(trackFile, outputImage)
+	switch {
+	case sweep.active():
+		tr0s := preview.Sweep{From: tr0, To: tr0, Steps: 1}
+		if sweep.Tr0 != nil {
+			tr0s = *sweep.Tr0
+		}
+		dtrs := preview.Sweep{From: dtr, To: dtr, Steps: 1}
+		if sweep.Dtr != nil {
+			dtrs = *sweep.Dtr
+		}
+		err = visualizer.VisualizeSweep(trackFile, outputImage, tr0s, dtrs)
+	case format == preview.FormatDeepZoom:
+		err = visualizer.VisualizeDeepZoom(trackFile, outputImage)
+	case format == preview.FormatGIF, format == preview.FormatAPNG:
+		err = visualizer.VisualizeBurn(trackFile, outputImage, anim)
+	default:
+		err = visualizer.VisualizeTrack(trackFile, outputImage)
+	}
 	if err != nil {
 		return fmt.Errorf("visualization failed: %w", err)
 	}